@@ -0,0 +1,172 @@
+package aggregator
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	"github.com/mae-pax/consul-loadbalancer/util"
+)
+
+// LOCK_SUFFIX is appended to the zone cpu key to form the leader lock key.
+const LOCK_SUFFIX = ".lock"
+
+// Aggregator reads the InstanceFactor document, averages the cpu of the
+// instances of each zone and writes the ZoneCPUUtilizationRatio document
+// every interval. Any number of aggregators may run; a consul session lock
+// elects the one that writes.
+type Aggregator struct {
+	client            *api.Client
+	instanceFactorKey string
+	zoneCPUKey        string
+	interval          time.Duration
+	logger            util.Logger
+	mutex             sync.Mutex
+	leader            bool
+	stop              chan struct{}
+	wg                sync.WaitGroup
+}
+
+func NewAggregator(client *api.Client, instanceFactorKey, zoneCPUKey string, interval time.Duration) *Aggregator {
+	return &Aggregator{
+		client:            client,
+		instanceFactorKey: instanceFactorKey,
+		zoneCPUKey:        zoneCPUKey,
+		interval:          interval,
+	}
+}
+
+func (a *Aggregator) SetLogger(logger util.Logger) {
+	a.logger = logger
+}
+
+// IsLeader reports whether this aggregator currently holds the lock.
+func (a *Aggregator) IsLeader() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.leader
+}
+
+func (a *Aggregator) setLeader(leader bool) {
+	a.mutex.Lock()
+	a.leader = leader
+	a.mutex.Unlock()
+}
+
+func (a *Aggregator) Start() error {
+	lock, err := a.client.LockKey(a.zoneCPUKey + LOCK_SUFFIX)
+	if err != nil {
+		return err
+	}
+	a.stop = make(chan struct{})
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		for {
+			lost, err := lock.Lock(a.stop)
+			if err != nil {
+				a.warnf("acquire lock failed. key: [%s], err: [%v]", a.zoneCPUKey+LOCK_SUFFIX, err)
+				select {
+				case <-time.After(a.interval):
+					continue
+				case <-a.stop:
+					return
+				}
+			}
+			if lost == nil {
+				// stopped while waiting
+				return
+			}
+			a.setLeader(true)
+			a.lead(lost)
+			a.setLeader(false)
+			lock.Unlock()
+			select {
+			case <-a.stop:
+				return
+			default:
+			}
+		}
+	}()
+	return nil
+}
+
+func (a *Aggregator) lead(lost <-chan struct{}) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		if err := a.Aggregate(); err != nil {
+			a.warnf("aggregate zone cpu failed. key: [%s], err: [%v]", a.zoneCPUKey, err)
+		}
+		select {
+		case <-ticker.C:
+		case <-lost:
+			return
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+func (a *Aggregator) Stop() {
+	if a.stop == nil {
+		return
+	}
+	close(a.stop)
+	a.wg.Wait()
+	a.stop = nil
+}
+
+// Aggregate writes the zone cpu document once, regardless of leadership.
+func (a *Aggregator) Aggregate() error {
+	kv := a.client.KV()
+	pair, _, err := kv.Get(a.instanceFactorKey, nil)
+	if err != nil {
+		return err
+	}
+	var doc balancer.InstanceFactor
+	if pair != nil {
+		if err := json.Unmarshal(pair.Value, &doc); err != nil {
+			return err
+		}
+	}
+	value, err := json.Marshal(ZoneCPU(doc.Date, time.Now()))
+	if err != nil {
+		return err
+	}
+	_, err = kv.Put(&api.KVPair{Key: a.zoneCPUKey, Value: value}, nil)
+	return err
+}
+
+// ZoneCPU averages the cpu utilization of the instances of each zone.
+// Instances without a zone are ignored.
+func ZoneCPU(infos []balancer.InstanceMetaInfo, now time.Time) balancer.ZoneCPUUtilizationRatio {
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+	for _, info := range infos {
+		if info.Zone == "" {
+			continue
+		}
+		sums[info.Zone] += info.CPUUtilization
+		counts[info.Zone]++
+	}
+	zones := make([]string, 0, len(sums))
+	for zone := range sums {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	ratio := balancer.ZoneCPUUtilizationRatio{Updated: now.Unix(), Date: make([]map[string]float64, 0, len(zones))}
+	for _, zone := range zones {
+		ratio.Date = append(ratio.Date, map[string]float64{zone: sums[zone] / float64(counts[zone])})
+	}
+	return ratio
+}
+
+func (a *Aggregator) warnf(format string, v ...interface{}) {
+	if a.logger != nil {
+		a.logger.Warnf(format, v...)
+	}
+}
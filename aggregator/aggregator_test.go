@@ -0,0 +1,28 @@
+package aggregator_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mae-pax/consul-loadbalancer/aggregator"
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestZoneCPU(t *testing.T) {
+	Convey("Test ZoneCPU", t, func() {
+		now := time.Unix(1600000000, 0)
+		Convey("Given instances in two zones, ZoneCPU averages each zone", func() {
+			ratio := aggregator.ZoneCPU([]balancer.InstanceMetaInfo{
+				{InstanceID: "i-1", Zone: "a", CPUUtilization: 40},
+				{InstanceID: "i-2", Zone: "a", CPUUtilization: 60},
+				{InstanceID: "i-3", Zone: "b", CPUUtilization: 30},
+				{InstanceID: "i-4", CPUUtilization: 90},
+			}, now)
+			So(ratio.Updated, ShouldEqual, 1600000000)
+			So(len(ratio.Date), ShouldEqual, 2)
+			So(ratio.Date[0]["a"], ShouldEqual, 50)
+			So(ratio.Date[1]["b"], ShouldEqual, 30)
+		})
+	})
+}
@@ -0,0 +1,52 @@
+package balancer
+
+import (
+	"math"
+)
+
+// AdaptiveThreshold replaces the fixed RateThreshold with K standard
+// deviations of the node workloads around their zone workload, observed
+// every update cycle and bounded by Min and Max when they are positive.
+// The balanced band then follows the natural noise of each service
+// instead of one value tuned by hand. With fewer than two nodes or no
+// zone workload yet, RateThreshold applies.
+type AdaptiveThreshold struct {
+	K   float64 `json:"k"`
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// updateRateThreshold sets the rate threshold of this update cycle.
+func (r *ConsulResolver) updateRateThreshold() {
+	r.rateThreshold = r.onlineLab.RateThreshold
+	a := r.onlineLab.AdaptiveThreshold
+	if a == nil || a.K <= 0 || !r.zoneCPUUpdated {
+		return
+	}
+	var n int
+	var sum, sumSquares float64
+	for _, zone := range r.serviceZones {
+		for _, node := range zone.Nodes {
+			d := (node.WorkLoad - zone.WorkLoad) / 100.0
+			sum += d
+			sumSquares += d * d
+			n++
+		}
+	}
+	if n < 2 {
+		return
+	}
+	mean := sum / float64(n)
+	threshold := a.K * math.Sqrt(math.Max(0, sumSquares/float64(n)-mean*mean))
+	if a.Min > 0 {
+		threshold = math.Max(a.Min, threshold)
+	}
+	if a.Max > 0 {
+		threshold = math.Min(a.Max, threshold)
+	}
+	r.rateThreshold = threshold
+	r.logger.Debugf("service: %s, adaptive rateThreshold: %f over %d nodes", r.service, threshold, n)
+	if r.metricsSink != nil {
+		r.metricsSink.SetGauge("clb_rate_threshold", threshold, map[string]string{"service": r.service})
+	}
+}
@@ -0,0 +1,55 @@
+package balancer
+
+import (
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+// LearningAnnealing replaces the fixed LearningRate with one that restarts
+// at StartRate whenever the set of service nodes changes, e.g. after a
+// deploy, and is multiplied by Decay every update cycle down to MinRate,
+// or LearningRate when MinRate is 0. Learning converges fast after
+// topology changes without oscillating afterwards.
+type LearningAnnealing struct {
+	StartRate float64 `json:"startRate"`
+	MinRate   float64 `json:"minRate"`
+	Decay     float64 `json:"decay"`
+}
+
+// learningRate returns the learning rate of this update cycle.
+func (r *ConsulResolver) learningRate() float64 {
+	a := r.onlineLab.Annealing
+	if a == nil || a.StartRate <= 0 {
+		return r.onlineLab.LearningRate
+	}
+	floor := a.MinRate
+	if floor <= 0 {
+		floor = r.onlineLab.LearningRate
+	}
+	topology := r.topologyHash()
+	if topology != r.annealTopology || r.annealRate == 0 {
+		r.annealTopology = topology
+		r.annealRate = a.StartRate
+		r.logger.Debugf("topology changed, restart learningRate at %f", r.annealRate)
+	} else if a.Decay > 0 && a.Decay < 1 {
+		r.annealRate = math.Max(floor, r.annealRate*a.Decay)
+	}
+	return r.annealRate
+}
+
+func (r *ConsulResolver) topologyHash() uint64 {
+	var keys []string
+	for _, z := range r.serviceZones {
+		for _, node := range z.Nodes {
+			keys = append(keys, nodeKey(node))
+		}
+	}
+	sort.Strings(keys)
+	h := fnv.New64a()
+	for _, key := range keys {
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
@@ -0,0 +1,109 @@
+package balancer
+
+import (
+	"sync"
+	"time"
+)
+
+const BREAKER_RECOVERY_RATE = 0.3
+
+type circuitBreaker struct {
+	mutex        sync.Mutex
+	failures     int
+	coolDown     time.Duration
+	recoveryRate float64
+	counts       map[string]int
+	ejected      map[string]time.Time
+}
+
+// SetCircuitBreaker ejects a node from selection once ReportResult saw
+// failures consecutive errors from it. After coolDown it is admitted again
+// with its factor scaled by recoveryRate (BREAKER_RECOVERY_RATE when not
+// positive) for another coolDown. Cold nodes are never ejected. Zero
+// failures disables the breaker.
+func (r *ConsulResolver) SetCircuitBreaker(failures int, coolDown time.Duration, recoveryRate float64) {
+	if failures <= 0 {
+		r.breaker = nil
+		return
+	}
+	if recoveryRate <= 0 {
+		recoveryRate = BREAKER_RECOVERY_RATE
+	}
+	r.breaker = &circuitBreaker{
+		failures:     failures,
+		coolDown:     coolDown,
+		recoveryRate: recoveryRate,
+		counts:       make(map[string]int),
+		ejected:      make(map[string]time.Time),
+	}
+}
+
+func (b *circuitBreaker) report(key string, failed bool, now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !failed {
+		delete(b.counts, key)
+		return false
+	}
+	b.counts[key]++
+	if b.counts[key] < b.failures {
+		return false
+	}
+	delete(b.counts, key)
+	b.ejected[key] = now
+	return true
+}
+
+// rate returns the factor multiplier of key: 0 while ejected,
+// recoveryRate while recovering and 1 otherwise.
+func (b *circuitBreaker) rate(key string, now time.Time) float64 {
+	since, ok := b.ejected[key]
+	if !ok {
+		return 1
+	}
+	switch age := now.Sub(since); {
+	case age < b.coolDown:
+		return 0
+	case age < 2*b.coolDown:
+		return b.recoveryRate
+	}
+	delete(b.ejected, key)
+	return 1
+}
+
+func (r *ConsulResolver) reportBreaker(node *ServiceNode, err error) {
+	if r.breaker == nil || r.Cold(node) {
+		return
+	}
+	if r.breaker.report(nodeKey(node), err != nil, time.Now()) {
+		r.logger.Warnf("service: %s, node %s ejected after %d consecutive failures", r.service, nodeKey(node), r.breaker.failures)
+	}
+}
+
+// ejected must be called with r.mutex held.
+func (r *ConsulResolver) ejected(node *ServiceNode) bool {
+	if r.breaker == nil {
+		return false
+	}
+	r.breaker.mutex.Lock()
+	defer r.breaker.mutex.Unlock()
+	if len(r.breaker.ejected) == 0 {
+		return false
+	}
+	return r.breaker.rate(nodeKey(node), time.Now()) == 0
+}
+
+func (r *ConsulResolver) applyBreaker(pool *CandidatePool) *CandidatePool {
+	if r.breaker == nil {
+		return pool
+	}
+	r.breaker.mutex.Lock()
+	defer r.breaker.mutex.Unlock()
+	if len(r.breaker.ejected) == 0 {
+		return pool
+	}
+	now := time.Now()
+	return r.scalePool(pool, ADJUST_BREAKER, func(node *ServiceNode) float64 {
+		return r.breaker.rate(nodeKey(node), now)
+	})
+}
@@ -0,0 +1,63 @@
+package balancer
+
+import (
+	"context"
+)
+
+type callerKey struct{}
+
+// WithCaller returns a context labeling SelectNodeContext calls with
+// caller, e.g. the gateway route, for CallerMetrics.
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerMetrics counts the selections of one caller.
+type CallerMetrics struct {
+	SelectNum    int
+	CrossZoneNum int
+	NoNodeNum    int
+}
+
+func (r *ConsulResolver) countCaller(caller string, node *ServiceNode) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.callers == nil {
+		r.callers = make(map[string]*CallerMetrics)
+	}
+	m, ok := r.callers[caller]
+	if !ok {
+		m = &CallerMetrics{}
+		r.callers[caller] = m
+	}
+	if node == nil {
+		m.NoNodeNum++
+		return
+	}
+	m.SelectNum++
+	if node.Zone != r.zone {
+		m.CrossZoneNum++
+	}
+}
+
+// CallerMetrics returns a copy of the selection counters per caller label.
+func (r *ConsulResolver) CallerMetrics() map[string]CallerMetrics {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	out := make(map[string]CallerMetrics, len(r.callers))
+	for caller, m := range r.callers {
+		out[caller] = *m
+	}
+	return out
+}
+
+func (r *ConsulResolver) exportCallers() {
+	if r.metricsSink == nil {
+		return
+	}
+	for caller, m := range r.CallerMetrics() {
+		labels := map[string]string{"service": r.service, "caller": caller}
+		r.metricsSink.SetGauge("clb_caller_select_total", float64(m.SelectNum), labels)
+		r.metricsSink.SetGauge("clb_caller_cross_zone_total", float64(m.CrossZoneNum), labels)
+	}
+}
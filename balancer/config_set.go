@@ -0,0 +1,48 @@
+package balancer
+
+import (
+	"path"
+)
+
+// ConfigSetPointer is the document stored at the config set pointer key,
+// naming the config set currently in use, e.g. {"name": "v2"}.
+type ConfigSetPointer struct {
+	Name string `json:"name"`
+}
+
+// SetConfigSetKey makes the resolver read its cpuThreshold and onlineLab
+// documents from clb/<service>/sets/<name>/<configured key>, where name is
+// read from key, e.g. clb/as/sets/v2/clb/lab for the configured clb/lab.
+// Switching or rolling back is a single write to the pointer key.
+func (r *ConsulResolver) SetConfigSetKey(key string) {
+	r.configSetKey = key
+}
+
+func (r *ConsulResolver) updateConfigSet() error {
+	if r.configSetKey == "" {
+		return nil
+	}
+	var cs ConfigSetPointer
+	err := r.getKV(r.configSetKey, &cs)
+	if ue, ok := err.(*UpdateError); ok && ue.Class == ERROR_KEY_MISSING {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	if cs.Name != r.configSet {
+		r.logger.Infof("config set switched from %q to %q, key: %s", r.configSet, cs.Name, r.configSetKey)
+	}
+	r.configSet = cs.Name
+	return nil
+}
+
+// configKey maps a configured key into the active config set, keeping the
+// whole key so configured keys sharing a file name stay apart. Without an
+// active set the key is returned unchanged.
+func (r *ConsulResolver) configKey(key string) string {
+	if r.configSet == "" {
+		return key
+	}
+	return path.Join("clb", r.service, "sets", r.configSet, key)
+}
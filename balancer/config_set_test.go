@@ -0,0 +1,78 @@
+package balancer_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// countingSerializer decodes with encoding/json and counts the documents
+// it decoded.
+type countingSerializer struct {
+	mutex sync.Mutex
+	calls int
+}
+
+func (s *countingSerializer) Marshal(v interface{}) ([]byte, error) {
+	return balancer.StdSerializer.Marshal(v)
+}
+
+func (s *countingSerializer) Unmarshal(data []byte, v interface{}) error {
+	s.mutex.Lock()
+	s.calls++
+	s.mutex.Unlock()
+	return balancer.StdSerializer.Unmarshal(data, v)
+}
+
+func TestConfigSet(t *testing.T) {
+	Convey("Test ConfigSet", t, func() {
+		f, server := newFakeConsul()
+		defer server.Close()
+		lab := balancer.DefaultOnlineLab()
+		f.kv["clb/as/set"] = balancer.ConfigSetPointer{Name: "v2"}
+		f.kv["clb/as/sets/v2/clb/cpu"] = balancer.CPUThreshold{CThreshold: 70}
+		f.kv["clb/as/sets/v2/clb/lab"] = lab
+		r := newFakeResolver(server.URL)
+		r.SetConfigSetKey("clb/as/set")
+		cpuThreshold := func(r *balancer.ConsulResolver) float64 {
+			w := httptest.NewRecorder()
+			r.DebugHandler().ServeHTTP(w, httptest.NewRequest("GET", "/debug", nil))
+			var res balancer.DebugResponse
+			So(json.Unmarshal(w.Body.Bytes(), &res), ShouldBeNil)
+			return res.CPUThreshold
+		}
+
+		Convey("Given a config set, its documents are read", func() {
+			So(r.Start(), ShouldBeNil)
+			defer r.Stop()
+			So(cpuThreshold(r), ShouldEqual, 70)
+		})
+		Convey("Given configured keys sharing a file name, they read their own documents", func() {
+			f.kv["clb/as/sets/v2/cpu/conf"] = balancer.CPUThreshold{CThreshold: 80}
+			f.kv["clb/as/sets/v2/lab/conf"] = lab
+			config := api.DefaultConfig()
+			config.Address = strings.TrimPrefix(server.URL, "http://")
+			r, err := balancer.NewConsulResolverWithConfig("", config, "as", "cpu/conf", "clb/zone", "clb/factor", "lab/conf", time.Minute, time.Second)
+			So(err, ShouldBeNil)
+			r.SetZone("a")
+			r.SetConfigSetKey("clb/as/set")
+			So(r.Start(), ShouldBeNil)
+			defer r.Stop()
+			So(cpuThreshold(r), ShouldEqual, 80)
+		})
+		Convey("Given a serializer for the configured key, it decodes the document of the set", func() {
+			s := &countingSerializer{}
+			r.SetDocumentSerializer("clb/lab", s)
+			So(r.Start(), ShouldBeNil)
+			defer r.Stop()
+			So(s.calls, ShouldEqual, 1)
+		})
+	})
+}
@@ -2,7 +2,6 @@ package balancer
 
 import (
 	"errors"
-	"strconv"
 
 	"github.com/hashicorp/consul/api"
 )
@@ -47,15 +46,7 @@ func (c *ConsulClient) GetServiceNodes(service, tag string, passingOnly bool) ([
 	}
 	serviceNodes := make([]ServiceNode, len(entries))
 	for i, entry := range entries {
-		serviceNode := ServiceNode{}
-		serviceNode.Zone = entry.Service.Meta["zone"]
-		balanceFactor, _ := strconv.ParseFloat(entry.Service.Meta["balanceFactor"], 64)
-		serviceNode.BalanceFactor = balanceFactor
-		serviceNode.InstanceID = entry.Service.Meta["instanceID"]
-		serviceNode.PublicIP = entry.Service.Meta["publicIP"]
-		serviceNode.Host = entry.Service.Address
-		serviceNode.Port = entry.Service.Port
-		serviceNodes[i] = serviceNode
+		serviceNodes[i] = newServiceNode(entry)
 	}
 	return serviceNodes, nil
 }
@@ -1,13 +1,20 @@
 package balancer
 
 import (
+	"context"
+	"fmt"
 	"math"
+	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/consul/api"
-	jsoniter "github.com/json-iterator/go"
 	"github.com/mae-pax/consul-loadbalancer/util"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -27,25 +34,111 @@ type ConsulResolverBuilder struct {
 	ZoneCPUKey        string
 	InstanceFactorKey string
 	OnlineLabKey      string
+	ConfigSetKey      string
+	DiscoveryKey      string
 	Interval          time.Duration
 	Timeout           time.Duration
+	// Zone, if set, is the zone of the client. Otherwise it is found by
+	// ZoneProvider, or by util.CloudZone(Cloud, ZoneTimeout), and Build
+	// fails when it can't be.
+	Zone         string
+	ZoneProvider util.ZoneProvider
+	ZoneTimeout  time.Duration
+	// ACL and TLS settings, applied on top of Config or the default
+	// config. Scheme is "http" or "https".
+	Token     string
+	TokenFile string
+	CAFile    string
+	CertFile  string
+	KeyFile   string
+	Scheme    string
+	// Client, if set, is used as is and Address is ignored. Otherwise
+	// Config, if set, is used to create the client.
+	Client *api.Client
+	Config *api.Config
 }
 
 func (b *ConsulResolverBuilder) Build() (*ConsulResolver, error) {
-	return NewConsulResolver(b.Cloud, b.Address, b.Service, b.CPUThresholdKey, b.ZoneCPUKey, b.InstanceFactorKey, b.OnlineLabKey, b.Interval, b.Timeout)
+	var r *ConsulResolver
+	var err error
+	switch {
+	case b.Client != nil:
+		r = NewConsulResolverWithClient(b.Cloud, b.Client, b.Service, b.CPUThresholdKey, b.ZoneCPUKey, b.InstanceFactorKey, b.OnlineLabKey, b.Interval, b.Timeout)
+	default:
+		r, err = NewConsulResolverWithConfig(b.Cloud, b.config(), b.Service, b.CPUThresholdKey, b.ZoneCPUKey, b.InstanceFactorKey, b.OnlineLabKey, b.Interval, b.Timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.SetConfigSetKey(b.ConfigSetKey)
+	r.SetDiscoveryKey(b.DiscoveryKey)
+	if b.Zone != "" {
+		r.SetZone(b.Zone)
+		return r, nil
+	}
+	provider := b.ZoneProvider
+	if provider == nil {
+		provider = util.CloudZone(b.Cloud, b.ZoneTimeout)
+	}
+	if err = r.SetZoneProvider(provider); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (b *ConsulResolverBuilder) config() *api.Config {
+	config := api.DefaultConfig()
+	if b.Config != nil {
+		c := *b.Config
+		config = &c
+	} else {
+		config.Address = b.Address
+	}
+	if b.Token != "" {
+		config.Token = b.Token
+	}
+	if b.TokenFile != "" {
+		config.TokenFile = b.TokenFile
+	}
+	if b.Scheme != "" {
+		config.Scheme = b.Scheme
+	}
+	if b.CAFile != "" {
+		config.TLSConfig.CAFile = b.CAFile
+	}
+	if b.CertFile != "" {
+		config.TLSConfig.CertFile = b.CertFile
+	}
+	if b.KeyFile != "" {
+		config.TLSConfig.KeyFile = b.KeyFile
+	}
+	return config
 }
 
 func NewConsulResolver(cloud, address, service, cpuThresholdKey, zoneCPUKey, instanceFactorKey, onlineLabKey string, interval, timeout time.Duration, args ...string) (*ConsulResolver, error) {
 	config := api.DefaultConfig()
 	config.Address = address
+	return NewConsulResolverWithConfig(cloud, config, service, cpuThresholdKey, zoneCPUKey, instanceFactorKey, onlineLabKey, interval, timeout, args...)
+}
+
+// NewConsulResolverWithConfig creates the consul client from config, so
+// callers can set the ACL token, TLS, HTTP transport or namespace.
+func NewConsulResolverWithConfig(cloud string, config *api.Config, service, cpuThresholdKey, zoneCPUKey, instanceFactorKey, onlineLabKey string, interval, timeout time.Duration, args ...string) (*ConsulResolver, error) {
 	client, err := api.NewClient(config)
 	if err != nil {
 		return nil, err
 	}
+	r := NewConsulResolverWithClient(cloud, client, service, cpuThresholdKey, zoneCPUKey, instanceFactorKey, onlineLabKey, interval, timeout, args...)
+	r.address = config.Address
+	return r, nil
+}
 
+// NewConsulResolverWithClient uses a pre-built consul client. Unless set
+// with SetZone or SetZoneProvider, the zone is found by Start with
+// util.DefaultZoneProvider(cloud), and Start fails when it can't be.
+func NewConsulResolverWithClient(cloud string, client *api.Client, service, cpuThresholdKey, zoneCPUKey, instanceFactorKey, onlineLabKey string, interval, timeout time.Duration, args ...string) *ConsulResolver {
 	r := &ConsulResolver{
 		client:             client,
-		address:            address,
 		service:            service,
 		interval:           interval,
 		timeout:            timeout,
@@ -53,66 +146,241 @@ func NewConsulResolver(cloud, address, service, cpuThresholdKey, zoneCPUKey, ins
 		zoneCPUKey:         zoneCPUKey,
 		instanceFactorKey:  instanceFactorKey,
 		onlineLabKey:       onlineLabKey,
-		zone:               util.Zone(cloud),
-		done:               make(chan bool),
+		zoneProvider:       util.DefaultZoneProvider(cloud),
 		balanceFactorCache: make(map[string]float64),
+		errorCounts:        make(map[ErrorClass]int),
 	}
+	r.initLogger()
 	if len(args) != 0 {
 		r.k8sServiceKey = args[0]
 	}
+	r.instanceID, _ = os.Hostname()
 
-	return r, nil
+	return r
 }
 
 type ConsulResolver struct {
-	client             *api.Client
-	address            string
-	service            string
-	lastIndex          uint64
-	zone               string
-	candidatePool      *CandidatePool
-	localZone          *ServiceZone
-	serviceZones       []*ServiceZone
-	zoneCPUMap         map[string]float64
-	instanceFactorMap  map[string]float64
-	balanceFactorCache map[string]float64
-	interval           time.Duration
-	timeout            time.Duration
-	done               chan bool
-	cpuThreshold       float64
-	onlineLab          *OnlineLab
-	k8sServiceKey      string
-	cpuThresholdKey    string
-	instanceFactorKey  string
-	onlineLabKey       string
-	zoneCPUKey         string
-	metric             *ConsulResolverMetric
-	zoneCPUUpdated     bool
-	logger             util.Logger
-	watcherLogger      util.Logger
-	watcher            *util.Watch
+	client               *api.Client
+	address              string
+	service              string
+	lastIndex            uint64
+	noWait               bool
+	zone                 string
+	zoneProvider         util.ZoneProvider
+	candidatePool        *CandidatePool
+	localZone            *ServiceZone
+	serviceZones         []*ServiceZone
+	zoneCPUMap           map[string]float64
+	instanceFactorMap    map[string]float64
+	zoneNetworkMap       map[string]float64
+	balanceFactorCache   map[string]float64
+	interval             time.Duration
+	timeout              time.Duration
+	loopCancel           context.CancelFunc
+	loopDone             chan struct{}
+	lifecycleMutex       sync.Mutex
+	lifecycleCtx         context.Context
+	lifecycleCancel      context.CancelFunc
+	cpuThreshold         float64
+	onlineLab            *OnlineLab
+	k8sServiceKey        string
+	cpuThresholdKey      string
+	instanceFactorKey    string
+	onlineLabKey         string
+	zoneCPUKey           string
+	configSetKey         string
+	configSet            string
+	instanceID           string
+	metric               *ConsulResolverMetric
+	zoneCPUUpdated       bool
+	zoneCPUTime          int64
+	logger               util.Logger
+	logs                 util.SwapLogger
+	baseLogger           util.Logger
+	watcherLogs          util.SwapLogger
+	watcherLogger        util.Logger
+	learningLog          util.Logger
+	selectCache          *selectCache
+	weightedRandom       bool
+	invalidFactorPolicy  string
+	cordonKey            string
+	cordoned             map[string]bool
+	quarantineKey        string
+	quarantine           quarantine
+	tags                 []string
+	dnsFallbackHost      string
+	dnsFallbackPort      int
+	degraded             bool
+	datacenters          []string
+	remoteDCPenalty      float64
+	deployDrainRate      float64
+	deployProgress       int
+	deployProgressTime   time.Time
+	namespace            string
+	tracer               trace.Tracer
+	updateCtx            context.Context
+	selectDuration       metric.Float64Histogram
+	static               bool
+	lastError            error
+	lastErrorTime        time.Time
+	softRemovalGrace     time.Duration
+	softRemovalRate      float64
+	vanished             map[string]*vanishedNode
+	knownNodes           map[string]ServiceNode
+	softRemoved          map[string]bool
+	picker               NodePicker
+	callers              map[string]*CallerMetrics
+	maxStaleness         time.Duration
+	refreshMutex         sync.Mutex
+	refreshTried         time.Time
+	refreshErr           error
+	factorExportPrefix   string
+	factorExportInterval time.Duration
+	lastFactorExport     time.Time
+	factorExporting      int32
+	stateStore           StateStore
+	stateKey             string
+	stateSaveInterval    time.Duration
+	lastStateSave        time.Time
+	stateSaving          int32
+	sourceIntervals      SourceIntervals
+	sourceFetched        map[string]time.Time
+	fetchedNodes         []ServiceNode
+	selectionLogEvery    uint64
+	selectionLogCount    uint64
+	selectionLog         util.Logger
+	partialUpdates       bool
+	retryAttempts        int
+	retryBaseDelay       time.Duration
+	retryMaxDelay        time.Duration
+	circuitFailures      int
+	circuitCoolDown      time.Duration
+	manager              *ResolverManager
+	staticWeights        bool
+	stopped              bool
+	poolHistory          []PoolSnapshot
+	leastInFlight        bool
+	inflight             inflightCounters
+	feedback             *feedbackTracker
+	annealTopology       uint64
+	annealRate           float64
+	rateThreshold        float64
+	pipelineStats        *PipelineStats
+	preparedQuery        string
+	duplicatePolicy      string
+	savedPool            []ServiceNode
+	fallbackNodes        []ServiceNode
+	fallbackWindow       time.Duration
+	srvServer            string
+	srvDomain            string
+	settingsKey          string
+	settings             *ResolverSettings
+	strategy             string
+	liveInterval         time.Duration
+	hooks                eventHooks
+	errorHooks           []func(stage string, err error)
+	sourceStatus         map[string]SourceStatus
+	breaker              *circuitBreaker
+	prober               *healthProber
+	warmup               time.Duration
+	kvWatch              bool
+	factorCache          *FactorCache
+	kvWatchCancel        context.CancelFunc
+	kvMutex              sync.Mutex
+	kvCache              map[string]*api.KVPair
+	defaultFactor        float64
+	watcher              *util.Watch
+	redact               bool
+	redactLogger         *util.RedactLogger
+	observerPicker       NodePicker
+	mutex                sync.Mutex
+	frozen               bool
+	pendingPool          *CandidatePool
+	pinEnabled           bool
+	pinInstanceID        string
+	pinExpire            time.Time
+	errorMutex           sync.Mutex
+	errorCounts          map[ErrorClass]int
+	consecutiveFailures  int
+	traceSampleRate      float64
+	traces               *traceBuffer
+	updateMutex          sync.Mutex
+	streaming            bool
+	streamNodes          []ServiceNode
+	streamUpdate         time.Time
+	schedule             *LabSchedule
+	streamCancel         context.CancelFunc
+	subscribers          []*subscriber
+	poolIndex            uint64
+	running              bool
+	lastUpdate           time.Time
+	readinessMaxAge      time.Duration
+	hostID               string
+	rack                 string
+	slowMultiple         float64
+	latency              *latencyTracker
+	serializer           Serializer
+	documentSerializers  atomic.Value
+	serializerMutex      sync.Mutex
+	discoveryFormats     map[string]string
+	mirrorNext           uint64
+	fairMultiple         float64
+	fairWindow           int
+	metricsSink          MetricsSink
+	lastFactors          map[string]float64
+	generation           uint64
+	discoveryKey         string
+	formats              map[string]Serializer
+	requestDeadline      time.Duration
 }
 
 type ConsulResolverMetric struct {
 	candidatePoolSize int
 	crossZoneNum      int
 	selectNum         int
+	invalidFactorNum  int
 }
 
 type OnlineLab struct {
-	CrossZone         bool    `json:"crossZone"`
-	CrossZoneRate     float64 `json:"crossZoneRate"` // TODO
-	FactorCacheExpire int     `json:"factorCacheExpire"`
-	FactorStartRate   float64 `json:"factorStartRate"`
-	LearningRate      float64 `json:"learningRate"`
-	RateThreshold     float64 `json:"rateThreshold"`
+	CrossZone          bool                    `json:"crossZone"`
+	CrossZoneRate      float64                 `json:"crossZoneRate"` // TODO
+	FactorCacheExpire  int                     `json:"factorCacheExpire"`
+	FactorStartRate    float64                 `json:"factorStartRate"`
+	LearningRate       float64                 `json:"learningRate"`
+	RateThreshold      float64                 `json:"rateThreshold"`
+	Schedules          []LabSchedule           `json:"schedules"`
+	Rollout            *LabRollout             `json:"rollout"`
+	ZoneBounds         map[string]FactorBounds `json:"zoneBounds"`
+	Locality           *LocalityWeights        `json:"locality"`
+	NetworkWeight      float64                 `json:"networkWeight"`
+	Mirror             *MirrorConfig           `json:"mirror"`
+	CrossZoneAdmission *CrossZoneAdmission     `json:"crossZoneAdmission"`
+	CrossZoneLatencyMs float64                 `json:"crossZoneLatencyMs"`
+	LatencyBudgetShare float64                 `json:"latencyBudgetShare"`
+	DrainZones         []string                `json:"drainZones"`
+	DrainRate          float64                 `json:"drainRate"`
+	MaxCrossZones      int                     `json:"maxCrossZones"`
+	Annealing          *LearningAnnealing      `json:"annealing"`
+	ZoneCosts          map[string]float64      `json:"zoneCosts"`
+	MinZoneShare       float64                 `json:"minZoneShare"`
+	MinNodeShare       float64                 `json:"minNodeShare"`
+	AdaptiveThreshold  *AdaptiveThreshold      `json:"adaptiveThreshold"`
 }
 
 type CandidatePool struct {
-	Nodes     []*ServiceNode
-	Factors   []float64
-	Weights   []float64
-	FactorSum float64
+	Nodes        []*ServiceNode
+	Factors      []float64
+	Weights      []float64
+	FactorSum    float64
+	Epoch        uint64
+	slowNodes    map[string]bool
+	selectCounts []int
+	selectTotal  int
+	// allNodes are the nodes of every zone when the pool was built,
+	// including those left out of it, for observer mode and pinning.
+	allNodes []*ServiceNode
+	// mirror is the mirror config of the lab the pool was built with.
+	mirror *MirrorConfig
 }
 
 type ServiceNodes struct {
@@ -121,18 +389,29 @@ type ServiceNodes struct {
 }
 
 type ServiceNode struct {
-	PublicIP      string
-	InstanceID    string
-	Host          string
-	Port          int
-	Zone          string
-	BalanceFactor float64
-	CurrentFactor float64
-	WorkLoad      float64
+	PublicIP       string
+	InstanceID     string
+	Host           string
+	Port           int
+	Ports          map[string]int `json:",omitempty"`
+	Zone           string
+	Rack           string
+	HostID         string
+	Tags           []string
+	BalanceFactor  float64
+	CurrentFactor  float64
+	WorkLoad       float64
+	AdjustReason   string
+	StartTime      time.Time
+	Datacenter     string `json:",omitempty"`
+	DeployBatch    int    `json:",omitempty"`
+	DeployProgress int    `json:",omitempty"`
+	DeployTotal    int    `json:",omitempty"`
 }
 
 type ServiceZone struct {
 	Nodes    []*ServiceNode
+	Racks    map[string]*ServiceRack
 	Zone     string
 	WorkLoad float64
 }
@@ -152,49 +431,103 @@ type InstanceFactor struct {
 }
 
 type InstanceMetaInfo struct {
-	PublicIP       string  `json:"public_ip"`
-	InstanceID     string  `json:"instanceid"`
-	CPUUtilization float64 `json:"CPUUtilization"`
-	Zone           string  `json:"zone"`
+	PublicIP           string   `json:"public_ip"`
+	InstanceID         string   `json:"instanceid"`
+	CPUUtilization     float64  `json:"CPUUtilization"`
+	NetworkUtilization *float64 `json:"NetworkUtilization,omitempty"`
+	Zone               string   `json:"zone"`
 }
 
+// initLogger installs the swappable logger, discarding everything until
+// SetLogger is called.
+func (r *ConsulResolver) initLogger() {
+	r.logs.Swap(nil)
+	r.watcherLogs.Swap(nil)
+	r.logger = &r.logs
+}
+
+// SetLogger sets the logger, which may be replaced while the resolver is
+// running. A nil logger, the default, discards everything.
 func (r *ConsulResolver) SetLogger(logger util.Logger) {
-	r.logger = logger
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.baseLogger = logger
+	r.swapLoggers()
 }
 
 func (r *ConsulResolver) SetWatcher(watcherLogger util.Logger) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 	r.watcherLogger = watcherLogger
+	r.swapLoggers()
 }
 
 func (r *ConsulResolver) SetZone(zone string) {
 	r.zone = zone
 }
 
-func (r *ConsulResolver) Start() error {
-	if err := r.updateAll(); err != nil {
-		return err
+// SetZoneProvider sets the zone found by provider, keeping the current
+// zone when it fails.
+func (r *ConsulResolver) SetZoneProvider(provider util.ZoneProvider) error {
+	zone, err := provider.Zone()
+	if err != nil {
+		return fmt.Errorf("find zone: %v", err)
 	}
+	r.SetZone(zone)
+	return nil
+}
 
-	if r.logger != nil {
-		r.logger.Infof("new consul resolver start. [%#v]", r)
+// findZone finds the zone with the provider of the cloud given to the
+// constructor when none was set.
+func (r *ConsulResolver) findZone() error {
+	if r.zone != "" || r.zoneProvider == nil {
+		return nil
 	}
+	return r.SetZoneProvider(r.zoneProvider)
+}
 
-	if r.watcherLogger != nil {
-		r.watcher = util.NewWatch(r.watcherLogger)
-		r.watcher.RunWatch()
-	}
+func (r *ConsulResolver) Start() error {
+	return r.StartContext(context.Background())
+}
 
+// StartContext is Start with the update loop tied to ctx: when ctx is
+// done the loop exits as if Stop was called. Starting a running resolver
+// does nothing; a stopped one starts again.
+func (r *ConsulResolver) StartContext(ctx context.Context) error {
+	r.lifecycleMutex.Lock()
+	defer r.lifecycleMutex.Unlock()
+	if r.loopRunning() {
+		return nil
+	}
+	if err := r.startBackground(ctx); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	r.mutex.Lock()
+	r.loopCancel = cancel
+	r.loopDone = done
+	r.mutex.Unlock()
 	go func() {
-		tk := time.NewTicker(r.interval)
+		defer close(done)
+		defer r.setRunning(false)
+		interval := r.loopInterval()
+		tk := time.NewTicker(interval)
+		defer func() { tk.Stop() }()
 		for {
 			select {
 			case <-tk.C:
 				if err := r.updateAll(); err != nil {
 					r.logger.Warnf("updateAll failed. err: %s", err.Error())
 				}
-			case <-r.done:
+				if next := r.loopInterval(); next != interval {
+					tk.Stop()
+					tk = time.NewTicker(next)
+					interval = next
+				}
+			case <-ctx.Done():
 				r.logger.Infof("consul resolver get stop signal, will stop")
-				tk.Stop()
+				r.stopBackground()
 				return
 			}
 		}
@@ -203,72 +536,265 @@ func (r *ConsulResolver) Start() error {
 	return nil
 }
 
+// startBackground runs the first update and starts everything but the
+// update loop, under a lifecycle context derived from ctx.
+func (r *ConsulResolver) startBackground(ctx context.Context) error {
+	if err := r.findZone(); err != nil {
+		return err
+	}
+	r.loadState()
+	r.setLifecycle(context.WithCancel(ctx))
+	r.mutex.Lock()
+	r.degraded = false
+	r.mutex.Unlock()
+	if err := r.updateAll(); err != nil {
+		if err = r.startFallback(err); err != nil {
+			r.cancelLifecycle()
+			return err
+		}
+	}
+
+	r.logger.Infof("new consul resolver start. service: %s, address: %s, zone: %s", r.service, r.address, r.zone)
+
+	if r.watcherLogger != nil {
+		r.watcher = util.NewWatch(&r.watcherLogs)
+		r.watcher.RunWatch()
+	}
+
+	if r.streaming && r.k8sServiceKey == "" && r.preparedQuery == "" {
+		ctx, cancel := context.WithCancel(r.lifecycleContext())
+		r.streamCancel = cancel
+		go r.watchService(ctx)
+	}
+
+	r.startProbe()
+	r.startKVWatch()
+	r.setStopped(false)
+	r.setRunning(true)
+	return nil
+}
+
 func (r *ConsulResolver) Stop() {
-	r.done <- true
+	r.StopContext(context.Background())
+}
+
+// StopContext stops the update loop and waits for an update in progress
+// to finish, or returns ctx.Err() when ctx is done first. Stopping a
+// resolver that is not running does nothing.
+func (r *ConsulResolver) StopContext(ctx context.Context) error {
+	r.lifecycleMutex.Lock()
+	defer r.lifecycleMutex.Unlock()
+	r.mutex.Lock()
+	cancel, done := r.loopCancel, r.loopDone
+	r.mutex.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	r.mutex.Lock()
+	r.loopCancel = nil
+	r.loopDone = nil
+	r.mutex.Unlock()
+	return nil
+}
+
+// loopRunning reports whether the update loop of Start is running.
+func (r *ConsulResolver) loopRunning() bool {
+	r.mutex.Lock()
+	done := r.loopDone
+	r.mutex.Unlock()
+	if done == nil {
+		return false
+	}
+	select {
+	case <-done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (r *ConsulResolver) stopBackground() {
+	r.setStopped(true)
+	r.cancelLifecycle()
+	if r.streamCancel != nil {
+		r.streamCancel()
+	}
+	r.stopProbe()
+	r.stopKVWatch()
 	if r.watcherLogger != nil {
 		r.watcher.Stop()
 	}
 }
 
 func (r *ConsulResolver) updateAll() error {
+	return r.update(false)
+}
+
+// updateNow runs an update cycle whose health read does not block on the
+// last index, so a KV change is applied without waiting for the health
+// query to time out.
+func (r *ConsulResolver) updateNow() error {
+	return r.update(true)
+}
+
+func (r *ConsulResolver) update(noWait bool) (err error) {
+	// the hooks run once updateMutex is released
+	defer r.runHooks()
+	r.updateMutex.Lock()
+	defer r.updateMutex.Unlock()
+	r.noWait = noWait
+	prev := r.saveGeneration()
+	defer func() {
+		if err != nil {
+			r.restoreGeneration(prev)
+			if err != ErrUpdateCircuitOpen {
+				// a skipped cycle must not extend the cool down
+				r.countError(err)
+			}
+			if err == ErrUpdateCircuitOpen || !r.partialUpdates || !r.readsDocuments() {
+				// updatePartial reported its errors already
+				r.reportError(err)
+			}
+			if !r.fallBackToSRV(err) {
+				r.fallBackAfterOutage(err)
+			}
+			return
+		}
+		r.errorMutex.Lock()
+		r.consecutiveFailures = 0
+		r.errorMutex.Unlock()
+	}()
+	if r.circuitOpen() {
+		return ErrUpdateCircuitOpen
+	}
+	ctx, end := r.startSpan(r.lifecycleContext(), "consul_lb.updateAll", attribute.String("service", r.service))
+	r.updateCtx = ctx
+	defer func() {
+		end(err)
+		r.updateCtx = nil
+	}()
 	r.logger.Debugf("======== start updateAll ========")
-	err := r.updateCPUThreshold()
+	switch {
+	case r.partialUpdates && r.readsDocuments():
+		err = r.updatePartial()
+		if err != nil {
+			return err
+		}
+	default:
+		if r.readsDocuments() {
+			err = r.updateDocuments()
+			if err != nil {
+				return err
+			}
+		}
+		err = r.updateServiceZone()
+		if err != nil {
+			return err
+		}
+	}
+	r.checkZoneCPU()
+	r.expireBalanceFactorCache()
+	r.updateCandidatePool()
+	r.mutex.Lock()
+	r.lastUpdate = time.Now()
+	r.generation++
+	r.degraded = false
+	r.applySettings()
+	r.queueConfigEvent(prev)
+	r.mutex.Unlock()
+	r.logger.Debugf("======== end updateAll ========")
+	return nil
+}
+
+// updateDocuments reads the KV documents of one update cycle.
+func (r *ConsulResolver) updateDocuments() error {
+	err := r.updateDiscovery()
 	if err != nil {
 		return err
 	}
-	err = r.updateZoneCPUMap()
+	err = r.updateConfigSet()
 	if err != nil {
 		return err
 	}
-	err = r.updateOnlineLabFactor()
+	err = r.updateSettings()
 	if err != nil {
 		return err
 	}
-	err = r.updateInstanceFactorMap()
+	if r.sourceDue(SOURCE_CONFIG) {
+		err = r.updateCPUThreshold()
+		if err != nil {
+			return err
+		}
+	}
+	if r.sourceDue(SOURCE_ZONE_CPU) {
+		err = r.updateZoneCPUMap()
+		if err != nil {
+			return err
+		}
+		r.fetched(SOURCE_ZONE_CPU)
+	}
+	if r.sourceDue(SOURCE_CONFIG) {
+		err = r.updateOnlineLabFactor()
+		if err != nil {
+			return err
+		}
+		r.fetched(SOURCE_CONFIG)
+	}
+	err = r.updateCordon()
 	if err != nil {
 		return err
 	}
-	err = r.updateServiceZone()
+	err = r.updateQuarantine()
 	if err != nil {
 		return err
 	}
-	r.expireBalanceFactorCache()
-	r.updateCandidatePool()
-	r.logger.Debugf("======== end updateAll ========")
+	r.applyRollout()
+	r.applySchedule(time.Now())
+	if !r.sourceDue(SOURCE_INSTANCE_FACTOR) {
+		return nil
+	}
+	err = r.updateInstanceFactorMap()
+	if err != nil {
+		return err
+	}
+	r.fetched(SOURCE_INSTANCE_FACTOR)
 	return nil
 }
 
 func (r *ConsulResolver) updateCPUThreshold() error {
-	res, _, err := r.client.KV().Get(r.cpuThresholdKey, nil)
-	if err != nil {
-		return err
-	}
 	var ct CPUThreshold
-	err = jsoniter.ConfigCompatibleWithStandardLibrary.Unmarshal(res.Value, &ct)
+	err := r.getConfigKV(r.cpuThresholdKey, &ct)
 	if err != nil {
 		return err
 	}
-	r.cpuThreshold = ct.CThreshold
-	r.logger.Debugf("update cpuThreshold: %f, key: %s", r.cpuThreshold, r.cpuThresholdKey)
+	r.applyCPUThreshold(&ct)
 	return nil
 }
 
+func (r *ConsulResolver) applyCPUThreshold(ct *CPUThreshold) {
+	r.cpuThreshold = ct.CThreshold
+	r.logger.Debugf("update cpuThreshold: %f, key: %s", r.cpuThreshold, r.configKey(r.cpuThresholdKey))
+}
+
 func (r *ConsulResolver) updateZoneCPUMap() error {
-	res, _, err := r.client.KV().Get(r.zoneCPUKey, nil)
-	if err != nil {
-		return err
-	}
 	var zc ZoneCPUUtilizationRatio
-	err = jsoniter.ConfigCompatibleWithStandardLibrary.Unmarshal(res.Value, &zc)
+	err := r.getKV(r.zoneCPUKey, &zc)
 	if err != nil {
 		return err
 	}
-	if time.Now().Unix()-zc.Updated < 300 {
-		r.zoneCPUUpdated = true
-	} else {
-		r.zoneCPUUpdated = false
-		r.logger.Warnf("%s no update, will hold factor learning", r.zoneCPUKey)
-	}
+	r.applyZoneCPUMap(&zc)
+	return nil
+}
+
+func (r *ConsulResolver) applyZoneCPUMap(zc *ZoneCPUUtilizationRatio) {
+	r.zoneCPUTime = zc.Updated
+	r.checkZoneCPU()
 	m := make(map[string]float64)
 	for _, v := range zc.Date {
 		for k, vv := range v {
@@ -277,79 +803,149 @@ func (r *ConsulResolver) updateZoneCPUMap() error {
 	}
 	r.zoneCPUMap = m
 	r.logger.Debugf("update zoneCPUMap: %+v, key: %s", r.zoneCPUMap, r.zoneCPUKey)
-	return nil
 }
 
-func (r *ConsulResolver) updateOnlineLabFactor() error {
-	res, _, err := r.client.KV().Get(r.onlineLabKey, nil)
-	if err != nil {
-		return err
+// checkZoneCPU holds factor learning while the zone cpu document is older
+// than 300s. It runs on every cycle, the document may be reused from an
+// earlier fetch. A zone cpu map set directly, with no document time, is
+// left as is.
+func (r *ConsulResolver) checkZoneCPU() {
+	if r.zoneCPUTime == 0 {
+		return
+	}
+	if time.Now().Unix()-r.zoneCPUTime < 300 {
+		r.zoneCPUUpdated = true
+	} else {
+		r.zoneCPUUpdated = false
+		r.logger.Warnf("%s no update, will hold factor learning", r.zoneCPUKey)
 	}
+}
+
+func (r *ConsulResolver) updateOnlineLabFactor() error {
 	var ol OnlineLab
-	err = jsoniter.ConfigCompatibleWithStandardLibrary.Unmarshal(res.Value, &ol)
+	err := r.getConfigKV(r.onlineLabKey, &ol)
 	if err != nil {
 		return err
 	}
-	r.onlineLab = &ol
-	r.logger.Debugf("update onlineLab: %+v, key: %s", r.onlineLab, r.onlineLabKey)
+	r.applyOnlineLab(&ol)
 	return nil
 }
 
+func (r *ConsulResolver) applyOnlineLab(ol *OnlineLab) {
+	r.onlineLab = ol
+	r.logger.Debugf("update onlineLab: %+v, key: %s", r.onlineLab, r.configKey(r.onlineLabKey))
+}
+
 func (r *ConsulResolver) updateInstanceFactorMap() error {
-	res, _, err := r.client.KV().Get(r.instanceFactorKey, nil)
-	if err != nil {
-		return err
-	}
 	var i InstanceFactor
-	err = jsoniter.ConfigCompatibleWithStandardLibrary.Unmarshal(res.Value, &i)
+	err := r.getKV(r.instanceFactorKey, &i)
 	if err != nil {
 		return err
 	}
+	r.applyInstanceFactorMap(&i)
+	return nil
+}
+
+func (r *ConsulResolver) applyInstanceFactorMap(i *InstanceFactor) {
 	m := make(map[string]float64)
 	for _, v := range i.Date {
 		m[v.InstanceID] = v.CPUUtilization
+		if v.NetworkUtilization != nil {
+			m[v.InstanceID] = r.blendNetwork(v.CPUUtilization, *v.NetworkUtilization)
+		}
 	}
 	r.instanceFactorMap = m
+	r.zoneNetworkMap = zoneNetwork(i.Date)
 	r.logger.Debugf("update instanceFactorMap: %+v, key: %s", r.instanceFactorMap, r.instanceFactorKey)
-	return nil
 }
 
-func (r *ConsulResolver) updateServiceZone() error {
-	var serviceNodes []ServiceNode
+func (r *ConsulResolver) fetchServiceNodes() ([]ServiceNode, error) {
 	if r.k8sServiceKey != "" {
 		var services ServiceNodes
-		res, _, err := r.client.KV().Get(r.k8sServiceKey, nil)
+		err := r.getKV(r.k8sServiceKey, &services)
 		if err != nil {
-			return err
-		}
-		err = jsoniter.ConfigCompatibleWithStandardLibrary.Unmarshal(res.Value, &services)
-		if err != nil {
-			return err
+			return nil, err
 		}
-		serviceNodes = services.Data
-	} else {
-		qm := api.QueryOptions{}
+		return r.filterTags(services.Data), nil
+	}
+	if r.preparedQuery != "" {
+		return r.executePreparedQuery()
+	}
+	qm := api.QueryOptions{Namespace: r.namespace}
+	if !r.noWait {
 		qm.WaitIndex = r.lastIndex
 		qm.WaitTime = r.timeout
-		res, meta, err := r.client.Health().Service(r.service, "", true, &qm)
+	}
+	_, end := r.startSpan(r.updateContext(), "consul_lb.health.service", attribute.String("service", r.service))
+	var res []*api.ServiceEntry
+	var meta *api.QueryMeta
+	err := r.retry(func() (err error) {
+		// leave the server the whole wait time to answer the blocking query
+		ctx, cancel := r.callContext(2 * r.timeout)
+		defer cancel()
+		res, meta, err = r.healthService(qm.WithContext(ctx))
 		if err != nil {
-			return err
+			return classifyError(r.service, err)
 		}
-		r.lastIndex = meta.LastIndex
-		serviceNodes = make([]ServiceNode, len(res))
-		for i, entry := range res {
-			serviceNode := ServiceNode{}
-			serviceNode.Zone = entry.Service.Meta["zone"]
-			balanceFactor, _ := strconv.ParseFloat(entry.Service.Meta["balanceFactor"], 64)
-			serviceNode.BalanceFactor = balanceFactor
-			serviceNode.InstanceID = entry.Service.Meta["instanceID"]
-			serviceNode.PublicIP = entry.Service.Meta["publicIP"]
-			serviceNode.Host = entry.Service.Address
-			serviceNode.Port = entry.Service.Port
-			serviceNodes[i] = serviceNode
+		return nil
+	})
+	if err != nil {
+		end(err)
+		return nil, err
+	}
+	end(nil)
+	r.lastIndex = meta.LastIndex
+	serviceNodes := make([]ServiceNode, len(res))
+	for i, entry := range res {
+		serviceNodes[i] = newServiceNode(entry)
+	}
+	return r.failover(r.updateContext(), serviceNodes)
+}
+
+func newServiceNode(entry *api.ServiceEntry) ServiceNode {
+	serviceNode := ServiceNode{}
+	serviceNode.Zone = entry.Service.Meta["zone"]
+	balanceFactor, _ := strconv.ParseFloat(entry.Service.Meta["balanceFactor"], 64)
+	serviceNode.BalanceFactor = balanceFactor
+	serviceNode.InstanceID = entry.Service.Meta["instanceID"]
+	serviceNode.PublicIP = entry.Service.Meta["publicIP"]
+	serviceNode.Rack = entry.Service.Meta["rack"]
+	serviceNode.HostID = entry.Service.Meta["hostID"]
+	serviceNode.Tags = entry.Service.Tags
+	serviceNode.Host = entry.Service.Address
+	serviceNode.Port = entry.Service.Port
+	serviceNode.Ports = parsePorts(entry.Service.Meta)
+	serviceNode.StartTime = parseStartTime(entry.Service.Meta)
+	serviceNode.DeployBatch, serviceNode.DeployProgress, serviceNode.DeployTotal = parseDeploy(entry.Service.Meta)
+	return serviceNode
+}
+
+func (r *ConsulResolver) updateServiceZone() error {
+	serviceNodes := r.streamedNodes()
+	if serviceNodes == nil && r.fetchedNodes != nil && !r.sourceDue(SOURCE_HEALTH) {
+		serviceNodes = r.fetchedNodes
+	}
+	if serviceNodes == nil {
+		var err error
+		serviceNodes, err = r.fetchServiceNodes()
+		if err != nil {
+			return err
 		}
+		r.fetchedNodes = serviceNodes
+		r.fetched(SOURCE_HEALTH)
 	}
+	r.buildServiceZones(serviceNodes)
+	return nil
+}
 
+// buildServiceZones groups serviceNodes into zones and racks with the
+// workloads of the current documents.
+func (r *ConsulResolver) buildServiceZones(serviceNodes []ServiceNode) {
+	serviceNodes = r.seedNodes(serviceNodes)
+	serviceNodes = r.retainVanished(serviceNodes, time.Now())
+	r.redactNodes(serviceNodes)
+	serviceNodes = r.applyFactorPolicy(serviceNodes)
+	serviceNodes = r.applyDuplicatePolicy(serviceNodes)
 	m := make(map[string]*ServiceZone)
 	for _, v := range serviceNodes {
 		workload, ok := r.instanceFactorMap[v.InstanceID]
@@ -368,6 +964,9 @@ func (r *ConsulResolver) updateServiceZone() error {
 			}
 			if zoneWorkload, ok := r.zoneCPUMap[v.Zone]; ok {
 				z.WorkLoad = zoneWorkload
+				if network, ok := r.zoneNetworkMap[v.Zone]; ok {
+					z.WorkLoad = r.blendNetwork(zoneWorkload, network)
+				}
 			}
 			node := ServiceNode{}
 			node = v
@@ -384,181 +983,299 @@ func (r *ConsulResolver) updateServiceZone() error {
 
 	serviceZones := make([]*ServiceZone, 0)
 	for _, v := range m {
+		buildRacks(v)
 		serviceZones = append(serviceZones, v)
 		if v.Zone == r.zone {
 			r.localZone = v
 		}
 	}
 	r.serviceZones = serviceZones
-	return nil
 }
 
 func (r *ConsulResolver) expireBalanceFactorCache() {
 	if 1 == util.IntPseudoRandom(1, r.onlineLab.FactorCacheExpire) {
+		if r.factorCache != nil {
+			r.factorCache.reset()
+		}
 		r.balanceFactorCache = make(map[string]float64)
 		r.logger.Debugf("remove balanceFactorCache")
 	}
 }
 
 func (r *ConsulResolver) updateCandidatePool() {
+	logger := r.learningLogger()
 	localZone := r.localZone
-	serviceZones := r.serviceZones
-	balanceFactorCache := r.balanceFactorCache
+	serviceZones := r.nearestZones(r.serviceZones)
+	learningRate := r.learningRate()
+	r.updateRateThreshold()
+	balanceFactorCache, unlockFactorCache := r.lockFactorCache()
+	now := time.Now()
 	candidatePool := new(CandidatePool)
 	var factorCached bool
-	if len(r.balanceFactorCache) > 0 {
+	if len(balanceFactorCache) > 0 {
 		factorCached = true
 	}
 	var localAvgFactor float64
+	var localFactorSum float64
+	if r.staticWeights {
+		// nothing to learn, the loop below has no zones to go through
+		candidatePool = r.staticPool(serviceZones)
+		serviceZones = nil
+	}
 
 	for _, serviceZone := range serviceZones {
-		if (r.localZone == nil && r.onlineLab.CrossZone) || r.localZone.Zone == serviceZone.Zone {
-			r.logger.Debugf("current zone: %s, %s", r.zone, serviceZone.Zone)
+		if (r.localZone == nil && r.crossZone()) || (r.localZone != nil && r.localZone.Zone == serviceZone.Zone) {
+			logger.Debugf("current zone: %s, %s", r.zone, serviceZone.Zone)
+			bounds := r.factorBounds(serviceZone.Zone)
 			for _, node := range serviceZone.Nodes {
 				candidatePool.Nodes = append(candidatePool.Nodes, node)
 				candidatePool.Weights = append(candidatePool.Weights, 0)
 				balanceFactor := node.BalanceFactor
+				node.AdjustReason = ADJUST_NONE
+				learned := r.learnedElsewhere(node.InstanceID, now)
 				if factorCached {
 					bf, ok := balanceFactorCache[node.InstanceID]
 					if ok {
 						balanceFactor = bf
-						r.logger.Debugf("balanceFactor update, factorCached balanceFactor: %f", balanceFactor)
+						logger.Debugf("balanceFactor update, factorCached balanceFactor: %f", balanceFactor)
 					} else if localAvgFactor > 0 {
 						balanceFactor = localAvgFactor
-						r.logger.Debugf("balanceFactor update, localAvgFactor balanceFactor: %f", balanceFactor)
+						logger.Debugf("balanceFactor update, localAvgFactor balanceFactor: %f", balanceFactor)
+						node.AdjustReason = ADJUST_START
 					} else {
 						balanceFactor = node.BalanceFactor * r.onlineLab.FactorStartRate
-						r.logger.Debugf("balanceFactor update, node.BalanceFactor * r.onlineLab.FactorStartRate balanceFactor: %f", balanceFactor)
+						logger.Debugf("balanceFactor update, node.BalanceFactor * r.onlineLab.FactorStartRate balanceFactor: %f", balanceFactor)
+						node.AdjustReason = ADJUST_START
 					}
 				}
-				r.logger.Debugf("will check nodeBalance, node.WorkLoad: %f, serviceZone.WorkLoad: %f, r.onlineLab.RateThreshold: %f, r.zoneCPUUpdated: %t",
-					node.WorkLoad, serviceZone.WorkLoad, r.onlineLab.RateThreshold, r.zoneCPUUpdated)
+				logger.Debugf("will check nodeBalance, node.WorkLoad: %f, serviceZone.WorkLoad: %f, r.rateThreshold: %f, r.zoneCPUUpdated: %t",
+					node.WorkLoad, serviceZone.WorkLoad, r.rateThreshold, r.zoneCPUUpdated)
 
-				if !r.nodeBalanced(node, serviceZone) && r.zoneCPUUpdated {
+				if !learned && !r.nodeBalanced(node, serviceZone) && r.zoneCPUUpdated {
 					if node.WorkLoad > serviceZone.WorkLoad {
-						balanceFactor -= balanceFactor * r.onlineLab.LearningRate
-						r.logger.Debugf("balanceFactor update, balanceFactor -= balanceFactor * r.onlineLab.LearningRate: %f", balanceFactor)
+						balanceFactor -= balanceFactor * learningRate
+						logger.Debugf("balanceFactor update, balanceFactor -= balanceFactor * learningRate %f: %f", learningRate, balanceFactor)
+						node.AdjustReason = ADJUST_LEARN_DOWN
 					} else {
-						balanceFactor += balanceFactor * r.onlineLab.LearningRate
-						r.logger.Debugf("balanceFactor update, balanceFactor += balanceFactor * r.onlineLab.LearningRate: %f", balanceFactor)
+						balanceFactor += balanceFactor * learningRate
+						logger.Debugf("balanceFactor update, balanceFactor += balanceFactor * learningRate %f: %f", learningRate, balanceFactor)
+						node.AdjustReason = ADJUST_LEARN_UP
 					}
 				}
-				if balanceFactor > BALANCEFACTOR_MAX_LOCAL {
-					balanceFactor = BALANCEFACTOR_MAX_LOCAL
-					r.logger.Debugf("balanceFactor update, BALANCEFACTOR_MAX_LOCAL: %f", balanceFactor)
-				} else if balanceFactor < BALANCEFACTOR_MIN_LOCAL {
-					balanceFactor = BALANCEFACTOR_MIN_LOCAL
-					r.logger.Debugf("balanceFactor update, BALANCEFACTOR_MIN_LOCAL: %f", balanceFactor)
+				if balanceFactor > bounds.MaxLocal {
+					balanceFactor = bounds.MaxLocal
+					logger.Debugf("balanceFactor update, bounds.MaxLocal: %f", balanceFactor)
+					node.AdjustReason = ADJUST_CLAMP_MAX
+				} else if balanceFactor < bounds.MinLocal {
+					balanceFactor = bounds.MinLocal
+					logger.Debugf("balanceFactor update, bounds.MinLocal: %f", balanceFactor)
+					node.AdjustReason = ADJUST_CLAMP_MIN
+				}
+				// logger.Infof("balanceFactor: %f", balanceFactor)
+				balanceFactorCache[node.InstanceID] = balanceFactor
+				if !learned {
+					r.markLearned(node.InstanceID, now)
+				}
+				localFactorSum += balanceFactor
+				logger.Debugf("balanceFactorCache: %+v", balanceFactorCache)
+				if w := r.localityWeight(node, serviceZone); w != 1 {
+					balanceFactor *= w
+					logger.Debugf("balanceFactor update, balanceFactor *= localityWeight %f: %f", w, balanceFactor)
+					node.AdjustReason = ADJUST_LOCALITY
 				}
-				// r.logger.Infof("balanceFactor: %f", balanceFactor)
 				node.CurrentFactor = balanceFactor
 				candidatePool.Factors = append(candidatePool.Factors, balanceFactor)
 				candidatePool.FactorSum += balanceFactor
-				balanceFactorCache[node.InstanceID] = balanceFactor
-				r.logger.Debugf("balanceFactorCache: %+v", balanceFactorCache)
 			}
 			if len(candidatePool.Factors) > 0 {
-				localAvgFactor = candidatePool.FactorSum / float64(len(candidatePool.Factors))
-				r.logger.Debugf("localAvgFactor updated: %f", localAvgFactor)
+				localAvgFactor = localFactorSum / float64(len(candidatePool.Factors))
+				logger.Debugf("localAvgFactor updated: %f", localAvgFactor)
 			}
-		} else if r.onlineLab.CrossZone && r.zoneCPUMap[r.localZone.Zone] > r.cpuThreshold && r.onlineLab.CrossZoneRate > util.FloatPseudoRandom() {
-			r.logger.Debugf("when crossZone is true, current zone: %s, %s", r.zone, serviceZone.Zone)
+		} else if r.crossZone() && r.admitCrossZone(localZone, serviceZone) && r.scheduledCrossZoneRate() > util.FloatPseudoRandom() {
+			logger.Debugf("when crossZone is true, current zone: %s, %s", r.zone, serviceZone.Zone)
+			bounds := r.factorBounds(serviceZone.Zone)
 			for _, node := range serviceZone.Nodes {
 				candidatePool.Nodes = append(candidatePool.Nodes, node)
 				candidatePool.Weights = append(candidatePool.Weights, 0)
 				balanceFactor := node.BalanceFactor
+				node.AdjustReason = ADJUST_NONE
 				bf, ok := balanceFactorCache[node.InstanceID]
 				if ok {
 					balanceFactor = bf
-					r.logger.Debugf("balanceFactor update, factorCached balanceFactor: %f", balanceFactor)
-				}
-				if !r.zoneBalanced(localZone, serviceZone) && localZone.WorkLoad > r.cpuThreshold && localZone.WorkLoad > serviceZone.WorkLoad {
-					balanceFactor = balanceFactor * BALANCEFACTOR_CROSS_RATE
-					r.logger.Debugf("balanceFactor update, balanceFactor = balanceFactor * BALANCEFACTOR_CROSS_RATE: %f", balanceFactor)
-				} else {
-					// balanceFactor = balanceFactor * (localZone.WorkLoad - serviceZone.WorkLoad) / 100.0
-					balanceFactor = BALANCEFACTOR_MIN_CROSS
-					r.logger.Debugf("balanceFactor update, balanceFactor = BALANCEFACTOR_MIN_CROSS: %f", balanceFactor)
+					logger.Debugf("balanceFactor update, factorCached balanceFactor: %f", balanceFactor)
 				}
-				if r.zoneCPUUpdated {
-					if !r.zoneBalanced(localZone, serviceZone) && localZone.WorkLoad > r.cpuThreshold && localZone.WorkLoad > serviceZone.WorkLoad {
-						if balanceFactor < BALANCEFACTOR_START_CROSS {
-							balanceFactor = BALANCEFACTOR_START_CROSS
-							r.logger.Debugf("balanceFactor update, balanceFactor = BALANCEFACTOR_START_CROSS: %f", balanceFactor)
-						}
-						balanceFactor += balanceFactor * r.onlineLab.LearningRate
-						r.logger.Debugf("balanceFactor update, balanceFactor += balanceFactor * r.onlineLab.LearningRate: %f", balanceFactor)
+				learned := ok && r.learnedElsewhere(node.InstanceID, now)
+				if !learned {
+					if r.spillCrossZone(localZone, serviceZone) {
+						balanceFactor = balanceFactor * BALANCEFACTOR_CROSS_RATE
+						logger.Debugf("balanceFactor update, balanceFactor = balanceFactor * BALANCEFACTOR_CROSS_RATE: %f", balanceFactor)
+						node.AdjustReason = ADJUST_CROSS_RATE
 					} else {
-						balanceFactor -= balanceFactor * r.onlineLab.LearningRate
-						r.logger.Debugf("balanceFactor update, balanceFactor -= balanceFactor * r.onlineLab.LearningRate: %f", balanceFactor)
+						// balanceFactor = balanceFactor * (localZone.WorkLoad - serviceZone.WorkLoad) / 100.0
+						balanceFactor = bounds.MinCross
+						logger.Debugf("balanceFactor update, balanceFactor = bounds.MinCross: %f", balanceFactor)
+						node.AdjustReason = ADJUST_CLAMP_MIN
 					}
-					if !r.nodeBalanced(node, serviceZone) {
-						if node.WorkLoad > serviceZone.WorkLoad {
-							balanceFactor += balanceFactor * r.onlineLab.LearningRate
-							r.logger.Debugf("balanceFactor update, balanceFactor += balanceFactor * r.onlineLab.LearningRate: %f", balanceFactor)
+					if r.zoneCPUUpdated {
+						if r.spillCrossZone(localZone, serviceZone) {
+							if balanceFactor < BALANCEFACTOR_START_CROSS {
+								balanceFactor = BALANCEFACTOR_START_CROSS
+								logger.Debugf("balanceFactor update, balanceFactor = BALANCEFACTOR_START_CROSS: %f", balanceFactor)
+								node.AdjustReason = ADJUST_START
+							}
+							balanceFactor += balanceFactor * learningRate
+							logger.Debugf("balanceFactor update, balanceFactor += balanceFactor * learningRate %f: %f", learningRate, balanceFactor)
+							node.AdjustReason = ADJUST_LEARN_UP
 						} else {
-							balanceFactor -= balanceFactor * r.onlineLab.LearningRate
-							r.logger.Debugf("balanceFactor update, balanceFactor -= balanceFactor * r.onlineLab.LearningRate: %f", balanceFactor)
+							balanceFactor -= balanceFactor * learningRate
+							logger.Debugf("balanceFactor update, balanceFactor -= balanceFactor * learningRate %f: %f", learningRate, balanceFactor)
+							node.AdjustReason = ADJUST_LEARN_DOWN
+						}
+						if !r.nodeBalanced(node, serviceZone) {
+							if node.WorkLoad > serviceZone.WorkLoad {
+								balanceFactor += balanceFactor * learningRate
+								logger.Debugf("balanceFactor update, balanceFactor += balanceFactor * learningRate %f: %f", learningRate, balanceFactor)
+								node.AdjustReason = ADJUST_LEARN_UP
+							} else {
+								balanceFactor -= balanceFactor * learningRate
+								logger.Debugf("balanceFactor update, balanceFactor -= balanceFactor * learningRate %f: %f", learningRate, balanceFactor)
+								node.AdjustReason = ADJUST_LEARN_DOWN
+							}
 						}
 					}
 				}
-				if balanceFactor > BALANCEFACTOR_MAX_CROSS {
-					balanceFactor = BALANCEFACTOR_MAX_CROSS
-					r.logger.Debugf("balanceFactor update, BALANCEFACTOR_MAX_CROSS: %f", balanceFactor)
-				} else if balanceFactor < BALANCEFACTOR_MIN_CROSS {
-					balanceFactor = BALANCEFACTOR_MIN_CROSS
-					r.logger.Debugf("balanceFactor update, BALANCEFACTOR_MIN_CROSS: %f", balanceFactor)
+				if balanceFactor > bounds.MaxCross {
+					balanceFactor = bounds.MaxCross
+					logger.Debugf("balanceFactor update, bounds.MaxCross: %f", balanceFactor)
+					node.AdjustReason = ADJUST_CLAMP_MAX
+				} else if balanceFactor < bounds.MinCross {
+					balanceFactor = bounds.MinCross
+					logger.Debugf("balanceFactor update, bounds.MinCross: %f", balanceFactor)
+					node.AdjustReason = ADJUST_CLAMP_MIN
+				}
+				// logger.Infof("balanceFactor: %f", balanceFactor)
+				balanceFactorCache[node.InstanceID] = balanceFactor
+				if !learned {
+					r.markLearned(node.InstanceID, now)
+				}
+				logger.Debugf("balanceFactorCache: %+v", balanceFactorCache)
+				if d := r.crossZoneDiscount(); d < 1 {
+					balanceFactor *= d
+					logger.Debugf("balanceFactor update, balanceFactor *= crossZoneDiscount %f: %f", d, balanceFactor)
+					node.AdjustReason = ADJUST_LATENCY_BUDGET
 				}
-				// r.logger.Infof("balanceFactor: %f", balanceFactor)
 				node.CurrentFactor = balanceFactor
 				candidatePool.Factors = append(candidatePool.Factors, balanceFactor)
 				candidatePool.FactorSum += balanceFactor
-				balanceFactorCache[node.InstanceID] = balanceFactor
-				r.logger.Debugf("balanceFactorCache: %+v", balanceFactorCache)
 			}
 		}
 	}
+	unlockFactorCache()
+
+	candidatePool = r.drainZones(candidatePool)
+	candidatePool = r.cordonNodes(candidatePool)
+	candidatePool = r.applyQuarantine(candidatePool)
+	candidatePool = r.softRemoveNodes(candidatePool)
+	candidatePool = r.drainDeployBatch(candidatePool)
+	candidatePool = r.applyWarmup(candidatePool)
+	candidatePool = r.applyFeedback(candidatePool)
+	candidatePool = r.applyBreaker(candidatePool)
+	candidatePool = r.applyProbe(candidatePool)
+	candidatePool = r.penalizeRemote(candidatePool)
+	candidatePool = r.applyTrafficFloor(candidatePool)
 
 	candidatePoolSize := len(candidatePool.Nodes)
+	r.mutex.Lock()
 	if r.metric != nil {
 		r.metric.candidatePoolSize = candidatePoolSize
 	} else {
 		cm := ConsulResolverMetric{}
 		cm.candidatePoolSize = candidatePoolSize
 		r.metric = &cm
-		r.logger.Debugf("init metric: %+v", r.metric)
+		logger.Debugf("init metric: %+v", r.metric)
 	}
+	r.mutex.Unlock()
 
-	r.candidatePool = candidatePool
-	return
+	candidatePool.allNodes = zoneNodes(r.serviceZones)
+	if r.onlineLab != nil {
+		candidatePool.mirror = r.onlineLab.Mirror
+	}
+	candidatePool.slowNodes = r.updateSlowNodes(candidatePool)
+	r.exportLearning(candidatePool)
+	r.exportCallers()
+	r.exportPipeline()
+	r.exportFactors(candidatePool)
+	r.saveState(candidatePool)
+	r.publishPool(candidatePool)
 }
 
 func (r *ConsulResolver) nodeBalanced(node *ServiceNode, zone *ServiceZone) bool {
-	return math.Abs(node.WorkLoad-zone.WorkLoad)/100.0 < r.onlineLab.RateThreshold
+	return math.Abs(node.WorkLoad-zone.WorkLoad)/100.0 < r.rateThreshold
 }
 
 func (r *ConsulResolver) zoneBalanced(localZone *ServiceZone, crossZone *ServiceZone) bool {
-	return math.Abs(localZone.WorkLoad-crossZone.WorkLoad)/100.0 < r.onlineLab.RateThreshold*2
+	return math.Abs(localZone.WorkLoad-crossZone.WorkLoad)/100.0 < r.rateThreshold*2
 }
 
 func (r *ConsulResolver) SelectNode() *ServiceNode {
+	if r.selectDuration != nil {
+		defer r.recordSelect(time.Now())
+	}
+	node, _ := r.selectNode(nil, nil)
+	return r.observe(node)
+}
+
+// SelectNodeExcept is SelectNode leaving out the nodes skip returns true
+// for, e.g. those without a ready connection, in the same single selection
+// so the weighting and metrics count it once. It returns nil when skip
+// leaves out every node.
+func (r *ConsulResolver) SelectNodeExcept(skip func(node *ServiceNode) bool) *ServiceNode {
+	if r.selectDuration != nil {
+		defer r.recordSelect(time.Now())
+	}
+	node, _ := r.selectNode(nil, skip)
+	return r.observe(node)
+}
+
+func (r *ConsulResolver) selectNode(trace *SelectTrace, skip func(node *ServiceNode) bool) (*ServiceNode, uint64) {
+	if err := r.ensureFresh(); err != nil {
+		return nil, 0
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if node := r.pinnedNode(); node != nil && (skip == nil || !skip(node)) {
+		if trace != nil {
+			trace.Pinned = true
+		}
+		return node, r.poolIndex
+	}
 	if r.candidatePool == nil || len(r.candidatePool.Nodes) == 0 {
-		return nil
+		return nil, r.poolIndex
+	}
+	if trace != nil {
+		trace.fill(r.candidatePool)
 	}
 
-	var idx int
-	var max float64
-	for i := 0; i < len(r.candidatePool.Factors); i++ {
-		r.candidatePool.Weights[i] += r.candidatePool.Factors[i]
-		if max < r.candidatePool.Weights[i] {
-			max = r.candidatePool.Weights[i]
-			idx = i
+	var skipIndex func(i int) bool
+	if skip != nil {
+		skipIndex = func(i int) bool { return skip(r.candidatePool.Nodes[i]) }
+	}
+	idx, cached := r.cachedSelect()
+	if cached && skipIndex != nil && skipIndex(idx) {
+		cached = false
+	}
+	if !cached {
+		idx = r.pickIndex(skipIndex)
+		if idx < 0 {
+			return nil, r.candidatePool.Epoch
 		}
+		r.storeSelect(idx)
 	}
+	r.countSelect(idx)
+	r.logSelection(idx)
 	r.logger.Debugf("index: %d", idx)
 	node := r.candidatePool.Nodes[idx]
 	r.logger.Debugf("select node: %+v", node)
-	r.candidatePool.Weights[idx] -= r.candidatePool.FactorSum
 	r.metric.selectNum += 1
 
 	if node.Zone != r.zone {
@@ -570,7 +1287,35 @@ func (r *ConsulResolver) SelectNode() *ServiceNode {
 		r.watcher.AddWatchValue(node.Host, 1)
 		r.watcher.AddAvgWatchValue(node.Host+"_workload", node.WorkLoad)
 	}
-	return node
+	return node, r.candidatePool.Epoch
+}
+
+// pickWeighted runs one round of smooth weighted round robin over the
+// candidate pool, leaving out the nodes skip returns true for. It returns
+// -1 if every node was skipped.
+func (r *ConsulResolver) pickWeighted(skip func(i int) bool) int {
+	pool := r.candidatePool
+	idx := -1
+	var max, total float64
+	for i := 0; i < len(pool.Factors); i++ {
+		if skip != nil && skip(i) {
+			continue
+		}
+		pool.Weights[i] += pool.Factors[i]
+		total += pool.Factors[i]
+		if idx < 0 || max < pool.Weights[i] {
+			max = pool.Weights[i]
+			idx = i
+		}
+	}
+	if idx >= 0 {
+		pool.Weights[idx] -= total
+	}
+	return idx
+}
+
+func (r *ConsulResolver) skipNode(i int) bool {
+	return r.candidatePool.slowNodes[nodeKey(r.candidatePool.Nodes[i])] || r.overShare(i) || r.ejected(r.candidatePool.Nodes[i]) || r.unreachable(r.candidatePool.Nodes[i]) || r.quarantined(r.candidatePool.Nodes[i])
 }
 
 func (r *ConsulResolver) GetZoneNodes(zone string) []*ServiceNode {
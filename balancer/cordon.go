@@ -0,0 +1,121 @@
+package balancer
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const CORDON_CAS_RETRY = 5
+
+var ErrCordonConflict = errors.New("cordon list changed concurrently")
+
+// CordonList is the KV document of instances excluded from selection by
+// every resolver watching it.
+type CordonList struct {
+	Updated     int64    `json:"updated"`
+	InstanceIDs []string `json:"instanceIDs"`
+}
+
+// SetCordonKey makes the resolver read the CordonList at key every update
+// cycle and drop the listed instances from the candidate pool. A missing
+// key means no instance is cordoned.
+func (r *ConsulResolver) SetCordonKey(key string) {
+	r.cordonKey = key
+}
+
+func (r *ConsulResolver) updateCordon() error {
+	if r.cordonKey == "" {
+		return nil
+	}
+	var c CordonList
+	err := r.getKV(r.cordonKey, &c)
+	if ue, ok := err.(*UpdateError); ok && ue.Class == ERROR_KEY_MISSING {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	m := make(map[string]bool, len(c.InstanceIDs))
+	for _, id := range c.InstanceIDs {
+		m[id] = true
+	}
+	r.cordoned = m
+	r.logger.Debugf("update cordoned: %+v, key: %s", r.cordoned, r.cordonKey)
+	return nil
+}
+
+func (r *ConsulResolver) cordonNodes(pool *CandidatePool) *CandidatePool {
+	if len(r.cordoned) == 0 {
+		return pool
+	}
+	return r.scalePool(pool, ADJUST_CORDON, func(node *ServiceNode) float64 {
+		if r.cordoned[node.InstanceID] {
+			return 0
+		}
+		return 1
+	})
+}
+
+// Cordon adds instanceID to the shared cordon list. Every resolver with the
+// same cordon key applies it on its next update cycle.
+func (r *ConsulResolver) Cordon(instanceID string) error {
+	return r.updateCordonList(func(ids []string) []string {
+		for _, id := range ids {
+			if id == instanceID {
+				return ids
+			}
+		}
+		return append(ids, instanceID)
+	})
+}
+
+// Uncordon removes instanceID from the shared cordon list.
+func (r *ConsulResolver) Uncordon(instanceID string) error {
+	return r.updateCordonList(func(ids []string) []string {
+		out := ids[:0]
+		for _, id := range ids {
+			if id != instanceID {
+				out = append(out, id)
+			}
+		}
+		return out
+	})
+}
+
+func (r *ConsulResolver) updateCordonList(f func([]string) []string) error {
+	if r.cordonKey == "" {
+		return errors.New("cordon key not set")
+	}
+	kv := r.client.KV()
+	for i := 0; i < CORDON_CAS_RETRY; i++ {
+		pair, _, err := kv.Get(r.cordonKey, r.queryOptions(&api.QueryOptions{}))
+		if err != nil {
+			return classifyError(r.cordonKey, err)
+		}
+		var c CordonList
+		var index uint64
+		if pair != nil {
+			index = pair.ModifyIndex
+			if err := json.Unmarshal(pair.Value, &c); err != nil {
+				return &UpdateError{Class: ERROR_PARSE, Key: r.cordonKey, Err: err}
+			}
+		}
+		c.InstanceIDs = f(c.InstanceIDs)
+		c.Updated = time.Now().Unix()
+		value, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		ok, _, err := kv.CAS(&api.KVPair{Key: r.cordonKey, Value: value, ModifyIndex: index}, r.writeOptions(&api.WriteOptions{}))
+		if err != nil {
+			return classifyError(r.cordonKey, err)
+		}
+		if ok {
+			return nil
+		}
+	}
+	return ErrCordonConflict
+}
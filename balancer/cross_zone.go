@@ -0,0 +1,30 @@
+package balancer
+
+// CrossZoneAdmission replaces the default cross-zone conditions, which
+// admit remote zones while the local zone CPU is above cpuThreshold and
+// grow remote factors while the zones are unbalanced. When set, a remote
+// zone is admitted, and its factors grow, only while the local zone
+// workload is at least MinLocalUtilization and exceeds the remote zone
+// workload by LocalWorkloadMargin, both in percent.
+type CrossZoneAdmission struct {
+	LocalWorkloadMargin float64 `json:"localWorkloadMargin"`
+	MinLocalUtilization float64 `json:"minLocalUtilization"`
+}
+
+func (a *CrossZoneAdmission) admit(localZone, crossZone *ServiceZone) bool {
+	return localZone.WorkLoad >= a.MinLocalUtilization && localZone.WorkLoad-crossZone.WorkLoad >= a.LocalWorkloadMargin
+}
+
+func (r *ConsulResolver) admitCrossZone(localZone, crossZone *ServiceZone) bool {
+	if a := r.onlineLab.CrossZoneAdmission; a != nil {
+		return a.admit(localZone, crossZone)
+	}
+	return r.zoneCPUMap[localZone.Zone] > r.scheduledCPUThreshold()
+}
+
+func (r *ConsulResolver) spillCrossZone(localZone, crossZone *ServiceZone) bool {
+	if a := r.onlineLab.CrossZoneAdmission; a != nil {
+		return a.admit(localZone, crossZone)
+	}
+	return !r.zoneBalanced(localZone, crossZone) && localZone.WorkLoad > r.scheduledCPUThreshold() && localZone.WorkLoad > crossZone.WorkLoad
+}
@@ -0,0 +1,71 @@
+package balancer
+
+import (
+	"context"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const REMOTE_DC_PENALTY = 0.5
+
+// SetDatacenters makes the resolver query datacenters, in order, when the
+// local datacenter has no healthy instance of the service, and use the
+// nodes of the first one that has. The factors of remote nodes are scaled
+// by penalty (REMOTE_DC_PENALTY when not positive), which matters when
+// they share the pool with local nodes, e.g. soft removed ones. The local
+// datacenter is queried again every cycle and takes over as soon as it has
+// healthy instances.
+func (r *ConsulResolver) SetDatacenters(datacenters []string, penalty float64) {
+	if penalty <= 0 {
+		penalty = REMOTE_DC_PENALTY
+	}
+	r.datacenters = datacenters
+	r.remoteDCPenalty = penalty
+}
+
+// failover returns nodes, or the nodes of the first of the configured
+// datacenters with healthy instances if nodes is empty.
+func (r *ConsulResolver) failover(ctx context.Context, nodes []ServiceNode) ([]ServiceNode, error) {
+	if len(nodes) > 0 || len(r.datacenters) == 0 || r.k8sServiceKey != "" {
+		return nodes, nil
+	}
+	for _, dc := range r.datacenters {
+		var res []*api.ServiceEntry
+		err := r.retry(func() (err error) {
+			callCtx, cancel := withTimeout(ctx, r.timeout)
+			defer cancel()
+			res, _, err = r.healthService(r.queryOptions(&api.QueryOptions{Datacenter: dc}).WithContext(callCtx))
+			if err != nil {
+				return classifyError(r.service, err)
+			}
+			return nil
+		})
+		if err != nil {
+			r.logger.Warnf("service: %s, query datacenter %s failed. err: %s", r.service, dc, err.Error())
+			continue
+		}
+		if len(res) == 0 {
+			continue
+		}
+		remote := make([]ServiceNode, len(res))
+		for i, entry := range res {
+			remote[i] = newServiceNode(entry)
+			remote[i].Datacenter = dc
+		}
+		r.logger.Warnf("service: %s, no healthy local instance, fail over to datacenter %s with %d nodes", r.service, dc, len(remote))
+		return remote, nil
+	}
+	return nodes, nil
+}
+
+func (r *ConsulResolver) penalizeRemote(pool *CandidatePool) *CandidatePool {
+	if len(r.datacenters) == 0 {
+		return pool
+	}
+	return r.scalePool(pool, ADJUST_REMOTE_DC, func(node *ServiceNode) float64 {
+		if node.Datacenter != "" {
+			return r.remoteDCPenalty
+		}
+		return 1
+	})
+}
@@ -0,0 +1,57 @@
+package balancer_test
+
+import (
+	"testing"
+
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDatacenters(t *testing.T) {
+	Convey("Test Datacenters", t, func() {
+		f, server := newFakeConsul()
+		defer server.Close()
+		f.remote = map[string][]balancer.ServiceNode{"dc2": {}, "dc3": testNodes()}
+		f.nodes = nil
+		r := newFakeResolver(server.URL)
+		r.SetDatacenters([]string{"dc2", "dc3"}, 0)
+
+		Convey("Given no local instance, the first datacenter with instances is used", func() {
+			So(r.Start(), ShouldBeNil)
+			defer r.Stop()
+			nodes := r.CandidateNodes()
+			So(len(nodes), ShouldEqual, 2)
+			So(nodes[0].Datacenter, ShouldEqual, "dc3")
+
+			f.mutex.Lock()
+			f.nodes = testNodes()
+			f.mutex.Unlock()
+			So(r.Update(), ShouldBeNil)
+			So(r.CandidateNodes()[0].Datacenter, ShouldEqual, "")
+		})
+	})
+}
+
+func TestPreparedQuery(t *testing.T) {
+	Convey("Test PreparedQuery", t, func() {
+		f, server := newFakeConsul()
+		defer server.Close()
+		r := newFakeResolver(server.URL)
+		r.SetPreparedQuery("as-nearest")
+
+		Convey("Given a prepared query, its nodes are weighted like health query ones", func() {
+			So(r.Start(), ShouldBeNil)
+			defer r.Stop()
+			So(len(r.CandidateNodes()), ShouldEqual, 2)
+		})
+		Convey("Given a prepared query failing over, the nodes carry the datacenter", func() {
+			f.nodes = nil
+			f.remote = map[string][]balancer.ServiceNode{"dc2": testNodes()}
+			So(r.Start(), ShouldBeNil)
+			defer r.Stop()
+			nodes := r.CandidateNodes()
+			So(len(nodes), ShouldEqual, 2)
+			So(nodes[0].Datacenter, ShouldEqual, "dc2")
+		})
+	})
+}
@@ -0,0 +1,89 @@
+package balancer
+
+import (
+	"net/http"
+)
+
+// DebugZone is the workload of one service zone in DebugResponse.
+type DebugZone struct {
+	Zone     string  `json:"zone"`
+	WorkLoad float64 `json:"workload"`
+	Nodes    int     `json:"nodes"`
+}
+
+// DebugResponse is the body served by DebugHandler.
+type DebugResponse struct {
+	Service            string             `json:"service"`
+	Zone               string             `json:"zone"`
+	Epoch              uint64             `json:"epoch"`
+	Generation         uint64             `json:"generation"`
+	Frozen             bool               `json:"frozen"`
+	CPUThreshold       float64            `json:"cpuThreshold"`
+	ZoneCPUUpdated     bool               `json:"zoneCPUUpdated"`
+	ZoneCPU            map[string]float64 `json:"zoneCPU"`
+	Zones              []DebugZone        `json:"zones"`
+	OnlineLab          *OnlineLab         `json:"onlineLab"`
+	BalanceFactorCache map[string]float64 `json:"balanceFactorCache"`
+	Nodes              []ServiceNode      `json:"nodes"`
+	Metrics            Metrics            `json:"metrics"`
+	ErrorCounts        map[ErrorClass]int `json:"errorCounts"`
+	LastError          string             `json:"lastError,omitempty"`
+	Pipeline           []StageStats       `json:"pipeline,omitempty"`
+}
+
+// DebugHandler serves the state behind the current selection as JSON: the
+// candidate pool with per-node factors, zone workloads, the onlineLab
+// config and the balanceFactorCache. Hosts and IPs are masked when
+// redaction is enabled.
+func (r *ConsulResolver) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		res := r.debugState()
+		data, err := r.serializerFor("").Marshal(res)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if redactor := r.redactor(); redactor != nil {
+			data = []byte(redactor.Redact(string(data)))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+}
+
+func (r *ConsulResolver) debugState() *DebugResponse {
+	res := &DebugResponse{Service: r.service, Zone: r.zone}
+
+	// the documents are replaced under updateMutex
+	r.updateMutex.Lock()
+	res.CPUThreshold = r.cpuThreshold
+	res.ZoneCPUUpdated = r.zoneCPUUpdated
+	res.ZoneCPU = make(map[string]float64, len(r.zoneCPUMap))
+	for k, v := range r.zoneCPUMap {
+		res.ZoneCPU[k] = v
+	}
+	for _, z := range r.serviceZones {
+		res.Zones = append(res.Zones, DebugZone{Zone: z.Zone, WorkLoad: z.WorkLoad, Nodes: len(z.Nodes)})
+	}
+	if r.onlineLab != nil {
+		lab := *r.onlineLab
+		res.OnlineLab = &lab
+	}
+	res.BalanceFactorCache = r.factorCacheSnapshot()
+	r.updateMutex.Unlock()
+
+	r.mutex.Lock()
+	res.Frozen = r.frozen
+	res.Generation = r.generation
+	r.mutex.Unlock()
+
+	res.Nodes = r.CandidateNodes()
+	res.Metrics = r.Metrics()
+	res.ErrorCounts = r.ErrorCounts()
+	if res.Metrics.LastError != nil {
+		res.LastError = res.Metrics.LastError.Error()
+	}
+	res.Epoch = res.Metrics.Epoch
+	res.Pipeline = r.pipelineStatsSnapshot()
+	return res
+}
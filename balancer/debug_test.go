@@ -0,0 +1,56 @@
+package balancer_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDebugHandler(t *testing.T) {
+	Convey("Test DebugHandler", t, func() {
+		r, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+		So(err, ShouldBeNil)
+		Convey("Given a request, the candidate pool and zones are rendered", func() {
+			w := httptest.NewRecorder()
+			r.DebugHandler().ServeHTTP(w, httptest.NewRequest("GET", "/debug", nil))
+			So(w.Code, ShouldEqual, 200)
+			var res balancer.DebugResponse
+			So(json.Unmarshal(w.Body.Bytes(), &res), ShouldBeNil)
+			So(res.Service, ShouldBeEmpty)
+			So(res.Zone, ShouldEqual, "a")
+			So(len(res.Nodes), ShouldEqual, 2)
+			So(len(res.Zones), ShouldEqual, 2)
+			So(res.BalanceFactorCache["i-2"], ShouldEqual, 900)
+		})
+	})
+}
+
+func TestZoneCPUStaleness(t *testing.T) {
+	Convey("Test zone cpu staleness", t, func() {
+		f, server := newFakeConsul()
+		defer server.Close()
+		f.kv["clb/zone"] = balancer.ZoneCPUUtilizationRatio{Updated: time.Now().Unix() - 298}
+		r := newFakeResolver(server.URL)
+		r.SetSourceIntervals(balancer.SourceIntervals{ZoneCPU: time.Hour})
+		So(r.Start(), ShouldBeNil)
+		defer r.Stop()
+		zoneCPUUpdated := func() bool {
+			w := httptest.NewRecorder()
+			r.DebugHandler().ServeHTTP(w, httptest.NewRequest("GET", "/debug", nil))
+			var res balancer.DebugResponse
+			So(json.Unmarshal(w.Body.Bytes(), &res), ShouldBeNil)
+			return res.ZoneCPUUpdated
+		}
+		So(zoneCPUUpdated(), ShouldBeTrue)
+
+		Convey("Given the document aging past 300s between fetches, learning is held", func() {
+			time.Sleep(2100 * time.Millisecond)
+			So(r.Update(), ShouldBeNil)
+			So(zoneCPUUpdated(), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,76 @@
+package balancer
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DEPLOY_BATCH_META is the service meta key holding the rolling update
+	// batch an instance is replaced in, e.g. "2".
+	DEPLOY_BATCH_META = "deployBatch"
+	// DEPLOY_PROGRESS_META is the service meta key the deploy tooling sets
+	// to the batch in flight out of the total, e.g. "2/5", and to the total
+	// out of the total, e.g. "5/5", once the update completed.
+	DEPLOY_PROGRESS_META = "deployProgress"
+	// DEPLOY_DRAIN_RATE is the factor multiplier of the instances of the
+	// batch in flight.
+	DEPLOY_DRAIN_RATE = 0.2
+)
+
+// parseDeploy returns the batch of an instance, and the batch in flight
+// and the total number of batches from its meta, 0 when absent or
+// malformed.
+func parseDeploy(meta map[string]string) (batch, progress, total int) {
+	batch, _ = strconv.Atoi(meta[DEPLOY_BATCH_META])
+	if v := meta[DEPLOY_PROGRESS_META]; v != "" {
+		if i := strings.IndexByte(v, '/'); i > 0 {
+			total, _ = strconv.Atoi(v[i+1:])
+			v = v[:i]
+		}
+		progress, _ = strconv.Atoi(v)
+	}
+	return batch, progress, total
+}
+
+// SetDeployDraining scales by rate (DEPLOY_DRAIN_RATE when not positive)
+// the factors of the instances in the rolling update batch in flight, so
+// traffic moves off them before they are replaced. The batch in flight is
+// the highest DEPLOY_PROGRESS_META among the candidate nodes and the batch
+// of an instance its DEPLOY_BATCH_META. Only the instances started before
+// the batch went in flight are drained, their replacements are not, and
+// draining stops once the progress reaches the total. A negative rate
+// disables it.
+func (r *ConsulResolver) SetDeployDraining(rate float64) {
+	if rate == 0 {
+		rate = DEPLOY_DRAIN_RATE
+	}
+	r.deployDrainRate = rate
+}
+
+func (r *ConsulResolver) drainDeployBatch(pool *CandidatePool) *CandidatePool {
+	if r.deployDrainRate <= 0 {
+		return pool
+	}
+	progress, total := 0, 0
+	for _, node := range pool.Nodes {
+		if node.DeployProgress > progress {
+			progress, total = node.DeployProgress, node.DeployTotal
+		}
+	}
+	if progress != r.deployProgress {
+		r.deployProgress = progress
+		r.deployProgressTime = time.Now()
+	}
+	if progress == 0 || progress == total {
+		return pool
+	}
+	since := r.deployProgressTime
+	return r.scalePool(pool, ADJUST_DEPLOY, func(node *ServiceNode) float64 {
+		if node.DeployBatch == progress && node.StartTime.Before(since) {
+			return r.deployDrainRate
+		}
+		return 1
+	})
+}
@@ -0,0 +1,70 @@
+package balancer
+
+import (
+	"reflect"
+)
+
+// DiscoveryDocument lists the KV keys a service's resolvers read, and
+// optionally the format of each document. Empty keys keep the configured
+// ones; formats name serializers registered with SetFormat.
+type DiscoveryDocument struct {
+	CPUThresholdKey   string            `json:"cpuThresholdKey"`
+	ZoneCPUKey        string            `json:"zoneCPUKey"`
+	InstanceFactorKey string            `json:"instanceFactorKey"`
+	OnlineLabKey      string            `json:"onlineLabKey"`
+	ConfigSetKey      string            `json:"configSetKey"`
+	Formats           map[string]string `json:"formats"`
+}
+
+// SetDiscoveryKey makes the resolver read its KV keys from the
+// DiscoveryDocument at key every update cycle, so the key layout can be
+// changed centrally.
+func (r *ConsulResolver) SetDiscoveryKey(key string) {
+	r.discoveryKey = key
+}
+
+// SetFormat registers a serializer under a format name usable in the
+// DiscoveryDocument.
+func (r *ConsulResolver) SetFormat(name string, s Serializer) {
+	if r.formats == nil {
+		r.formats = make(map[string]Serializer)
+	}
+	r.formats[name] = s
+}
+
+func (r *ConsulResolver) updateDiscovery() error {
+	if r.discoveryKey == "" {
+		return nil
+	}
+	var d DiscoveryDocument
+	err := r.getKV(r.discoveryKey, &d)
+	if err != nil {
+		return err
+	}
+	setKey := func(dst *string, key string) {
+		if key != "" && key != *dst {
+			r.logger.Infof("discovery %s: switch key %s to %s", r.discoveryKey, *dst, key)
+			*dst = key
+		}
+	}
+	setKey(&r.cpuThresholdKey, d.CPUThresholdKey)
+	setKey(&r.zoneCPUKey, d.ZoneCPUKey)
+	setKey(&r.instanceFactorKey, d.InstanceFactorKey)
+	setKey(&r.onlineLabKey, d.OnlineLabKey)
+	setKey(&r.configSetKey, d.ConfigSetKey)
+	if reflect.DeepEqual(d.Formats, r.discoveryFormats) {
+		return nil
+	}
+	serializers := make(map[string]Serializer, len(d.Formats))
+	for key, format := range d.Formats {
+		s, ok := r.formats[format]
+		if !ok {
+			r.logger.Warnf("discovery %s: unknown format %s for %s", r.discoveryKey, format, key)
+			continue
+		}
+		serializers[key] = s
+	}
+	r.setDocumentSerializers(serializers)
+	r.discoveryFormats = d.Formats
+	return nil
+}
@@ -0,0 +1,95 @@
+package balancer
+
+import (
+	"net"
+	"strconv"
+)
+
+// SetDNSFallback lets Start succeed when its first update cycle fails,
+// e.g. because Consul is unreachable: host, usually the internal load
+// balancer of the service, is resolved and the resolver serves a degraded
+// pool of its addresses with port until an update cycle succeeds. Start
+// still fails if host does not resolve.
+func (r *ConsulResolver) SetDNSFallback(host string, port int) {
+	r.dnsFallbackHost = host
+	r.dnsFallbackPort = port
+}
+
+// Degraded reports whether the resolver serves the nodes found over DNS
+// SRV, the pool saved in its state store, the fallback nodes or the DNS
+// fallback pool because update cycles failed.
+func (r *ConsulResolver) Degraded() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.degraded
+}
+
+// startFallback serves the nodes found over DNS SRV, or else the saved
+// pool, or else the fallback nodes, or else the DNS fallback pool, after
+// the first update cycle failed with cause, or returns cause when there is
+// none of them.
+func (r *ConsulResolver) startFallback(cause error) error {
+	defer r.runHooks()
+	if r.Degraded() {
+		// the failed update cycle fell back to dns srv already
+		return nil
+	}
+	if len(r.savedPool) > 0 {
+		nodes := make([]*ServiceNode, len(r.savedPool))
+		for i := range r.savedPool {
+			nodes[i] = &r.savedPool[i]
+		}
+		r.serveFallback(nodes)
+		r.logger.Warnf("service: %s, update failed, serve the %d saved nodes. err: %s", r.service, len(nodes), cause.Error())
+		return nil
+	}
+	if r.serveSeeds() {
+		r.logger.Warnf("service: %s, update failed, serve %d fallback nodes. err: %s", r.service, len(r.fallbackNodes), cause.Error())
+		return nil
+	}
+	if r.dnsFallbackHost == "" {
+		return cause
+	}
+	ctx, cancel := withTimeout(r.lifecycleContext(), r.timeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, r.dnsFallbackHost)
+	if err != nil {
+		r.logger.Warnf("service: %s, dns fallback %s failed. err: %s", r.service, r.dnsFallbackHost, err.Error())
+		return cause
+	}
+	var nodes []*ServiceNode
+	for _, addr := range addrs {
+		nodes = append(nodes, &ServiceNode{
+			InstanceID:    net.JoinHostPort(addr, strconv.Itoa(r.dnsFallbackPort)),
+			Host:          addr,
+			Port:          r.dnsFallbackPort,
+			Zone:          r.zone,
+			BalanceFactor: 1,
+			CurrentFactor: 1,
+			AdjustReason:  ADJUST_NONE,
+		})
+	}
+	r.serveFallback(nodes)
+	r.logger.Warnf("service: %s, update failed, serve dns fallback %s: %v. err: %s", r.service, r.dnsFallbackHost, addrs, cause.Error())
+	return nil
+}
+
+// serveFallback publishes a pool of nodes at their current factors and
+// marks the resolver degraded.
+func (r *ConsulResolver) serveFallback(nodes []*ServiceNode) {
+	pool := new(CandidatePool)
+	for _, node := range nodes {
+		pool.Nodes = append(pool.Nodes, node)
+		pool.Factors = append(pool.Factors, node.CurrentFactor)
+		pool.Weights = append(pool.Weights, 0)
+		pool.FactorSum += node.CurrentFactor
+	}
+	r.publishPool(pool)
+	r.mutex.Lock()
+	if r.metric == nil {
+		r.metric = &ConsulResolverMetric{}
+	}
+	r.metric.candidatePoolSize = len(pool.Nodes)
+	r.degraded = true
+	r.mutex.Unlock()
+}
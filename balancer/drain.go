@@ -0,0 +1,52 @@
+package balancer
+
+// drainZones scales the factor of nodes in OnlineLab.DrainZones by
+// OnlineLab.DrainRate, dropping them when the rate is 0. The nodes stay in
+// serviceZones, so draining only shifts selection; evacuating the local
+// zone needs crossZone enabled for the other zones to be candidates.
+func (r *ConsulResolver) drainZones(pool *CandidatePool) *CandidatePool {
+	if r.onlineLab == nil || len(r.onlineLab.DrainZones) == 0 {
+		return pool
+	}
+	drained := make(map[string]bool, len(r.onlineLab.DrainZones))
+	for _, zone := range r.onlineLab.DrainZones {
+		drained[zone] = true
+	}
+	return r.scalePool(pool, ADJUST_DRAIN, func(node *ServiceNode) float64 {
+		if drained[node.Zone] {
+			return r.onlineLab.DrainRate
+		}
+		return 1
+	})
+}
+
+// scalePool returns a pool with each factor multiplied by scale(node),
+// dropping nodes scaled to 0. If that would drop every node the pool is
+// returned unchanged, so an exclusion never leaves callers with nothing.
+func (r *ConsulResolver) scalePool(pool *CandidatePool, reason string, scale func(node *ServiceNode) float64) *CandidatePool {
+	if len(pool.Nodes) == 0 {
+		return pool
+	}
+	scaled := new(CandidatePool)
+	for i, node := range pool.Nodes {
+		s := scale(node)
+		if s <= 0 {
+			continue
+		}
+		factor := pool.Factors[i]
+		if s != 1 {
+			factor *= s
+			node.CurrentFactor = factor
+			node.AdjustReason = reason
+		}
+		scaled.Nodes = append(scaled.Nodes, node)
+		scaled.Factors = append(scaled.Factors, factor)
+		scaled.Weights = append(scaled.Weights, 0)
+		scaled.FactorSum += factor
+	}
+	if len(scaled.Nodes) == 0 {
+		r.logger.Warnf("service: %s, %s would exclude every candidate node, ignored", r.service, reason)
+		return pool
+	}
+	return scaled
+}
@@ -0,0 +1,101 @@
+package balancer
+
+const (
+	DUPLICATE_KEEP    = "keep"
+	DUPLICATE_NEWEST  = "newest"
+	DUPLICATE_MERGE   = "merge"
+	DUPLICATE_EXCLUDE = "exclude"
+)
+
+// SetDuplicatePolicy sets what happens to registrations sharing an
+// instanceID, which typically linger after unclean restarts and would
+// otherwise share one factor cache entry: DUPLICATE_KEEP leaves them all,
+// DUPLICATE_NEWEST keeps the one with the latest start time, the last one
+// on a tie, DUPLICATE_MERGE keeps that one with the tags and ports of all
+// of them and DUPLICATE_EXCLUDE drops them all. Registrations without an
+// instanceID are told apart by host and port. Duplicated instanceIDs are
+// always logged and exported as the clb_duplicate_instances gauge.
+func (r *ConsulResolver) SetDuplicatePolicy(policy string) {
+	r.duplicatePolicy = policy
+}
+
+func (r *ConsulResolver) applyDuplicatePolicy(serviceNodes []ServiceNode) []ServiceNode {
+	groups := make(map[string][]int, len(serviceNodes))
+	for i := range serviceNodes {
+		key := nodeKey(&serviceNodes[i])
+		groups[key] = append(groups[key], i)
+	}
+	if len(groups) == len(serviceNodes) {
+		r.exportDuplicates(0)
+		return serviceNodes
+	}
+	nodes := make([]ServiceNode, 0, len(groups))
+	duplicates := 0
+	for i, node := range serviceNodes {
+		group := groups[nodeKey(&node)]
+		if len(group) == 1 {
+			nodes = append(nodes, node)
+			continue
+		}
+		if i == group[0] {
+			duplicates++
+			r.logger.Warnf("service: %s, instance: %s registered %d times, policy: %s", r.service, nodeKey(&node), len(group), r.duplicatePolicy)
+		}
+		switch r.duplicatePolicy {
+		case DUPLICATE_NEWEST, DUPLICATE_MERGE:
+			newest := group[0]
+			for _, j := range group[1:] {
+				if !serviceNodes[j].StartTime.Before(serviceNodes[newest].StartTime) {
+					newest = j
+				}
+			}
+			if i != newest {
+				continue
+			}
+			if r.duplicatePolicy == DUPLICATE_MERGE {
+				node = mergeRegistrations(serviceNodes, group, node)
+			}
+		case DUPLICATE_EXCLUDE:
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	r.exportDuplicates(duplicates)
+	return nodes
+}
+
+// mergeRegistrations returns node with the tags and ports of the
+// registrations in group added.
+func mergeRegistrations(serviceNodes []ServiceNode, group []int, node ServiceNode) ServiceNode {
+	tags := make([]string, 0, len(node.Tags))
+	seen := make(map[string]bool)
+	ports := make(map[string]int)
+	for _, j := range group {
+		for _, tag := range serviceNodes[j].Tags {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+		for name, port := range serviceNodes[j].Ports {
+			if _, ok := ports[name]; !ok {
+				ports[name] = port
+			}
+		}
+	}
+	// the ports of node itself win
+	for name, port := range node.Ports {
+		ports[name] = port
+	}
+	node.Tags = tags
+	if len(ports) > 0 {
+		node.Ports = ports
+	}
+	return node
+}
+
+func (r *ConsulResolver) exportDuplicates(duplicates int) {
+	if r.metricsSink != nil {
+		r.metricsSink.SetGauge("clb_duplicate_instances", float64(duplicates), map[string]string{"service": r.service})
+	}
+}
@@ -0,0 +1,34 @@
+package balancer
+
+import (
+	"time"
+)
+
+// servePool makes pool the serving pool, stamped with the next epoch. It
+// must be called with r.mutex held.
+func (r *ConsulResolver) servePool(pool *CandidatePool) {
+	pool.Epoch = r.poolIndex + 1
+	if pool.allNodes == nil {
+		pool.allNodes = pool.Nodes
+	}
+	r.notifySubscribers(r.candidatePool, pool)
+	r.queuePoolEvent(r.candidatePool, pool)
+	r.candidatePool = pool
+	r.inflight.prune(pool)
+	r.recordHistory(pool)
+	r.poolIndex = pool.Epoch
+	if r.metricsSink != nil {
+		r.metricsSink.SetGauge("clb_pool_epoch", float64(pool.Epoch), map[string]string{"service": r.service})
+	}
+}
+
+// SelectNodeEpoch is SelectNode also returning the epoch of the pool the
+// node was selected from, to correlate a request with the view of the
+// world it was routed with.
+func (r *ConsulResolver) SelectNodeEpoch() (*ServiceNode, uint64) {
+	if r.selectDuration != nil {
+		defer r.recordSelect(time.Now())
+	}
+	node, epoch := r.selectNode(nil, nil)
+	return r.observe(node), epoch
+}
@@ -0,0 +1,128 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+type ErrorClass string
+
+const (
+	ERROR_TIMEOUT     ErrorClass = "timeout"
+	ERROR_ACL_DENIED  ErrorClass = "acl_denied"
+	ERROR_SERVER      ErrorClass = "server_error"
+	ERROR_TRANSPORT   ErrorClass = "transport"
+	ERROR_PARSE       ErrorClass = "parse_error"
+	ERROR_KEY_MISSING ErrorClass = "key_missing"
+	ERROR_OTHER       ErrorClass = "other"
+)
+
+// UpdateError is returned by the update cycle for a failed Consul read.
+type UpdateError struct {
+	Class ErrorClass
+	Key   string
+	Err   error
+}
+
+func (e *UpdateError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Class, e.Key, e.Err)
+}
+
+func (e *UpdateError) Unwrap() error {
+	return e.Err
+}
+
+func classifyError(key string, err error) *UpdateError {
+	class := ERROR_OTHER
+	msg := err.Error()
+	if ne, ok := err.(net.Error); (ok && ne.Timeout()) || err == context.DeadlineExceeded || strings.Contains(msg, "Client.Timeout") {
+		class = ERROR_TIMEOUT
+	} else if strings.Contains(msg, "response code: 403") || strings.Contains(msg, "Permission denied") || strings.Contains(msg, "ACL not found") {
+		class = ERROR_ACL_DENIED
+	} else if strings.Contains(msg, "response code: 5") {
+		class = ERROR_SERVER
+	} else if transportError(err) {
+		class = ERROR_TRANSPORT
+	}
+	return &UpdateError{Class: class, Key: key, Err: err}
+}
+
+// transportError reports whether err means the connection to Consul
+// could not be made or was lost.
+func transportError(err error) bool {
+	var oe *net.OpError
+	var de *net.DNSError
+	return errors.As(err, &oe) || errors.As(err, &de)
+}
+
+// getKV reads key and decodes its JSON value into v.
+func (r *ConsulResolver) getKV(key string, v interface{}) error {
+	return r.readKV(key, key, v)
+}
+
+// getConfigKV reads the configured key from the active config set. The
+// document serializer is the one set for the configured key.
+func (r *ConsulResolver) getConfigKV(key string, v interface{}) error {
+	return r.readKV(r.configKey(key), key, v)
+}
+
+// readKV reads key and decodes its value into v with the serializer of
+// document.
+func (r *ConsulResolver) readKV(key, document string, v interface{}) (err error) {
+	_, end := r.startSpan(r.updateContext(), "consul_lb.kv.get", attribute.String("consul.key", key))
+	defer func() { end(err) }()
+	res, ok := r.watchedKV(key)
+	if !ok {
+		err = r.retry(func() (err error) {
+			ctx, cancel := r.callContext(r.timeout)
+			defer cancel()
+			res, _, err = r.client.KV().Get(key, r.queryOptions(&api.QueryOptions{}).WithContext(ctx))
+			if err != nil {
+				return classifyError(key, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if res == nil {
+		return &UpdateError{Class: ERROR_KEY_MISSING, Key: key, Err: fmt.Errorf("key not found")}
+	}
+	err = r.serializerFor(document).Unmarshal(res.Value, v)
+	if err != nil {
+		return &UpdateError{Class: ERROR_PARSE, Key: key, Err: err}
+	}
+	return nil
+}
+
+func (r *ConsulResolver) countError(err error) {
+	class := ERROR_OTHER
+	if ue, ok := err.(*UpdateError); ok {
+		class = ue.Class
+	}
+	r.errorMutex.Lock()
+	r.errorCounts[class]++
+	r.consecutiveFailures++
+	r.lastError = err
+	r.lastErrorTime = time.Now()
+	r.errorMutex.Unlock()
+}
+
+// ErrorCounts returns the number of failed update cycles by error class.
+func (r *ConsulResolver) ErrorCounts() map[ErrorClass]int {
+	r.errorMutex.Lock()
+	defer r.errorMutex.Unlock()
+	m := make(map[ErrorClass]int, len(r.errorCounts))
+	for k, v := range r.errorCounts {
+		m[k] = v
+	}
+	return m
+}
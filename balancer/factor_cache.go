@@ -0,0 +1,87 @@
+package balancer
+
+import (
+	"sync"
+	"time"
+)
+
+// FactorCache holds learned balance factors keyed by instanceID. Resolvers
+// for services backed by the same instances, e.g. sidecars, can share one
+// so every instance is learned once instead of once per service.
+type FactorCache struct {
+	mutex   sync.Mutex
+	factors map[string]float64
+	learned map[string]time.Time
+}
+
+func NewFactorCache() *FactorCache {
+	return &FactorCache{
+		factors: make(map[string]float64),
+		learned: make(map[string]time.Time),
+	}
+}
+
+// Factors returns a copy of the cached factors.
+func (c *FactorCache) Factors() map[string]float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	m := make(map[string]float64, len(c.factors))
+	for k, v := range c.factors {
+		m[k] = v
+	}
+	return m
+}
+
+func (c *FactorCache) reset() {
+	c.mutex.Lock()
+	c.factors = make(map[string]float64)
+	c.learned = make(map[string]time.Time)
+	c.mutex.Unlock()
+}
+
+// SetFactorCache shares cache with other resolvers. When another resolver
+// learned an instance less than half an interval ago, its factor is used
+// as is instead of being learned again. Nil goes back to a private cache.
+func (r *ConsulResolver) SetFactorCache(cache *FactorCache) {
+	r.updateMutex.Lock()
+	r.factorCache = cache
+	r.updateMutex.Unlock()
+}
+
+// lockFactorCache returns the factor map to learn into and a func to call
+// once learning is done.
+func (r *ConsulResolver) lockFactorCache() (map[string]float64, func()) {
+	if r.factorCache == nil {
+		return r.balanceFactorCache, func() {}
+	}
+	r.factorCache.mutex.Lock()
+	return r.factorCache.factors, r.factorCache.mutex.Unlock
+}
+
+// learnedElsewhere reports whether the shared cache holds a factor for
+// instanceID learned recently. It must be called under lockFactorCache.
+func (r *ConsulResolver) learnedElsewhere(instanceID string, now time.Time) bool {
+	if r.factorCache == nil {
+		return false
+	}
+	learned, ok := r.factorCache.learned[instanceID]
+	return ok && now.Sub(learned) < r.interval/2
+}
+
+// markLearned must be called under lockFactorCache.
+func (r *ConsulResolver) markLearned(instanceID string, now time.Time) {
+	if r.factorCache != nil {
+		r.factorCache.learned[instanceID] = now
+	}
+}
+
+func (r *ConsulResolver) factorCacheSnapshot() map[string]float64 {
+	if r.factorCache != nil {
+		return r.factorCache.Factors()
+	}
+	m := make(map[string]float64, len(r.balanceFactorCache))
+	for k, v := range r.balanceFactorCache {
+		m[k] = v
+	}
+	return m
+}
@@ -0,0 +1,64 @@
+package balancer
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// FactorReport is the document a resolver publishes with SetFactorExport.
+type FactorReport struct {
+	Updated int64              `json:"updated"`
+	Service string             `json:"service"`
+	Zone    string             `json:"zone"`
+	Client  string             `json:"client"`
+	Factors map[string]float64 `json:"factors"`
+}
+
+// SetFactorExport publishes the per-node factors of the candidate pool to
+// prefix/<instanceID> at most once every minInterval, so a dashboard can
+// show how the fleet of clients weights the service instances. Writes are
+// asynchronous and never fail an update.
+func (r *ConsulResolver) SetFactorExport(prefix string, minInterval time.Duration) {
+	r.factorExportPrefix = prefix
+	r.factorExportInterval = minInterval
+}
+
+func (r *ConsulResolver) exportFactors(pool *CandidatePool) {
+	if r.factorExportPrefix == "" || r.client == nil {
+		return
+	}
+	now := time.Now()
+	if now.Sub(r.lastFactorExport) < r.factorExportInterval {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&r.factorExporting, 0, 1) {
+		return
+	}
+	r.lastFactorExport = now
+	report := FactorReport{
+		Updated: now.Unix(),
+		Service: r.service,
+		Zone:    r.zone,
+		Client:  r.instanceID,
+		Factors: make(map[string]float64, len(pool.Nodes)),
+	}
+	for i, node := range pool.Nodes {
+		report.Factors[nodeKey(node)] = pool.Factors[i]
+	}
+	key := r.factorExportPrefix + "/" + r.instanceID
+	ctx := r.lifecycleContext()
+	go func() {
+		defer atomic.StoreInt32(&r.factorExporting, 0)
+		value, err := r.serializerFor(key).Marshal(&report)
+		if err == nil {
+			wctx, cancel := withTimeout(ctx, r.timeout)
+			_, err = r.client.KV().Put(&api.KVPair{Key: key, Value: value}, r.writeOptions(&api.WriteOptions{}).WithContext(wctx))
+			cancel()
+		}
+		if err != nil {
+			r.logger.Warnf("export factors to %s failed. err: %s", key, err.Error())
+		}
+	}()
+}
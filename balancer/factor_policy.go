@@ -0,0 +1,53 @@
+package balancer
+
+import (
+	"math"
+)
+
+const (
+	INVALID_FACTOR_KEEP    = "keep"
+	INVALID_FACTOR_DEFAULT = "default"
+	INVALID_FACTOR_EXCLUDE = "exclude"
+)
+
+// SetInvalidFactorPolicy sets what happens to nodes whose balanceFactor
+// meta is missing, unparsable or not positive: INVALID_FACTOR_KEEP leaves
+// it as is (0, later clamped), INVALID_FACTOR_DEFAULT replaces it with
+// defaultFactor and INVALID_FACTOR_EXCLUDE drops the node. Such nodes are
+// always logged and exported as the clb_invalid_factor_nodes gauge.
+func (r *ConsulResolver) SetInvalidFactorPolicy(policy string, defaultFactor float64) {
+	r.invalidFactorPolicy = policy
+	r.defaultFactor = defaultFactor
+}
+
+func validFactor(factor float64) bool {
+	return factor > 0 && !math.IsInf(factor, 0)
+}
+
+func (r *ConsulResolver) applyFactorPolicy(serviceNodes []ServiceNode) []ServiceNode {
+	nodes := make([]ServiceNode, 0, len(serviceNodes))
+	invalid := 0
+	for _, node := range serviceNodes {
+		if !validFactor(node.BalanceFactor) {
+			invalid++
+			r.logger.Warnf("service: %s, instance: %s, invalid balanceFactor: %f, policy: %s", r.service, node.InstanceID, node.BalanceFactor, r.invalidFactorPolicy)
+			switch r.invalidFactorPolicy {
+			case INVALID_FACTOR_DEFAULT:
+				node.BalanceFactor = r.defaultFactor
+			case INVALID_FACTOR_EXCLUDE:
+				continue
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	r.mutex.Lock()
+	if r.metric == nil {
+		r.metric = &ConsulResolverMetric{}
+	}
+	r.metric.invalidFactorNum = invalid
+	r.mutex.Unlock()
+	if r.metricsSink != nil {
+		r.metricsSink.SetGauge("clb_invalid_factor_nodes", float64(invalid), map[string]string{"service": r.service})
+	}
+	return nodes
+}
@@ -0,0 +1,35 @@
+package balancer
+
+// SetFairnessGuard caps the selections of any node within every window
+// of selections to multiple times its fair share window/len(pool), e.g.
+// SetFairnessGuard(3, 1000). Zero multiple disables the guard.
+func (r *ConsulResolver) SetFairnessGuard(multiple float64, window int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.fairMultiple = multiple
+	r.fairWindow = window
+}
+
+// overShare must be called with r.mutex held.
+func (r *ConsulResolver) overShare(i int) bool {
+	pool := r.candidatePool
+	if r.fairMultiple <= 0 || r.fairWindow <= 0 || pool.selectCounts == nil {
+		return false
+	}
+	limit := r.fairMultiple * float64(r.fairWindow) / float64(len(pool.Nodes))
+	return float64(pool.selectCounts[i]) >= limit
+}
+
+// countSelect must be called with r.mutex held.
+func (r *ConsulResolver) countSelect(i int) {
+	if r.fairMultiple <= 0 || r.fairWindow <= 0 {
+		return
+	}
+	pool := r.candidatePool
+	if pool.selectCounts == nil || pool.selectTotal >= r.fairWindow {
+		pool.selectCounts = make([]int, len(pool.Nodes))
+		pool.selectTotal = 0
+	}
+	pool.selectCounts[i]++
+	pool.selectTotal++
+}
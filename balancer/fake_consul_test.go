@@ -0,0 +1,210 @@
+package balancer_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	"github.com/mae-pax/consul-loadbalancer/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeConsul serves the KV and health endpoints the resolver reads.
+type fakeConsul struct {
+	mutex   sync.Mutex
+	kv      map[string]interface{}
+	nodes   []balancer.ServiceNode
+	remote  map[string][]balancer.ServiceNode
+	failing map[string]int
+	index   uint64
+	// kvIndex, when set, is the index of the KV endpoints, so a KV change
+	// leaves the health queries blocked
+	kvIndex uint64
+	gets    int
+	lists   int
+	hang    chan struct{}
+	// blocking makes requests with an index wait, as blocking queries do,
+	// until the index moves past it or their wait time elapses
+	blocking bool
+	// params are the query parameters of the last request
+	params url.Values
+}
+
+func newFakeConsul() (*fakeConsul, *httptest.Server) {
+	f := &fakeConsul{
+		kv: map[string]interface{}{
+			"clb/cpu":    balancer.CPUThreshold{CThreshold: 50},
+			"clb/zone":   balancer.ZoneCPUUtilizationRatio{Updated: time.Now().Unix()},
+			"clb/factor": balancer.InstanceFactor{Updated: time.Now().Unix()},
+			"clb/lab":    balancer.DefaultOnlineLab(),
+		},
+		nodes:   testNodes(),
+		failing: make(map[string]int),
+		index:   1,
+	}
+	return f, httptest.NewServer(f)
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mutex.Lock()
+	hang := f.hang
+	f.mutex.Unlock()
+	if hang != nil {
+		select {
+		case <-hang:
+		case <-req.Context().Done():
+		}
+		return
+	}
+	f.wait(req)
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.params = req.URL.Query()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.indexOf(req.URL.Path), 10))
+	switch {
+	case strings.HasPrefix(req.URL.Path, "/v1/kv/"):
+		key := strings.TrimPrefix(req.URL.Path, "/v1/kv/")
+		if _, ok := req.URL.Query()["recurse"]; ok {
+			f.lists++
+			var pairs []api.KVPair
+			for k, v := range f.kv {
+				if strings.HasPrefix(k, key) {
+					value, _ := json.Marshal(v)
+					pairs = append(pairs, api.KVPair{Key: k, Value: value, ModifyIndex: f.indexOf(req.URL.Path)})
+				}
+			}
+			json.NewEncoder(w).Encode(pairs)
+			return
+		}
+		f.gets++
+		if f.fail(key) {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		v, ok := f.kv[key]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		value, _ := json.Marshal(v)
+		json.NewEncoder(w).Encode([]api.KVPair{{Key: key, Value: value, ModifyIndex: f.indexOf(req.URL.Path)}})
+	case strings.HasPrefix(req.URL.Path, "/v1/health/service/"):
+		if f.fail("health") {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		nodes := f.nodes
+		if dc := req.URL.Query().Get("dc"); dc != "" {
+			nodes = f.remote[dc]
+		}
+		json.NewEncoder(w).Encode(serviceEntries(nodes, req.URL.Query()["tag"]))
+	case strings.HasPrefix(req.URL.Path, "/v1/query/"):
+		// the prepared query fails over to the first remote datacenter
+		// with nodes when there is no local one
+		res := api.PreparedQueryExecuteResponse{Nodes: serviceEntries(f.nodes, nil)}
+		for dc, nodes := range f.remote {
+			if len(res.Nodes) == 0 && len(nodes) > 0 {
+				res.Nodes = serviceEntries(nodes, nil)
+				res.Datacenter = dc
+				res.Failovers = 1
+			}
+		}
+		json.NewEncoder(w).Encode(res)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func serviceEntries(nodes []balancer.ServiceNode, tags []string) []api.ServiceEntry {
+	var entries []api.ServiceEntry
+nodes:
+	for _, n := range nodes {
+		for _, tag := range tags {
+			if !contains(n.Tags, tag) {
+				continue nodes
+			}
+		}
+		entries = append(entries, api.ServiceEntry{
+			Node: &api.Node{Node: n.InstanceID},
+			Service: &api.AgentService{
+				Tags:    n.Tags,
+				Address: n.Host,
+				Port:    n.Port,
+				Meta: map[string]string{
+					"zone":          n.Zone,
+					"instanceID":    n.InstanceID,
+					"balanceFactor": strconv.FormatFloat(n.BalanceFactor, 'f', -1, 64),
+				},
+			},
+		})
+	}
+	return entries
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// wait blocks a request with an index while the index has not moved past
+// it, for at most the wait time of the request.
+func (f *fakeConsul) wait(req *http.Request) {
+	index, err := strconv.ParseUint(req.URL.Query().Get("index"), 10, 64)
+	if err != nil || index == 0 {
+		return
+	}
+	wait, err := time.ParseDuration(req.URL.Query().Get("wait"))
+	if err != nil {
+		wait = 5 * time.Minute
+	}
+	deadline := time.Now().Add(wait)
+	for time.Now().Before(deadline) && req.Context().Err() == nil {
+		f.mutex.Lock()
+		moved := !f.blocking || f.indexOf(req.URL.Path) > index
+		f.mutex.Unlock()
+		if moved {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// indexOf returns the index of the endpoint at path. It must be called
+// with f.mutex held.
+func (f *fakeConsul) indexOf(path string) uint64 {
+	if f.kvIndex != 0 && strings.HasPrefix(path, "/v1/kv/") {
+		return f.kvIndex
+	}
+	return f.index
+}
+
+// fail reports whether the request for key must fail, counting down
+// the failures set for it.
+func (f *fakeConsul) fail(key string) bool {
+	if f.failing[key] <= 0 {
+		return false
+	}
+	f.failing[key]--
+	return true
+}
+
+func newFakeResolver(addr string) *balancer.ConsulResolver {
+	config := api.DefaultConfig()
+	config.Address = strings.TrimPrefix(addr, "http://")
+	r, err := balancer.NewConsulResolverWithConfig("", config, "as", "clb/cpu", "clb/zone", "clb/factor", "clb/lab", time.Minute, time.Second)
+	So(err, ShouldBeNil)
+	r.SetLogger(util.NewRingLogger(100))
+	r.SetZone("a")
+	return r
+}
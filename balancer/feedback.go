@@ -0,0 +1,118 @@
+package balancer
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	FEEDBACK_ALPHA    = 0.2
+	FEEDBACK_MIN_RATE = 0.1
+)
+
+type nodeFeedback struct {
+	latency   float64 // EWMA in seconds
+	errorRate float64 // EWMA of 0 or 1
+	samples   int
+}
+
+type feedbackTracker struct {
+	mutex sync.Mutex
+	alpha float64
+	nodes map[string]*nodeFeedback
+}
+
+// SetFeedback folds the results reported with ReportResult into the
+// factors every update cycle: a node slower than the pool mean EWMA
+// latency has its factor scaled by mean/latency and every node by one
+// minus its EWMA error rate, never below FEEDBACK_MIN_RATE. alpha is the
+// EWMA smoothing, FEEDBACK_ALPHA when not in (0, 1].
+func (r *ConsulResolver) SetFeedback(alpha float64) {
+	if alpha <= 0 || alpha > 1 {
+		alpha = FEEDBACK_ALPHA
+	}
+	r.feedback = &feedbackTracker{alpha: alpha, nodes: make(map[string]*nodeFeedback)}
+}
+
+// ReportResult records the latency and outcome of a request sent to node.
+// The latency is also observed for SetSlowNodeSkip and the outcome counted
+// by SetCircuitBreaker.
+func (r *ConsulResolver) ReportResult(node *ServiceNode, latency time.Duration, err error) {
+	if node == nil {
+		return
+	}
+	r.ObserveLatency(node, latency)
+	r.reportBreaker(node, err)
+	if r.feedback == nil {
+		return
+	}
+	r.feedback.mutex.Lock()
+	defer r.feedback.mutex.Unlock()
+	key := nodeKey(node)
+	f, ok := r.feedback.nodes[key]
+	if !ok {
+		f = &nodeFeedback{latency: latency.Seconds()}
+		r.feedback.nodes[key] = f
+	}
+	failed := 0.0
+	if err != nil {
+		failed = 1
+	}
+	a := r.feedback.alpha
+	f.latency = a*latency.Seconds() + (1-a)*f.latency
+	f.errorRate = a*failed + (1-a)*f.errorRate
+	f.samples++
+}
+
+// feedbackRates returns the factor multiplier of each node in pool with
+// feedback, dropping the feedback of nodes no longer in the pool.
+func (r *ConsulResolver) feedbackRates(pool *CandidatePool) map[string]float64 {
+	r.feedback.mutex.Lock()
+	defer r.feedback.mutex.Unlock()
+	alive := make(map[string]bool, len(pool.Nodes))
+	var sum float64
+	var n int
+	for _, node := range pool.Nodes {
+		key := nodeKey(node)
+		alive[key] = true
+		if f, ok := r.feedback.nodes[key]; ok {
+			sum += f.latency
+			n++
+		}
+	}
+	for key := range r.feedback.nodes {
+		if !alive[key] {
+			delete(r.feedback.nodes, key)
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	mean := sum / float64(n)
+	rates := make(map[string]float64, n)
+	for key, f := range r.feedback.nodes {
+		rate := 1 - f.errorRate
+		if f.latency > mean && f.latency > 0 {
+			rate *= mean / f.latency
+		}
+		rates[key] = math.Max(rate, FEEDBACK_MIN_RATE)
+	}
+	return rates
+}
+
+func (r *ConsulResolver) applyFeedback(pool *CandidatePool) *CandidatePool {
+	if r.feedback == nil {
+		return pool
+	}
+	rates := r.feedbackRates(pool)
+	if len(rates) == 0 {
+		return pool
+	}
+	return r.scalePool(pool, ADJUST_FEEDBACK, func(node *ServiceNode) float64 {
+		if rate, ok := rates[nodeKey(node)]; ok {
+			return rate
+		}
+		return 1
+	})
+}
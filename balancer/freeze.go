@@ -0,0 +1,36 @@
+package balancer
+
+// FreezePool pins the candidate pool SelectNode serves from. Updates keep
+// running in the background and the latest pool is applied on Unfreeze.
+func (r *ConsulResolver) FreezePool() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.frozen = true
+	r.logger.Infof("candidate pool frozen")
+}
+
+func (r *ConsulResolver) Unfreeze() {
+	defer r.runHooks()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.frozen = false
+	if r.pendingPool != nil {
+		r.servePool(r.pendingPool)
+		r.pendingPool = nil
+	}
+	r.logger.Infof("candidate pool unfrozen")
+}
+
+// publishPool serves candidatePool, or holds it while the pool is frozen.
+// The caller runs the hooks of the queued events once it released
+// r.updateMutex.
+func (r *ConsulResolver) publishPool(candidatePool *CandidatePool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.frozen {
+		r.pendingPool = candidatePool
+		r.logger.Debugf("candidate pool frozen, hold update")
+		return
+	}
+	r.servePool(candidatePool)
+}
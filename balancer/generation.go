@@ -0,0 +1,53 @@
+package balancer
+
+// configGeneration holds the KV documents of one update cycle. They are
+// applied together: when any of them fails to load, the previous
+// generation is restored, so the pool is never rebuilt from a mix of old
+// and new documents.
+type configGeneration struct {
+	configSet         string
+	cpuThreshold      float64
+	zoneCPUMap        map[string]float64
+	zoneCPUUpdated    bool
+	zoneCPUTime       int64
+	onlineLab         *OnlineLab
+	instanceFactorMap map[string]float64
+	zoneNetworkMap    map[string]float64
+	cordoned          map[string]bool
+	settings          *ResolverSettings
+}
+
+func (r *ConsulResolver) saveGeneration() *configGeneration {
+	return &configGeneration{
+		configSet:         r.configSet,
+		cpuThreshold:      r.cpuThreshold,
+		zoneCPUMap:        r.zoneCPUMap,
+		zoneCPUUpdated:    r.zoneCPUUpdated,
+		zoneCPUTime:       r.zoneCPUTime,
+		onlineLab:         r.onlineLab,
+		instanceFactorMap: r.instanceFactorMap,
+		zoneNetworkMap:    r.zoneNetworkMap,
+		cordoned:          r.cordoned,
+		settings:          r.settings,
+	}
+}
+
+func (r *ConsulResolver) restoreGeneration(g *configGeneration) {
+	r.configSet = g.configSet
+	r.cpuThreshold = g.cpuThreshold
+	r.zoneCPUMap = g.zoneCPUMap
+	r.zoneCPUUpdated = g.zoneCPUUpdated
+	r.zoneCPUTime = g.zoneCPUTime
+	r.onlineLab = g.onlineLab
+	r.instanceFactorMap = g.instanceFactorMap
+	r.zoneNetworkMap = g.zoneNetworkMap
+	r.cordoned = g.cordoned
+	r.settings = g.settings
+}
+
+// Generation returns the number of config generations applied so far.
+func (r *ConsulResolver) Generation() uint64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.generation
+}
@@ -0,0 +1,67 @@
+package balancer
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// SelectNodeByKey selects a node by weighted rendezvous hashing of key over
+// the candidate pool, so the same key keeps landing on the same node while
+// keys spread in proportion to the factors. When a node leaves the pool
+// only its keys move.
+func (r *ConsulResolver) SelectNodeByKey(key string) *ServiceNode {
+	if err := r.ensureFresh(); err != nil {
+		return nil
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if node := r.pinnedNode(); node != nil {
+		return node
+	}
+	if r.candidatePool == nil || len(r.candidatePool.Nodes) == 0 {
+		return nil
+	}
+	idx := -1
+	var max float64
+	for i, node := range r.candidatePool.Nodes {
+		factor := r.candidatePool.Factors[i]
+		if factor <= 0 {
+			continue
+		}
+		score := -factor / math.Log(hashUnit(key, nodeKey(node)))
+		if idx < 0 || score > max {
+			idx = i
+			max = score
+		}
+	}
+	if idx < 0 {
+		idx = 0
+	}
+	node := r.candidatePool.Nodes[idx]
+	r.metric.selectNum += 1
+	if node.Zone != r.zone {
+		r.metric.crossZoneNum += 1
+	}
+	return node
+}
+
+// hashUnit hashes key and node to a float in (0, 1).
+func hashUnit(key, node string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(node))
+	// 53 bits fit a float64 mantissa exactly
+	return (float64(mix64(h.Sum64())>>11) + 0.5) / (1 << 53)
+}
+
+// mix64 is the splitmix64 finalizer; fnv alone barely changes its high
+// bits for keys differing in the last bytes.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
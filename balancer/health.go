@@ -0,0 +1,69 @@
+package balancer
+
+import (
+	"net/http"
+	"time"
+)
+
+// SetReadinessMaxAge sets how old the last successful update may be for
+// ReadinessHandler to report ready. It defaults to three intervals.
+func (r *ConsulResolver) SetReadinessMaxAge(maxAge time.Duration) {
+	r.readinessMaxAge = maxAge
+}
+
+// LivenessHandler reports 200 while the update loop is running.
+func (r *ConsulResolver) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !r.isRunning() {
+			http.Error(w, "resolver not running", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+}
+
+// ReadinessHandler reports 200 once the resolver is running with a
+// non-empty candidate pool and recently updated data.
+func (r *ConsulResolver) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		maxAge := r.readinessAge()
+		r.mutex.Lock()
+		running := r.running
+		poolSize := 0
+		if r.candidatePool != nil {
+			poolSize = len(r.candidatePool.Nodes)
+		}
+		lastUpdate := r.lastUpdate
+		r.mutex.Unlock()
+		switch {
+		case !running:
+			http.Error(w, "resolver not running", http.StatusServiceUnavailable)
+		case poolSize == 0:
+			http.Error(w, "candidate pool is empty", http.StatusServiceUnavailable)
+		case time.Since(lastUpdate) > maxAge:
+			http.Error(w, "resolver data is stale, last update: "+lastUpdate.Format(time.RFC3339), http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte("ok"))
+		}
+	})
+}
+
+// readinessAge returns how old the last successful update may be.
+func (r *ConsulResolver) readinessAge() time.Duration {
+	if r.readinessMaxAge > 0 {
+		return r.readinessMaxAge
+	}
+	return 3 * r.interval
+}
+
+func (r *ConsulResolver) setRunning(running bool) {
+	r.mutex.Lock()
+	r.running = running
+	r.mutex.Unlock()
+}
+
+func (r *ConsulResolver) isRunning() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.running
+}
@@ -0,0 +1,148 @@
+package balancer
+
+import (
+	"reflect"
+	"sync"
+)
+
+// ConfigChange describes the KV documents that changed in an update cycle.
+// Changed names them, among "configSet", "cpuThreshold", "onlineLab" and
+// "settings"; the other fields are their new values.
+type ConfigChange struct {
+	Generation   uint64
+	Changed      []string
+	ConfigSet    string
+	CPUThreshold float64
+	OnlineLab    *OnlineLab
+	Settings     *ResolverSettings
+}
+
+type poolEvent struct {
+	old, new *CandidatePool
+}
+
+// eventHooks are the callbacks registered with the On* methods. The hook
+// slices and pending events are guarded by r.mutex; fire serializes the
+// callbacks so they see the events in order.
+type eventHooks struct {
+	fire          sync.Mutex
+	poolUpdated   []func(old, new *CandidatePool)
+	nodeAdded     []func(node *ServiceNode)
+	nodeRemoved   []func(node *ServiceNode)
+	configChanged []func(change ConfigChange)
+	poolEvents    []poolEvent
+	configEvents  []ConfigChange
+}
+
+// OnPoolUpdated calls fn with the previous and the new serving pool, the
+// previous one nil for the first pool, each time a pool is served. Hooks
+// run after the pool is served, outside the resolver locks, on the
+// goroutine that served it; they must not block. The pools must not be
+// modified.
+func (r *ConsulResolver) OnPoolUpdated(fn func(old, new *CandidatePool)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.hooks.poolUpdated = append(r.hooks.poolUpdated, fn)
+}
+
+// OnNodeAdded calls fn with every node of a served pool that was not in
+// the previous one.
+func (r *ConsulResolver) OnNodeAdded(fn func(node *ServiceNode)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.hooks.nodeAdded = append(r.hooks.nodeAdded, fn)
+}
+
+// OnNodeRemoved calls fn with every node of the previous pool that is not
+// in the served one.
+func (r *ConsulResolver) OnNodeRemoved(fn func(node *ServiceNode)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.hooks.nodeRemoved = append(r.hooks.nodeRemoved, fn)
+}
+
+// OnConfigChanged calls fn after an update cycle that applied KV documents
+// different from the previous cycle's.
+func (r *ConsulResolver) OnConfigChanged(fn func(change ConfigChange)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.hooks.configChanged = append(r.hooks.configChanged, fn)
+}
+
+// queuePoolEvent must be called with r.mutex held.
+func (r *ConsulResolver) queuePoolEvent(old, new *CandidatePool) {
+	h := &r.hooks
+	if len(h.poolUpdated) == 0 && len(h.nodeAdded) == 0 && len(h.nodeRemoved) == 0 {
+		return
+	}
+	h.poolEvents = append(h.poolEvents, poolEvent{old: old, new: new})
+}
+
+// queueConfigEvent compares the documents of prev with the applied ones.
+// It must be called with r.mutex held.
+func (r *ConsulResolver) queueConfigEvent(prev *configGeneration) {
+	if len(r.hooks.configChanged) == 0 {
+		return
+	}
+	change := ConfigChange{
+		Generation:   r.generation,
+		ConfigSet:    r.configSet,
+		CPUThreshold: r.cpuThreshold,
+		OnlineLab:    r.onlineLab,
+		Settings:     r.settings,
+	}
+	if prev.configSet != r.configSet {
+		change.Changed = append(change.Changed, "configSet")
+	}
+	if prev.cpuThreshold != r.cpuThreshold {
+		change.Changed = append(change.Changed, "cpuThreshold")
+	}
+	if !reflect.DeepEqual(prev.onlineLab, r.onlineLab) {
+		change.Changed = append(change.Changed, "onlineLab")
+	}
+	if !reflect.DeepEqual(prev.settings, r.settings) {
+		change.Changed = append(change.Changed, "settings")
+	}
+	if len(change.Changed) > 0 {
+		r.hooks.configEvents = append(r.hooks.configEvents, change)
+	}
+}
+
+// runHooks calls the hooks of the queued events. It must be called
+// without r.mutex and r.updateMutex held, so hooks may call back into the
+// resolver.
+func (r *ConsulResolver) runHooks() {
+	h := &r.hooks
+	h.fire.Lock()
+	defer h.fire.Unlock()
+	r.mutex.Lock()
+	poolEvents, configEvents := h.poolEvents, h.configEvents
+	h.poolEvents, h.configEvents = nil, nil
+	poolUpdated, nodeAdded, nodeRemoved, configChanged := h.poolUpdated, h.nodeAdded, h.nodeRemoved, h.configChanged
+	r.mutex.Unlock()
+
+	for _, e := range poolEvents {
+		for _, fn := range poolUpdated {
+			fn(e.old, e.new)
+		}
+		if len(nodeAdded) == 0 && len(nodeRemoved) == 0 {
+			continue
+		}
+		delta := diffPool(e.old, e.new, false)
+		for _, node := range delta.Added {
+			for _, fn := range nodeAdded {
+				fn(node)
+			}
+		}
+		for _, node := range delta.Removed {
+			for _, fn := range nodeRemoved {
+				fn(node)
+			}
+		}
+	}
+	for _, change := range configEvents {
+		for _, fn := range configChanged {
+			fn(change)
+		}
+	}
+}
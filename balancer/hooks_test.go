@@ -0,0 +1,61 @@
+package balancer_test
+
+import (
+	"testing"
+
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHooks(t *testing.T) {
+	Convey("Test Hooks", t, func() {
+		f, server := newFakeConsul()
+		defer server.Close()
+		r := newFakeResolver(server.URL)
+		var pools int
+		var added, removed []string
+		var changes []balancer.ConfigChange
+		r.OnPoolUpdated(func(old, new *balancer.CandidatePool) {
+			pools++
+			So(r.CandidateNodes(), ShouldHaveLength, len(new.Nodes))
+		})
+		r.OnNodeAdded(func(node *balancer.ServiceNode) { added = append(added, node.InstanceID) })
+		r.OnNodeRemoved(func(node *balancer.ServiceNode) { removed = append(removed, node.InstanceID) })
+		r.OnConfigChanged(func(change balancer.ConfigChange) { changes = append(changes, change) })
+		So(r.Start(), ShouldBeNil)
+		defer r.Stop()
+		So(pools, ShouldEqual, 1)
+		So(added, ShouldHaveLength, 2)
+
+		Convey("Given membership churn, the added and removed nodes are reported", func() {
+			added = nil
+			f.mutex.Lock()
+			f.nodes = append(f.nodes[:1], balancer.ServiceNode{InstanceID: "i-4", Host: "10.0.0.4", Port: 80, Zone: "a", BalanceFactor: 300})
+			f.mutex.Unlock()
+			So(r.Update(), ShouldBeNil)
+			So(pools, ShouldEqual, 2)
+			So(added, ShouldResemble, []string{"i-4"})
+			So(removed, ShouldResemble, []string{"i-2"})
+		})
+		Convey("Given a hook calling back into the resolver, the update does not deadlock", func() {
+			r.OnPoolUpdated(func(old, new *balancer.CandidatePool) {
+				r.SetRetry(1, 0, 0)
+			})
+			So(r.Update(), ShouldBeNil)
+			So(pools, ShouldEqual, 2)
+		})
+		Convey("Given a changed online lab, the config change is reported once", func() {
+			changes = nil
+			lab := balancer.DefaultOnlineLab()
+			lab.LearningRate = 0.1
+			f.mutex.Lock()
+			f.kv["clb/lab"] = lab
+			f.mutex.Unlock()
+			So(r.Update(), ShouldBeNil)
+			So(r.Update(), ShouldBeNil)
+			So(changes, ShouldHaveLength, 1)
+			So(changes[0].Changed, ShouldResemble, []string{"onlineLab"})
+			So(changes[0].OnlineLab.LearningRate, ShouldEqual, 0.1)
+		})
+	})
+}
@@ -0,0 +1,89 @@
+package balancer
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	POOL_WAIT_DEFAULT = 30 * time.Second
+	POOL_WAIT_MAX     = 5 * time.Minute
+)
+
+// PoolResponse is the body served by PoolHandler.
+type PoolResponse struct {
+	Index   uint64        `json:"index"`
+	Service string        `json:"service"`
+	Zone    string        `json:"zone"`
+	Nodes   []ServiceNode `json:"nodes"`
+}
+
+// PoolHandler serves the candidate pool as JSON. Like a Consul blocking
+// query, a request with ?index=N waits until the pool index moves past N
+// or ?wait (default 30s) elapses. The index is also returned in the
+// X-Pool-Index header.
+func (r *ConsulResolver) PoolHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		var index uint64
+		if v := q.Get("index"); v != "" {
+			i, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				http.Error(w, "invalid index", http.StatusBadRequest)
+				return
+			}
+			index = i
+		}
+		wait := POOL_WAIT_DEFAULT
+		if v := q.Get("wait"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				http.Error(w, "invalid wait", http.StatusBadRequest)
+				return
+			}
+			wait = d
+		}
+		if wait > POOL_WAIT_MAX {
+			wait = POOL_WAIT_MAX
+		}
+
+		if index > 0 && r.PoolIndex() <= index {
+			ch, cancel := r.Subscribe()
+			timer := time.NewTimer(wait)
+		loop:
+			for r.PoolIndex() <= index {
+				select {
+				case <-ch:
+				case <-timer.C:
+					break loop
+				case <-req.Context().Done():
+					break loop
+				}
+			}
+			timer.Stop()
+			cancel()
+		}
+
+		r.mutex.Lock()
+		res := PoolResponse{Index: r.poolIndex, Service: r.service, Zone: r.zone}
+		r.mutex.Unlock()
+		res.Nodes = r.CandidateNodes()
+		data, err := r.serializerFor("").Marshal(&res)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Pool-Index", strconv.FormatUint(res.Index, 10))
+		w.Write(data)
+	})
+}
+
+// PoolIndex returns the epoch of the serving candidate pool, incremented
+// every time it is replaced.
+func (r *ConsulResolver) PoolIndex() uint64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.poolIndex
+}
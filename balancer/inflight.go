@@ -0,0 +1,144 @@
+package balancer
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+type inflightCounters struct {
+	mutex  sync.Mutex
+	counts map[string]*int64
+}
+
+func (c *inflightCounters) counter(key string) *int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]*int64)
+	}
+	n, ok := c.counts[key]
+	if !ok {
+		n = new(int64)
+		c.counts[key] = n
+	}
+	return n
+}
+
+// prune drops the counters of the nodes that left pool.
+func (c *inflightCounters) prune(pool *CandidatePool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.counts) == 0 {
+		return
+	}
+	keep := make(map[string]bool, len(pool.Nodes))
+	for _, node := range pool.Nodes {
+		keep[nodeKey(node)] = true
+	}
+	for key := range c.counts {
+		if !keep[key] {
+			delete(c.counts, key)
+		}
+	}
+}
+
+// release decrements n, never below zero.
+func release(n *int64) {
+	for {
+		v := atomic.LoadInt64(n)
+		if v <= 0 || atomic.CompareAndSwapInt64(n, v, v-1) {
+			return
+		}
+	}
+}
+
+// SetLeastInFlight makes SelectNode pick the node with the lowest
+// in-flight requests per unit of CurrentFactor, reacting to bursts the
+// Consul CPU data is too slow to show. Requests are counted with Acquire
+// or SelectNodeAcquire.
+func (r *ConsulResolver) SetLeastInFlight(enabled bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.leastInFlight = enabled
+}
+
+// Acquire counts a request to node as in flight until the returned func,
+// safe to call more than once, is called.
+func (r *ConsulResolver) Acquire(node *ServiceNode) func() {
+	n := r.inflight.counter(nodeKey(node))
+	atomic.AddInt64(n, 1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			release(n)
+		})
+	}
+}
+
+// Release ends one request to node counted by Acquire. A Release without
+// an Acquire is ignored once the count is zero.
+func (r *ConsulResolver) Release(node *ServiceNode) {
+	release(r.inflight.counter(nodeKey(node)))
+}
+
+// InFlight returns the requests to node currently in flight.
+func (r *ConsulResolver) InFlight(node *ServiceNode) int64 {
+	return atomic.LoadInt64(r.inflight.counter(nodeKey(node)))
+}
+
+// SelectNodeAcquire selects a node and acquires it. Call done when the
+// request finishes.
+func (r *ConsulResolver) SelectNodeAcquire() (node *ServiceNode, done func()) {
+	node = r.SelectNode()
+	if node == nil {
+		return nil, func() {}
+	}
+	return node, r.Acquire(node)
+}
+
+// pickLeastInFlight must be called with r.mutex held. Ties go to the
+// higher factor, then to smooth weighted round robin order. Nodes without
+// a positive factor are picked, fewest in flight first, only when no other
+// node is left.
+func (r *ConsulResolver) pickLeastInFlight(skip func(i int) bool) int {
+	pool := r.candidatePool
+	best := math.Inf(1)
+	candidates := make(map[int]bool)
+	var fallback map[int]bool
+	fallbackBest := int64(math.MaxInt64)
+	for i, node := range pool.Nodes {
+		if skip != nil && skip(i) {
+			continue
+		}
+		inflight := atomic.LoadInt64(r.inflight.counter(nodeKey(node)))
+		if pool.Factors[i] <= 0 {
+			if inflight < fallbackBest {
+				fallbackBest = inflight
+				fallback = map[int]bool{i: true}
+			} else if inflight == fallbackBest {
+				fallback[i] = true
+			}
+			continue
+		}
+		score := float64(inflight+1) / pool.Factors[i]
+		if score < best {
+			best = score
+			candidates = map[int]bool{i: true}
+		} else if score == best {
+			candidates[i] = true
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = fallback
+	}
+	if len(candidates) <= 1 {
+		for i := range candidates {
+			return i
+		}
+		return -1
+	}
+	return r.pickWeighted(func(i int) bool {
+		return !candidates[i]
+	})
+}
@@ -0,0 +1,99 @@
+package balancer
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// SetKVWatch keeps a blocking query open on the cpu threshold, zone cpu,
+// online lab and instance factor keys. A change to any of them triggers an
+// update right away, and the update cycles read the watched keys from the
+// latest query result instead of polling Consul. It must be called before
+// Start.
+func (r *ConsulResolver) SetKVWatch(watch bool) {
+	r.kvWatch = watch
+}
+
+func (r *ConsulResolver) startKVWatch() {
+	if !r.kvWatch || !r.readsDocuments() {
+		return
+	}
+	ctx, cancel := context.WithCancel(r.lifecycleContext())
+	r.kvWatchCancel = cancel
+	r.kvMutex.Lock()
+	r.kvCache = make(map[string]*api.KVPair)
+	r.kvMutex.Unlock()
+	keys := []string{
+		r.configKey(r.cpuThresholdKey),
+		r.zoneCPUKey,
+		r.configKey(r.onlineLabKey),
+		r.instanceFactorKey,
+	}
+	for _, key := range keys {
+		go r.watchKey(ctx, key)
+	}
+}
+
+func (r *ConsulResolver) stopKVWatch() {
+	if r.kvWatchCancel != nil {
+		r.kvWatchCancel()
+	}
+}
+
+func (r *ConsulResolver) watchKey(ctx context.Context, key string) {
+	var index uint64
+	for {
+		qm := r.queryOptions(&api.QueryOptions{WaitIndex: index, WaitTime: STREAMING_WAIT_TIME})
+		res, meta, err := r.client.KV().Get(key, qm.WithContext(ctx))
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			r.logger.Warnf("watch key %s failed. err: %s", key, err.Error())
+			r.kvMutex.Lock()
+			delete(r.kvCache, key)
+			r.kvMutex.Unlock()
+			select {
+			case <-time.After(r.interval):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		if meta.LastIndex < index {
+			index = 0
+			continue
+		}
+		if meta.LastIndex == index {
+			continue
+		}
+		first := index == 0
+		index = meta.LastIndex
+
+		r.kvMutex.Lock()
+		r.kvCache[key] = res
+		r.kvMutex.Unlock()
+		if first {
+			continue
+		}
+		r.logger.Debugf("key %s changed, index: %d", key, index)
+		if err := r.updateNow(); err != nil {
+			r.logger.Warnf("updateAll failed. err: %s", err.Error())
+		}
+	}
+}
+
+// watchedKV returns the latest value of a watched key, or of a key listed
+// by the ResolverManager. A nil pair with ok set means the key does not
+// exist.
+func (r *ConsulResolver) watchedKV(key string) (pair *api.KVPair, ok bool) {
+	r.kvMutex.Lock()
+	pair, ok = r.kvCache[key]
+	r.kvMutex.Unlock()
+	if !ok && r.manager != nil {
+		return r.manager.lookup(key)
+	}
+	return pair, ok
+}
@@ -0,0 +1,38 @@
+package balancer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKVWatch(t *testing.T) {
+	Convey("Test KVWatch", t, func() {
+		f, server := newFakeConsul()
+		defer server.Close()
+		f.blocking = true
+		f.kvIndex = 1
+		r := newFakeResolver(server.URL)
+		r.SetKVWatch(true)
+		So(r.Start(), ShouldBeNil)
+		defer r.Stop()
+
+		Convey("Given a watched key changed, the update lands before the health query times out", func() {
+			// let the watches read their first index
+			time.Sleep(50 * time.Millisecond)
+			generation := r.Generation()
+			f.mutex.Lock()
+			f.kv["clb/cpu"] = balancer.CPUThreshold{CThreshold: 60}
+			f.kvIndex++
+			f.mutex.Unlock()
+			start := time.Now()
+			for r.Generation() == generation && time.Since(start) < 2*time.Second {
+				time.Sleep(5 * time.Millisecond)
+			}
+			So(r.Generation(), ShouldBeGreaterThan, generation)
+			So(time.Since(start), ShouldBeLessThan, 500*time.Millisecond)
+		})
+	})
+}
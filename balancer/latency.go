@@ -0,0 +1,102 @@
+package balancer
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const LATENCY_SAMPLE_SIZE = 128
+
+type latencySamples struct {
+	samples []time.Duration
+	next    int
+}
+
+func (s *latencySamples) add(d time.Duration) {
+	if len(s.samples) < LATENCY_SAMPLE_SIZE {
+		s.samples = append(s.samples, d)
+		return
+	}
+	s.samples[s.next] = d
+	s.next = (s.next + 1) % LATENCY_SAMPLE_SIZE
+}
+
+func (s *latencySamples) percentile(p float64) time.Duration {
+	sorted := make([]time.Duration, len(s.samples))
+	copy(sorted, s.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(p*float64(len(sorted)-1))]
+}
+
+type latencyTracker struct {
+	mutex sync.Mutex
+	nodes map[string]*latencySamples
+}
+
+// SetSlowNodeSkip makes SelectNode skip nodes whose p90 latency exceeds
+// multiple times the median latency of the pool. Latencies are reported
+// with ObserveLatency; the slow set is recomputed every update cycle.
+// Zero disables skipping.
+func (r *ConsulResolver) SetSlowNodeSkip(multiple float64) {
+	r.slowMultiple = multiple
+	if multiple > 0 && r.latency == nil {
+		r.latency = &latencyTracker{nodes: make(map[string]*latencySamples)}
+	}
+}
+
+// ObserveLatency records the latency of a request sent to node.
+func (r *ConsulResolver) ObserveLatency(node *ServiceNode, latency time.Duration) {
+	if r.latency == nil || node == nil {
+		return
+	}
+	r.latency.mutex.Lock()
+	defer r.latency.mutex.Unlock()
+	key := nodeKey(node)
+	s, ok := r.latency.nodes[key]
+	if !ok {
+		s = &latencySamples{}
+		r.latency.nodes[key] = s
+	}
+	s.add(latency)
+}
+
+// updateSlowNodes recomputes the slow node set for the nodes in pool.
+func (r *ConsulResolver) updateSlowNodes(pool *CandidatePool) map[string]bool {
+	if r.latency == nil || r.slowMultiple <= 0 {
+		return nil
+	}
+	r.latency.mutex.Lock()
+	defer r.latency.mutex.Unlock()
+	p50 := make([]time.Duration, 0, len(pool.Nodes))
+	p90 := make(map[string]time.Duration, len(pool.Nodes))
+	alive := make(map[string]bool, len(pool.Nodes))
+	for _, node := range pool.Nodes {
+		key := nodeKey(node)
+		alive[key] = true
+		s, ok := r.latency.nodes[key]
+		if !ok || len(s.samples) == 0 {
+			continue
+		}
+		p50 = append(p50, s.percentile(0.5))
+		p90[key] = s.percentile(0.9)
+	}
+	for key := range r.latency.nodes {
+		if !alive[key] {
+			delete(r.latency.nodes, key)
+		}
+	}
+	if len(p50) == 0 {
+		return nil
+	}
+	sort.Slice(p50, func(i, j int) bool { return p50[i] < p50[j] })
+	median := p50[len(p50)/2]
+	slow := make(map[string]bool)
+	for key, d := range p90 {
+		if float64(d) > float64(median)*r.slowMultiple {
+			slow[key] = true
+			r.logger.Debugf("node %s is slow, p90: %s, pool median: %s", key, d, median)
+		}
+	}
+	return slow
+}
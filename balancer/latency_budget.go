@@ -0,0 +1,33 @@
+package balancer
+
+import (
+	"time"
+)
+
+const LATENCY_BUDGET_SHARE = 0.1
+
+// SetRequestDeadline sets the typical deadline of requests sent to the
+// service. Together with the onlineLab crossZoneLatencyMs it discounts
+// cross-zone factors for latency-sensitive callers.
+func (r *ConsulResolver) SetRequestDeadline(deadline time.Duration) {
+	r.requestDeadline = deadline
+}
+
+// crossZoneDiscount returns the multiplier for cross-zone factors. The
+// inter-zone penalty may use latencyBudgetShare (default 0.1) of the
+// request deadline for free; beyond that, factors shrink in proportion.
+func (r *ConsulResolver) crossZoneDiscount() float64 {
+	penalty := r.onlineLab.CrossZoneLatencyMs
+	if penalty <= 0 || r.requestDeadline <= 0 {
+		return 1
+	}
+	share := r.onlineLab.LatencyBudgetShare
+	if share <= 0 {
+		share = LATENCY_BUDGET_SHARE
+	}
+	budget := float64(r.requestDeadline) / float64(time.Millisecond) * share
+	if penalty <= budget {
+		return 1
+	}
+	return budget / penalty
+}
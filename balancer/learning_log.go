@@ -0,0 +1,24 @@
+package balancer
+
+import (
+	"github.com/mae-pax/consul-loadbalancer/util"
+)
+
+// SetLearningLogger sends the per-cycle balanceFactor computation trace to
+// logger instead of the resolver logger, e.g. a util.RingLogger or a
+// rotating util.FileLogger, so it stays available without filling the
+// service logs.
+func (r *ConsulResolver) SetLearningLogger(logger util.Logger) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.learningLog = logger
+}
+
+func (r *ConsulResolver) learningLogger() util.Logger {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.learningLog != nil {
+		return r.redacted(r.learningLog)
+	}
+	return r.logger
+}
@@ -0,0 +1,49 @@
+package balancer
+
+import (
+	"context"
+	"time"
+)
+
+// lifecycleContext is the context of the started resolver. It is canceled
+// by Stop, which aborts the Consul calls in flight, blocking queries
+// included.
+func (r *ConsulResolver) lifecycleContext() context.Context {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.lifecycleCtx == nil {
+		return context.Background()
+	}
+	return r.lifecycleCtx
+}
+
+func (r *ConsulResolver) setLifecycle(ctx context.Context, cancel context.CancelFunc) {
+	r.mutex.Lock()
+	r.lifecycleCtx = ctx
+	r.lifecycleCancel = cancel
+	r.mutex.Unlock()
+}
+
+func (r *ConsulResolver) cancelLifecycle() {
+	r.mutex.Lock()
+	cancel := r.lifecycleCancel
+	r.lifecycleCtx = nil
+	r.lifecycleCancel = nil
+	r.mutex.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// callContext is the context of one Consul call in the running update,
+// bounded by timeout when it is positive.
+func (r *ConsulResolver) callContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	return withTimeout(r.updateContext(), timeout)
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
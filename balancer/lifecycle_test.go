@@ -0,0 +1,304 @@
+package balancer_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	"github.com/mae-pax/consul-loadbalancer/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStartContext(t *testing.T) {
+	Convey("Test StartContext", t, func() {
+		_, server := newFakeConsul()
+		defer server.Close()
+		r := newFakeResolver(server.URL)
+		Convey("Given the start context is canceled, the resolver stops and Stop returns", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			So(r.StartContext(ctx), ShouldBeNil)
+			cancel()
+			stopCtx, stopCancel := context.WithTimeout(context.Background(), time.Second)
+			defer stopCancel()
+			So(r.StopContext(stopCtx), ShouldBeNil)
+			_, err := r.SelectNodeE()
+			So(err, ShouldEqual, balancer.ErrStopped)
+		})
+	})
+}
+
+func TestDefaultLogger(t *testing.T) {
+	Convey("Test default logger", t, func() {
+		_, server := newFakeConsul()
+		defer server.Close()
+		config := api.DefaultConfig()
+		config.Address = strings.TrimPrefix(server.URL, "http://")
+		r, err := balancer.NewConsulResolverWithConfig("", config, "as", "clb/cpu", "clb/zone", "clb/factor", "clb/lab", 10*time.Millisecond, time.Second)
+		So(err, ShouldBeNil)
+		r.SetZone("a")
+
+		Convey("Given no logger, the resolver runs and takes one while running", func() {
+			So(r.Start(), ShouldBeNil)
+			defer r.Stop()
+			ring := util.NewRingLogger(100)
+			r.SetLogger(ring)
+			So(r.Update(), ShouldBeNil)
+			So(ring.Lines(), ShouldNotBeEmpty)
+			r.SetLogger(nil)
+			So(r.Update(), ShouldBeNil)
+		})
+	})
+}
+
+func TestStopCancelsCalls(t *testing.T) {
+	Convey("Test Stop cancels Consul calls", t, func() {
+		f, server := newFakeConsul()
+		defer server.Close()
+		r := newFakeResolver(server.URL)
+		So(r.Start(), ShouldBeNil)
+		Convey("Given a hanging Consul, Stop aborts the update in flight", func() {
+			f.mutex.Lock()
+			f.hang = make(chan struct{})
+			f.mutex.Unlock()
+			defer close(f.hang)
+			errs := make(chan error, 1)
+			go func() { errs <- r.Update() }()
+			time.Sleep(20 * time.Millisecond)
+			r.Stop()
+			select {
+			case err := <-errs:
+				So(err, ShouldNotBeNil)
+			case <-time.After(500 * time.Millisecond):
+				So("update still running", ShouldBeEmpty)
+			}
+		})
+	})
+}
+
+func TestRestart(t *testing.T) {
+	Convey("Test Start and Stop", t, func() {
+		_, server := newFakeConsul()
+		defer server.Close()
+		r := newFakeResolver(server.URL)
+		Convey("Given Stop before Start and twice after, nothing blocks", func() {
+			r.Stop()
+			So(r.Start(), ShouldBeNil)
+			So(r.Start(), ShouldBeNil)
+			r.Stop()
+			r.Stop()
+			_, err := r.SelectNodeE()
+			So(err, ShouldEqual, balancer.ErrStopped)
+		})
+		Convey("Given Start after Stop, the resolver serves again", func() {
+			So(r.Start(), ShouldBeNil)
+			r.Stop()
+			So(r.Start(), ShouldBeNil)
+			defer r.Stop()
+			node, err := r.SelectNodeE()
+			So(err, ShouldBeNil)
+			So(node, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestResolverPairStop(t *testing.T) {
+	Convey("Test ResolverPair Stop", t, func() {
+		_, server := newFakeConsul()
+		defer server.Close()
+		Convey("Given a failed Start, Stop returns", func() {
+			p := balancer.NewResolverPair(newFakeResolver("http://127.0.0.1:1"), newFakeResolver("http://127.0.0.1:1"), 3)
+			So(p.Start(), ShouldNotBeNil)
+			p.Stop()
+		})
+		Convey("Given a started pair, Stop twice returns and Start runs again", func() {
+			p := balancer.NewResolverPair(newFakeResolver(server.URL), newFakeResolver(server.URL), 3)
+			So(p.Start(), ShouldBeNil)
+			p.Stop()
+			p.Stop()
+			So(p.Start(), ShouldBeNil)
+			defer p.Stop()
+			So(p.SelectNode(), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestStreamingWatchFailure(t *testing.T) {
+	Convey("Test a failing service watch", t, func() {
+		f, server := newFakeConsul()
+		defer server.Close()
+		r := newFakeResolver(server.URL)
+		r.SetStreaming(true)
+		So(r.Start(), ShouldBeNil)
+		defer r.Stop()
+
+		Convey("Given the watch fails, updates poll the service and fail with it", func() {
+			// let the watch answer first
+			time.Sleep(50 * time.Millisecond)
+			f.mutex.Lock()
+			f.failing["health"] = 1 << 20
+			f.mutex.Unlock()
+			var err error
+			for i := 0; i < 100 && err == nil; i++ {
+				time.Sleep(10 * time.Millisecond)
+				err = r.Update()
+			}
+			So(err, ShouldNotBeNil)
+			So(r.Health().Sources[balancer.SOURCE_HEALTH].LastError, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestStaleRefresh(t *testing.T) {
+	Convey("Test stale refreshes", t, func() {
+		_, server := newFakeConsul()
+		r := newFakeResolver(server.URL)
+		So(r.Start(), ShouldBeNil)
+		defer r.Stop()
+		var errs int
+		r.OnUpdateError(func(stage string, err error) { errs++ })
+
+		Convey("Given Consul unreachable, one refresh is tried per interval", func() {
+			server.Close()
+			r.MaxStaleness(time.Millisecond)
+			time.Sleep(5 * time.Millisecond)
+			_, err := r.SelectNodeFresh()
+			So(err, ShouldHaveSameTypeAs, &balancer.StaleError{})
+			So(errs, ShouldBeGreaterThan, 0)
+			tried := errs
+			for i := 0; i < 10; i++ {
+				_, err = r.SelectNodeFresh()
+				So(err, ShouldHaveSameTypeAs, &balancer.StaleError{})
+			}
+			So(errs, ShouldEqual, tried)
+		})
+	})
+}
+
+func TestDNSFallback(t *testing.T) {
+	Convey("Test DNSFallback", t, func() {
+		f, server := newFakeConsul()
+		defer server.Close()
+		f.mutex.Lock()
+		f.failing["health"] = 1000
+		f.mutex.Unlock()
+		r := newFakeResolver(server.URL)
+
+		Convey("Given no fallback, Start fails", func() {
+			So(r.Start(), ShouldNotBeNil)
+		})
+		Convey("Given a fallback host, the resolver serves it until Consul recovers", func() {
+			r.SetDNSFallback("localhost", 8080)
+			So(r.Start(), ShouldBeNil)
+			defer r.Stop()
+			So(r.Degraded(), ShouldBeTrue)
+			node := r.SelectNode()
+			So(node, ShouldNotBeNil)
+			So(node.Port, ShouldEqual, 8080)
+
+			f.mutex.Lock()
+			f.failing["health"] = 0
+			f.mutex.Unlock()
+			So(r.Update(), ShouldBeNil)
+			So(r.Degraded(), ShouldBeFalse)
+			So(len(r.CandidateNodes()), ShouldEqual, 2)
+		})
+	})
+}
+
+func TestSavedPoolFallback(t *testing.T) {
+	Convey("Test SavedPoolFallback", t, func() {
+		f, server := newFakeConsul()
+		defer server.Close()
+		dir, err := ioutil.TempDir("", "clb-state")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		store := balancer.FileStore{Dir: dir}
+
+		Convey("Given a saved state, a resolver starting without Consul serves the saved pool", func() {
+			r := newFakeResolver(server.URL)
+			r.SetStateStore(store, "as/state", 0)
+			So(r.Start(), ShouldBeNil)
+			var data []byte
+			for i := 0; i < 100 && data == nil; i++ {
+				time.Sleep(10 * time.Millisecond)
+				data, _ = store.Load("as/state")
+			}
+			r.Stop()
+			So(data, ShouldNotBeNil)
+
+			f.mutex.Lock()
+			f.failing["health"] = 1000
+			f.mutex.Unlock()
+			restarted := newFakeResolver(server.URL)
+			restarted.SetStateStore(store, "as/state", 0)
+			So(restarted.Start(), ShouldBeNil)
+			defer restarted.Stop()
+			So(restarted.Degraded(), ShouldBeTrue)
+			So(len(restarted.CandidateNodes()), ShouldEqual, 2)
+		})
+	})
+}
+
+func TestFallbackNodes(t *testing.T) {
+	Convey("Test FallbackNodes", t, func() {
+		f, server := newFakeConsul()
+		defer server.Close()
+		r := newFakeResolver(server.URL)
+		r.SetFallbackNodes([]balancer.ServiceNode{{InstanceID: "seed-1", Host: "10.1.0.1", Port: 80, Zone: "a"}}, 0)
+
+		Convey("Given no passing instance, the fallback nodes are selected", func() {
+			So(r.Start(), ShouldBeNil)
+			defer r.Stop()
+			f.mutex.Lock()
+			f.nodes = nil
+			f.mutex.Unlock()
+			So(r.Update(), ShouldBeNil)
+			node := r.SelectNode()
+			So(node, ShouldNotBeNil)
+			So(node.InstanceID, ShouldEqual, "seed-1")
+		})
+		Convey("Given Consul down at start, the fallback nodes are served", func() {
+			f.mutex.Lock()
+			f.failing["health"] = 1000
+			f.mutex.Unlock()
+			So(r.Start(), ShouldBeNil)
+			defer r.Stop()
+			So(r.Degraded(), ShouldBeTrue)
+			So(r.SelectNode().InstanceID, ShouldEqual, "seed-1")
+		})
+	})
+}
+
+func TestZoneDetection(t *testing.T) {
+	Convey("Test zone detection", t, func() {
+		_, server := newFakeConsul()
+		defer server.Close()
+		b := &balancer.ConsulResolverBuilder{Address: strings.TrimPrefix(server.URL, "http://"), Service: "as", Interval: time.Minute, Timeout: time.Second}
+
+		Convey("Given a zone provider, Build sets its zone", func() {
+			b.ZoneProvider = util.StaticZone("b")
+			r, err := b.Build()
+			So(err, ShouldBeNil)
+			So(r.Zone(), ShouldEqual, "b")
+		})
+		Convey("Given a failing zone provider, Build fails", func() {
+			b.ZoneProvider = util.StaticZone("")
+			_, err := b.Build()
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Given no zone set, Start finds it", func() {
+			t.Setenv(util.ZONE_ENV, "c")
+			r := newFakeResolver(server.URL)
+			r.SetZone("")
+			So(r.Zone(), ShouldBeEmpty)
+			So(r.Start(), ShouldBeNil)
+			defer r.Stop()
+			So(r.Zone(), ShouldEqual, "c")
+		})
+	})
+}
@@ -0,0 +1,78 @@
+package balancer
+
+import (
+	"math"
+)
+
+// LocalityWeights multiplies the factor of local zone nodes sharing the
+// client's physical host or rack, so they are preferred over the rest of
+// the zone. Values <= 0 mean 1.
+//
+// The preference follows the zone→rack→node hierarchy: a rack whose
+// workload exceeds its zone by RackRateThreshold loses the same-rack
+// preference, and a node whose workload exceeds its rack by
+// HostRateThreshold loses the same-host preference. Zero thresholds
+// disable the check.
+type LocalityWeights struct {
+	SameHost          float64 `json:"sameHost"`
+	SameRack          float64 `json:"sameRack"`
+	RackRateThreshold float64 `json:"rackRateThreshold"`
+	HostRateThreshold float64 `json:"hostRateThreshold"`
+}
+
+// ServiceRack groups the nodes of a zone sharing a rack.
+type ServiceRack struct {
+	Nodes    []*ServiceNode
+	Rack     string
+	WorkLoad float64
+}
+
+// SetLocality sets the physical host and rack of the client, matched
+// against the hostID and rack meta of service nodes.
+func (r *ConsulResolver) SetLocality(hostID, rack string) {
+	r.hostID = hostID
+	r.rack = rack
+}
+
+func (r *ConsulResolver) localityWeight(node *ServiceNode, zone *ServiceZone) float64 {
+	lw := r.onlineLab.Locality
+	if lw == nil {
+		return 1
+	}
+	rack := zone.Racks[node.Rack]
+	if r.hostID != "" && node.HostID == r.hostID && lw.SameHost > 0 {
+		if rack == nil || lw.HostRateThreshold <= 0 || (node.WorkLoad-rack.WorkLoad)/100.0 <= lw.HostRateThreshold {
+			return lw.SameHost
+		}
+		r.logger.Debugf("host %s overloaded, workload: %f, rack workload: %f", node.HostID, node.WorkLoad, rack.WorkLoad)
+	}
+	if r.rack != "" && rack != nil && rack.Rack == r.rack && lw.SameRack > 0 {
+		if lw.RackRateThreshold <= 0 || (rack.WorkLoad-zone.WorkLoad)/100.0 <= lw.RackRateThreshold {
+			return lw.SameRack
+		}
+		r.logger.Debugf("rack %s overloaded, workload: %f, zone workload: %f", rack.Rack, rack.WorkLoad, zone.WorkLoad)
+	}
+	return 1
+}
+
+func buildRacks(zone *ServiceZone) {
+	zone.Racks = make(map[string]*ServiceRack)
+	for _, node := range zone.Nodes {
+		if node.Rack == "" {
+			continue
+		}
+		rack, ok := zone.Racks[node.Rack]
+		if !ok {
+			rack = &ServiceRack{Rack: node.Rack}
+			zone.Racks[node.Rack] = rack
+		}
+		rack.Nodes = append(rack.Nodes, node)
+	}
+	for _, rack := range zone.Racks {
+		var sum float64
+		for _, node := range rack.Nodes {
+			sum += node.WorkLoad
+		}
+		rack.WorkLoad = sum / math.Max(1, float64(len(rack.Nodes)))
+	}
+}
@@ -0,0 +1,190 @@
+package balancer
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mae-pax/consul-loadbalancer/util"
+)
+
+// ResolverManager runs the resolvers of many services in one process over
+// a shared consul client. Their update cycles run concurrently, spread
+// evenly over the interval instead of all hitting the agent at once, so a
+// slow service does not hold back the others, and the KV documents
+// under the shared prefixes are read with one list query per interval
+// instead of one get per key and resolver.
+type ResolverManager struct {
+	client    *api.Client
+	interval  time.Duration
+	logger    util.Logger
+	mutex     sync.RWMutex
+	resolvers []*ConsulResolver
+	prefixes  []string
+	kv        map[string]*api.KVPair
+	lifecycle sync.Mutex
+	started   bool
+	cancel    context.CancelFunc
+	loops     sync.WaitGroup
+}
+
+func NewResolverManager(client *api.Client, interval time.Duration, logger util.Logger) *ResolverManager {
+	if logger == nil {
+		logger = util.NopLogger{}
+	}
+	return &ResolverManager{
+		client:   client,
+		interval: interval,
+		logger:   logger,
+	}
+}
+
+// SetKVPrefixes lists the KV prefixes read with one list query per
+// interval. Keys of the resolvers under a prefix are served from that
+// list; a key missing from it is treated as missing in Consul.
+func (m *ResolverManager) SetKVPrefixes(prefixes ...string) {
+	m.prefixes = prefixes
+}
+
+// NewResolver creates a resolver on the shared client and adds it to the
+// manager. It must be called before Start.
+func (m *ResolverManager) NewResolver(cloud, service, cpuThresholdKey, zoneCPUKey, instanceFactorKey, onlineLabKey string, timeout time.Duration, args ...string) *ConsulResolver {
+	r := NewConsulResolverWithClient(cloud, m.client, service, cpuThresholdKey, zoneCPUKey, instanceFactorKey, onlineLabKey, m.interval, timeout, args...)
+	r.SetLogger(m.logger)
+	m.Add(r)
+	return r
+}
+
+// Add adds a resolver built elsewhere. Its update cycle is run by the
+// manager, so it must not be started on its own.
+func (m *ResolverManager) Add(r *ConsulResolver) {
+	r.manager = m
+	m.resolvers = append(m.resolvers, r)
+}
+
+// Resolver returns the resolver of service, or nil.
+func (m *ResolverManager) Resolver(service string) *ConsulResolver {
+	for _, r := range m.resolvers {
+		if r.service == service {
+			return r
+		}
+	}
+	return nil
+}
+
+// Start runs the first update of every resolver and then their staggered
+// update cycles. It fails if any resolver fails to start.
+func (m *ResolverManager) Start() error {
+	m.lifecycle.Lock()
+	defer m.lifecycle.Unlock()
+	if m.started {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.refreshKV(ctx)
+	for i, r := range m.resolvers {
+		if err := r.startBackground(ctx); err != nil {
+			for _, started := range m.resolvers[:i] {
+				started.stopBackground()
+				started.setRunning(false)
+			}
+			cancel()
+			return err
+		}
+	}
+	m.started = true
+	m.cancel = cancel
+	for i, r := range m.resolvers {
+		offset := m.interval * time.Duration(i+1) / time.Duration(len(m.resolvers))
+		m.loops.Add(1)
+		go m.loop(ctx, r, offset, i == 0)
+	}
+	return nil
+}
+
+// loop runs the update cycles of r every interval, the first one offset
+// after Start. The first resolver refreshes the listed KV before its
+// cycles.
+func (m *ResolverManager) loop(ctx context.Context, r *ConsulResolver, offset time.Duration, refresh bool) {
+	defer m.loops.Done()
+	timer := time.NewTimer(offset)
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		timer.Stop()
+		return
+	}
+	tk := time.NewTicker(m.interval)
+	defer tk.Stop()
+	for {
+		if refresh {
+			m.refreshKV(ctx)
+		}
+		if err := r.updateAll(); err != nil {
+			r.logger.Warnf("updateAll failed. err: %s", err.Error())
+		}
+		select {
+		case <-tk.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Stop stops every resolver, canceling the updates in progress. Stopping
+// a manager that is not started does nothing.
+func (m *ResolverManager) Stop() {
+	m.lifecycle.Lock()
+	defer m.lifecycle.Unlock()
+	if !m.started {
+		return
+	}
+	m.started = false
+	m.cancel()
+	m.loops.Wait()
+	for _, r := range m.resolvers {
+		r.stopBackground()
+		r.setRunning(false)
+	}
+}
+
+func (m *ResolverManager) refreshKV(ctx context.Context) {
+	if len(m.prefixes) == 0 {
+		return
+	}
+	kv := make(map[string]*api.KVPair)
+	for _, prefix := range m.prefixes {
+		pairs, _, err := m.client.KV().List(prefix, (&api.QueryOptions{}).WithContext(ctx))
+		if err != nil {
+			m.logger.Warnf("list kv prefix %s failed, resolvers fall back to get. err: %s", prefix, err.Error())
+			m.mutex.Lock()
+			m.kv = nil
+			m.mutex.Unlock()
+			return
+		}
+		for _, pair := range pairs {
+			kv[pair.Key] = pair
+		}
+	}
+	m.mutex.Lock()
+	m.kv = kv
+	m.mutex.Unlock()
+}
+
+// lookup returns the listed value of key. ok is false when key is not
+// under a listed prefix or the last list failed.
+func (m *ResolverManager) lookup(key string) (pair *api.KVPair, ok bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if m.kv == nil {
+		return nil, false
+	}
+	for _, prefix := range m.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return m.kv[key], true
+		}
+	}
+	return nil, false
+}
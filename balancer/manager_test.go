@@ -0,0 +1,54 @@
+package balancer_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestResolverManager(t *testing.T) {
+	Convey("Test ResolverManager", t, func() {
+		f, server := newFakeConsul()
+		defer server.Close()
+		config := api.DefaultConfig()
+		config.Address = strings.TrimPrefix(server.URL, "http://")
+		client, err := api.NewClient(config)
+		So(err, ShouldBeNil)
+		m := balancer.NewResolverManager(client, time.Minute, nil)
+		m.SetKVPrefixes("clb/")
+		for _, service := range []string{"as", "bs"} {
+			r := m.NewResolver("", service, "clb/cpu", "clb/zone", "clb/factor", "clb/lab", time.Second)
+			r.SetZone("a")
+		}
+		Convey("Given resolvers under a shared prefix, their keys are read with one list", func() {
+			So(m.Start(), ShouldBeNil)
+			defer m.Stop()
+			f.mutex.Lock()
+			So(f.lists, ShouldEqual, 1)
+			So(f.gets, ShouldEqual, 0)
+			f.mutex.Unlock()
+			So(len(m.Resolver("bs").CandidateNodes()), ShouldEqual, 2)
+			So(m.Resolver("cs"), ShouldBeNil)
+		})
+		Convey("Given updates hanging, Stop cancels them", func() {
+			m := balancer.NewResolverManager(client, 10*time.Millisecond, nil)
+			for _, service := range []string{"as", "bs"} {
+				r := m.NewResolver("", service, "clb/cpu", "clb/zone", "clb/factor", "clb/lab", time.Minute)
+				r.SetZone("a")
+			}
+			So(m.Start(), ShouldBeNil)
+			f.mutex.Lock()
+			f.hang = make(chan struct{})
+			f.mutex.Unlock()
+			defer close(f.hang)
+			time.Sleep(50 * time.Millisecond)
+			start := time.Now()
+			m.Stop()
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+		})
+	})
+}
@@ -0,0 +1,41 @@
+package balancer
+
+import (
+	"time"
+)
+
+// Metrics is a snapshot of the resolver counters.
+type Metrics struct {
+	SelectNum         int
+	CrossZoneNum      int
+	CandidatePoolSize int
+	InvalidFactorNum  int
+	Epoch             uint64
+	LastUpdate        time.Time
+	// LastError is the most recent update error, kept after later updates
+	// succeed; compare LastErrorTime with LastUpdate.
+	LastError     error `json:"-"`
+	LastErrorTime time.Time
+}
+
+// Metrics returns a copy of the resolver counters, safe to call
+// concurrently with SelectNode and updates.
+func (r *ConsulResolver) Metrics() Metrics {
+	var m Metrics
+	r.mutex.Lock()
+	if r.metric != nil {
+		m.SelectNum = r.metric.selectNum
+		m.CrossZoneNum = r.metric.crossZoneNum
+		m.CandidatePoolSize = r.metric.candidatePoolSize
+		m.InvalidFactorNum = r.metric.invalidFactorNum
+	}
+	m.Epoch = r.poolIndex
+	m.LastUpdate = r.lastUpdate
+	r.mutex.Unlock()
+
+	r.errorMutex.Lock()
+	m.LastError = r.lastError
+	m.LastErrorTime = r.lastErrorTime
+	r.errorMutex.Unlock()
+	return m
+}
@@ -0,0 +1,60 @@
+package balancer
+
+const (
+	ADJUST_NONE           = "none"
+	ADJUST_START          = "start"
+	ADJUST_LEARN_UP       = "learn_up"
+	ADJUST_LEARN_DOWN     = "learn_down"
+	ADJUST_CLAMP_MAX      = "clamp_max"
+	ADJUST_CLAMP_MIN      = "clamp_min"
+	ADJUST_LOCALITY       = "locality"
+	ADJUST_CROSS_RATE     = "cross_rate"
+	ADJUST_LATENCY_BUDGET = "latency_budget"
+	ADJUST_DRAIN          = "drain"
+	ADJUST_CORDON         = "cordon"
+	ADJUST_QUARANTINE     = "quarantine"
+	ADJUST_SOFT_REMOVED   = "soft_removed"
+	ADJUST_FEEDBACK       = "feedback"
+	ADJUST_BREAKER        = "breaker"
+	ADJUST_UNREACHABLE    = "unreachable"
+	ADJUST_WARMUP         = "warmup"
+	ADJUST_FLOOR          = "floor"
+	ADJUST_DEPLOY         = "deploy"
+	ADJUST_REMOTE_DC      = "remote_dc"
+)
+
+// MetricsSink receives the gauges the resolver exports every update cycle.
+type MetricsSink interface {
+	SetGauge(name string, value float64, labels map[string]string)
+}
+
+// SetMetricsSink exports, for each candidate node and update cycle, the
+// gauges clb_node_factor, clb_node_workload and clb_node_adjustment, the
+// latter being the factor change since the previous cycle labeled with the
+// last adjustment applied to the node, and per caller label the counters
+// clb_caller_select_total and clb_caller_cross_zone_total.
+func (r *ConsulResolver) SetMetricsSink(sink MetricsSink) {
+	r.metricsSink = sink
+}
+
+func (r *ConsulResolver) exportLearning(pool *CandidatePool) {
+	if r.metricsSink == nil {
+		return
+	}
+	factors := make(map[string]float64, len(pool.Nodes))
+	for i, node := range pool.Nodes {
+		key := nodeKey(node)
+		labels := map[string]string{"service": r.service, "instance": key, "zone": node.Zone}
+		r.metricsSink.SetGauge("clb_node_factor", pool.Factors[i], labels)
+		r.metricsSink.SetGauge("clb_node_workload", node.WorkLoad, labels)
+		delta := 0.0
+		if last, ok := r.lastFactors[key]; ok {
+			delta = pool.Factors[i] - last
+		}
+		r.metricsSink.SetGauge("clb_node_adjustment", delta, map[string]string{
+			"service": r.service, "instance": key, "zone": node.Zone, "reason": node.AdjustReason,
+		})
+		factors[key] = pool.Factors[i]
+	}
+	r.lastFactors = factors
+}
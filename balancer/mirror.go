@@ -0,0 +1,65 @@
+package balancer
+
+import (
+	"sync/atomic"
+
+	"github.com/mae-pax/consul-loadbalancer/util"
+)
+
+// MirrorConfig mirrors Rate, in [0, 1], of selections to the nodes in Zone
+// and/or carrying Tag.
+type MirrorConfig struct {
+	Zone string  `json:"zone"`
+	Tag  string  `json:"tag"`
+	Rate float64 `json:"rate"`
+}
+
+func (m *MirrorConfig) match(node *ServiceNode) bool {
+	if m.Zone != "" && node.Zone != m.Zone {
+		return false
+	}
+	if m.Tag != "" && !node.HasTag(m.Tag) {
+		return false
+	}
+	return true
+}
+
+// HasTag reports whether the node was registered with tag.
+func (n *ServiceNode) HasTag(tag string) bool {
+	for _, t := range n.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectNodeWithMirror is SelectNode, additionally returning a node to
+// mirror the request to for the configured share of selections, or nil.
+func (r *ConsulResolver) SelectNodeWithMirror() (*ServiceNode, *ServiceNode) {
+	node := r.SelectNode()
+	if node == nil {
+		return node, nil
+	}
+	r.mutex.Lock()
+	var mc *MirrorConfig
+	var all []*ServiceNode
+	if r.candidatePool != nil {
+		mc, all = r.candidatePool.mirror, r.candidatePool.allNodes
+	}
+	r.mutex.Unlock()
+	if mc == nil || mc.Rate <= util.FloatPseudoRandom() {
+		return node, nil
+	}
+	var nodes []*ServiceNode
+	for _, n := range all {
+		if mc.match(n) && n != node {
+			nodes = append(nodes, n)
+		}
+	}
+	if len(nodes) == 0 {
+		return node, nil
+	}
+	i := atomic.AddUint64(&r.mirrorNext, 1) - 1
+	return node, nodes[i%uint64(len(nodes))]
+}
@@ -0,0 +1,31 @@
+package balancer
+
+// blendNetwork mixes network utilization into a CPU workload with the
+// onlineLab networkWeight, in [0, 1].
+func (r *ConsulResolver) blendNetwork(cpu, network float64) float64 {
+	w := r.onlineLab.NetworkWeight
+	if w <= 0 {
+		return cpu
+	}
+	if w > 1 {
+		w = 1
+	}
+	return cpu*(1-w) + network*w
+}
+
+// zoneNetwork averages the network utilization reported per zone.
+func zoneNetwork(infos []InstanceMetaInfo) map[string]float64 {
+	sum := make(map[string]float64)
+	count := make(map[string]int)
+	for _, v := range infos {
+		if v.NetworkUtilization == nil {
+			continue
+		}
+		sum[v.Zone] += *v.NetworkUtilization
+		count[v.Zone]++
+	}
+	for zone := range sum {
+		sum[zone] /= float64(count[zone])
+	}
+	return sum
+}
@@ -0,0 +1,95 @@
+package balancer
+
+import (
+	"sync/atomic"
+)
+
+// NodePicker picks one node out of nodes, returning nil if there is none.
+type NodePicker func(nodes []*ServiceNode) *ServiceNode
+
+// NewRoundRobinPicker returns a NodePicker cycling through nodes in order.
+func NewRoundRobinPicker() NodePicker {
+	var next uint64
+	return func(nodes []*ServiceNode) *ServiceNode {
+		if len(nodes) == 0 {
+			return nil
+		}
+		i := atomic.AddUint64(&next, 1) - 1
+		return nodes[i%uint64(len(nodes))]
+	}
+}
+
+// SetObserverMode keeps the resolver learning and recording its own
+// selections, while the node returned by SelectNode comes from fallback.
+// Passing nil leaves observer mode.
+func (r *ConsulResolver) SetObserverMode(fallback NodePicker) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.observerPicker = fallback
+}
+
+func zoneNodes(serviceZones []*ServiceZone) []*ServiceNode {
+	var nodes []*ServiceNode
+	for _, serviceZone := range serviceZones {
+		nodes = append(nodes, serviceZone.Nodes...)
+	}
+	return nodes
+}
+
+// allNodes returns the nodes of every zone as of the serving pool. It must
+// be called with r.mutex held.
+func (r *ConsulResolver) allNodes() []*ServiceNode {
+	if r.candidatePool == nil {
+		return nil
+	}
+	return r.candidatePool.allNodes
+}
+
+func (r *ConsulResolver) observe(node *ServiceNode) *ServiceNode {
+	r.mutex.Lock()
+	picker := r.observerPicker
+	var nodes []*ServiceNode
+	if picker != nil {
+		nodes = r.allNodes()
+	}
+	r.mutex.Unlock()
+	if picker == nil {
+		return node
+	}
+	picked := picker(nodes)
+	if node != nil && picked != nil {
+		r.logger.Debugf("observer mode, resolver select: %s, fallback select: %s", node.InstanceID, picked.InstanceID)
+	}
+	return picked
+}
+
+// observeNodes is observe for SelectNodes: in observer mode it returns as
+// many distinct nodes as the fallback picks.
+func (r *ConsulResolver) observeNodes(nodes []*ServiceNode) []*ServiceNode {
+	r.mutex.Lock()
+	picker := r.observerPicker
+	var remaining []*ServiceNode
+	if picker != nil {
+		remaining = append(remaining, r.allNodes()...)
+	}
+	r.mutex.Unlock()
+	if picker == nil || len(nodes) == 0 {
+		return nodes
+	}
+	picked := make([]*ServiceNode, 0, len(nodes))
+	for len(picked) < len(nodes) && len(remaining) > 0 {
+		node := picker(remaining)
+		if node == nil {
+			break
+		}
+		picked = append(picked, node)
+		for i, v := range remaining {
+			if v == node {
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				break
+			}
+		}
+	}
+	r.logger.Debugf("observer mode, resolver select %d nodes, fallback select %d", len(nodes), len(picked))
+	return picked
+}
@@ -0,0 +1,144 @@
+package balancer
+
+import (
+	"sync"
+	"time"
+)
+
+// SetPartialUpdates fetches the cpu threshold, zone cpu, online lab and
+// instance factor keys and the service health concurrently. When one of
+// them fails, its previous value is kept and the cycle goes on with the
+// others instead of failing as a whole, so one transient KV error does not
+// hold back the service node refresh for a full interval. Failed sources
+// are still counted in ErrorCounts.
+func (r *ConsulResolver) SetPartialUpdates(partial bool) {
+	r.updateMutex.Lock()
+	r.partialUpdates = partial
+	r.updateMutex.Unlock()
+}
+
+// updatePartial is the update cycle of SetPartialUpdates. It fails only
+// when the online lab or the service nodes have no previous value to keep.
+// It must be called with r.updateMutex held.
+func (r *ConsulResolver) updatePartial() error {
+	if err := r.updateDiscovery(); err != nil {
+		r.sourceFailed(err)
+	}
+	if err := r.updateConfigSet(); err != nil {
+		r.sourceFailed(err)
+	}
+	if err := r.updateSettings(); err != nil {
+		r.sourceFailed(err)
+	}
+
+	streamNodes := r.streamedNodes()
+	var (
+		wg        sync.WaitGroup
+		ct        *CPUThreshold
+		zc        *ZoneCPUUtilizationRatio
+		ol        *OnlineLab
+		inf       *InstanceFactor
+		nodes     []ServiceNode
+		errs      [5]error
+		fetchNode = streamNodes == nil && (r.fetchedNodes == nil || r.sourceDue(SOURCE_HEALTH))
+	)
+	fetch := func(i int, f func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = f()
+		}()
+	}
+	if r.sourceDue(SOURCE_CONFIG) {
+		fetch(0, func() error {
+			ct = new(CPUThreshold)
+			return r.getConfigKV(r.cpuThresholdKey, ct)
+		})
+		fetch(1, func() error {
+			ol = new(OnlineLab)
+			return r.getConfigKV(r.onlineLabKey, ol)
+		})
+	}
+	if r.sourceDue(SOURCE_ZONE_CPU) {
+		fetch(2, func() error {
+			zc = new(ZoneCPUUtilizationRatio)
+			return r.getKV(r.zoneCPUKey, zc)
+		})
+	}
+	if r.sourceDue(SOURCE_INSTANCE_FACTOR) {
+		fetch(3, func() error {
+			inf = new(InstanceFactor)
+			return r.getKV(r.instanceFactorKey, inf)
+		})
+	}
+	if fetchNode {
+		fetch(4, func() (err error) {
+			nodes, err = r.fetchServiceNodes()
+			return err
+		})
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			r.sourceFailed(err)
+		}
+	}
+	if ct != nil && errs[0] == nil {
+		r.applyCPUThreshold(ct)
+	}
+	if ol != nil && errs[1] == nil {
+		r.applyOnlineLab(ol)
+	}
+	if ct != nil && ol != nil && errs[0] == nil && errs[1] == nil {
+		r.fetched(SOURCE_CONFIG)
+	}
+	if zc != nil && errs[2] == nil {
+		r.applyZoneCPUMap(zc)
+		r.fetched(SOURCE_ZONE_CPU)
+	}
+	if err := r.updateCordon(); err != nil {
+		r.sourceFailed(err)
+	}
+	if err := r.updateQuarantine(); err != nil {
+		r.sourceFailed(err)
+	}
+	r.applyRollout()
+	r.applySchedule(time.Now())
+	if inf != nil && errs[3] == nil {
+		r.applyInstanceFactorMap(inf)
+		r.fetched(SOURCE_INSTANCE_FACTOR)
+	}
+
+	if r.onlineLab == nil {
+		return errs[1]
+	}
+	switch {
+	case streamNodes != nil:
+		nodes = streamNodes
+	case fetchNode && errs[4] == nil:
+		r.fetchedNodes = nodes
+		r.fetched(SOURCE_HEALTH)
+	default:
+		nodes = r.fetchedNodes
+	}
+	if nodes == nil && errs[4] != nil {
+		return errs[4]
+	}
+	r.buildServiceZones(nodes)
+	return nil
+}
+
+func (r *ConsulResolver) sourceFailed(err error) {
+	r.logger.Warnf("update source failed, keep the previous value. err: %s", err.Error())
+	class := ERROR_OTHER
+	if ue, ok := err.(*UpdateError); ok {
+		class = ue.Class
+	}
+	r.errorMutex.Lock()
+	r.errorCounts[class]++
+	r.lastError = err
+	r.lastErrorTime = time.Now()
+	r.errorMutex.Unlock()
+	r.reportError(err)
+}
@@ -0,0 +1,151 @@
+package balancer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPartialUpdates(t *testing.T) {
+	Convey("Test PartialUpdates", t, func() {
+		f, server := newFakeConsul()
+		defer server.Close()
+		r := newFakeResolver(server.URL)
+		r.SetPartialUpdates(true)
+		So(r.Start(), ShouldBeNil)
+		defer r.Stop()
+		So(len(r.CandidateNodes()), ShouldEqual, 2)
+
+		Convey("Given a failing KV key, the service nodes are still refreshed", func() {
+			f.mutex.Lock()
+			f.failing["clb/factor"] = 1000
+			f.nodes = f.nodes[1:]
+			f.mutex.Unlock()
+			So(r.Update(), ShouldBeNil)
+			So(len(r.CandidateNodes()), ShouldEqual, 1)
+			So(r.ErrorCounts()[balancer.ERROR_OTHER]+r.ErrorCounts()[balancer.ERROR_SERVER], ShouldBeGreaterThan, 0)
+		})
+		Convey("Given a failing health endpoint, the previous nodes are kept", func() {
+			f.mutex.Lock()
+			f.failing["health"] = 1000
+			f.mutex.Unlock()
+			So(r.Update(), ShouldBeNil)
+			So(len(r.CandidateNodes()), ShouldEqual, 2)
+		})
+	})
+}
+
+func TestRetry(t *testing.T) {
+	Convey("Test Retry", t, func() {
+		f, server := newFakeConsul()
+		defer server.Close()
+		r := newFakeResolver(server.URL)
+		So(r.Start(), ShouldBeNil)
+		defer r.Stop()
+
+		Convey("Given two transient failures and three attempts, the update succeeds", func() {
+			r.SetRetry(3, time.Millisecond, 10*time.Millisecond)
+			f.mutex.Lock()
+			f.failing["clb/lab"] = 2
+			f.mutex.Unlock()
+			So(r.Update(), ShouldBeNil)
+		})
+		Convey("Given repeated failed cycles, the update circuit opens", func() {
+			r.SetUpdateCircuit(2, time.Minute)
+			f.mutex.Lock()
+			f.failing["clb/lab"] = 1000
+			f.mutex.Unlock()
+			So(r.Update(), ShouldNotBeNil)
+			So(r.Update(), ShouldNotBeNil)
+			So(r.Update(), ShouldEqual, balancer.ErrUpdateCircuitOpen)
+			So(len(r.CandidateNodes()), ShouldEqual, 2)
+		})
+		Convey("Given the update circuit open, skipped cycles are reported and fall back", func() {
+			r.SetUpdateCircuit(1, time.Minute)
+			r.SetFallbackNodes([]balancer.ServiceNode{{InstanceID: "seed-1", Host: "10.1.0.1", Port: 80, Zone: "a"}}, time.Millisecond)
+			var errs []error
+			r.OnUpdateError(func(stage string, err error) {
+				errs = append(errs, err)
+			})
+			f.mutex.Lock()
+			f.failing["clb/lab"] = 1000
+			f.mutex.Unlock()
+			So(r.Update(), ShouldNotBeNil)
+			failures := r.ConsecutiveFailures()
+			// let the fallback window pass
+			time.Sleep(10 * time.Millisecond)
+			So(r.Update(), ShouldEqual, balancer.ErrUpdateCircuitOpen)
+			So(errs[len(errs)-1], ShouldEqual, balancer.ErrUpdateCircuitOpen)
+			So(r.ConsecutiveFailures(), ShouldEqual, failures)
+			So(r.Degraded(), ShouldBeTrue)
+			So(r.SelectNode().InstanceID, ShouldEqual, "seed-1")
+		})
+	})
+}
+
+func TestStaticWeights(t *testing.T) {
+	Convey("Test StaticWeights", t, func() {
+		f, server := newFakeConsul()
+		defer server.Close()
+		r := newFakeResolver(server.URL)
+		r.SetStaticWeights(true)
+		Convey("Given no KV documents, the registered factors of the local zone are used", func() {
+			f.mutex.Lock()
+			f.kv = map[string]interface{}{}
+			f.mutex.Unlock()
+			So(r.Start(), ShouldBeNil)
+			defer r.Stop()
+			factors := make(map[string]float64)
+			for _, node := range r.CandidateNodes() {
+				factors[node.InstanceID] = node.CurrentFactor
+			}
+			So(factors, ShouldResemble, map[string]float64{"i-1": 300, "i-2": 900})
+			f.mutex.Lock()
+			So(f.gets, ShouldEqual, 0)
+			f.mutex.Unlock()
+		})
+	})
+}
+
+func TestUpdateErrors(t *testing.T) {
+	Convey("Test update errors", t, func() {
+		f, server := newFakeConsul()
+		defer server.Close()
+		r := newFakeResolver(server.URL)
+		var stages []string
+		r.OnUpdateError(func(stage string, err error) {
+			So(err, ShouldNotBeNil)
+			stages = append(stages, stage)
+		})
+		So(r.IsHealthy(), ShouldBeFalse)
+		So(r.Start(), ShouldBeNil)
+		defer r.Stop()
+		So(r.IsHealthy(), ShouldBeTrue)
+		So(r.LastUpdate(), ShouldHappenWithin, time.Second, time.Now())
+
+		Convey("Given a failing source, the hook and the health report its stage", func() {
+			f.mutex.Lock()
+			f.failing["clb/factor"] = 1
+			f.failing["health"] = 1
+			f.mutex.Unlock()
+			So(r.Update(), ShouldNotBeNil)
+			So(stages, ShouldResemble, []string{balancer.SOURCE_INSTANCE_FACTOR})
+			So(r.Update(), ShouldNotBeNil)
+			So(stages, ShouldResemble, []string{balancer.SOURCE_INSTANCE_FACTOR, balancer.SOURCE_HEALTH})
+
+			h := r.Health()
+			So(h.Healthy, ShouldBeTrue)
+			So(h.Sources[balancer.SOURCE_INSTANCE_FACTOR].LastError, ShouldNotBeNil)
+			So(h.Sources[balancer.SOURCE_INSTANCE_FACTOR].LastSuccess.IsZero(), ShouldBeFalse)
+			So(h.Sources[balancer.SOURCE_CONFIG].LastError, ShouldBeNil)
+		})
+		Convey("Given a stopped resolver, it is not healthy", func() {
+			r.Stop()
+			h := r.Health()
+			So(h.Healthy, ShouldBeFalse)
+			So(h.Reason, ShouldEqual, "resolver not running")
+		})
+	})
+}
@@ -0,0 +1,69 @@
+package balancer
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrPinDisabled  = errors.New("node pinning is disabled")
+	ErrNodeNotFound = errors.New("node not found")
+)
+
+// SetPinEnabled allows PinNode to be used. It is off by default so that a
+// stray debug call can't redirect production traffic.
+func (r *ConsulResolver) SetPinEnabled(enabled bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.pinEnabled = enabled
+	if !enabled {
+		r.pinInstanceID = ""
+	}
+}
+
+// PinNode makes SelectNode return the node with instanceID for ttl.
+func (r *ConsulResolver) PinNode(instanceID string, ttl time.Duration) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.pinEnabled {
+		return ErrPinDisabled
+	}
+	if r.findNode(instanceID) == nil {
+		return ErrNodeNotFound
+	}
+	r.pinInstanceID = instanceID
+	r.pinExpire = time.Now().Add(ttl)
+	r.logger.Warnf("pin node %s until %s", instanceID, r.pinExpire.Format(time.RFC3339))
+	return nil
+}
+
+func (r *ConsulResolver) UnpinNode() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.pinInstanceID = ""
+}
+
+// findNode looks instanceID up among the nodes of the serving pool,
+// including those of the zones left out of it. It must be called with
+// r.mutex held.
+func (r *ConsulResolver) findNode(instanceID string) *ServiceNode {
+	for _, node := range r.allNodes() {
+		if node.InstanceID == instanceID {
+			return node
+		}
+	}
+	return nil
+}
+
+// pinnedNode must be called with r.mutex held.
+func (r *ConsulResolver) pinnedNode() *ServiceNode {
+	if r.pinInstanceID == "" {
+		return nil
+	}
+	if time.Now().After(r.pinExpire) {
+		r.logger.Infof("pin node %s expired", r.pinInstanceID)
+		r.pinInstanceID = ""
+		return nil
+	}
+	return r.findNode(r.pinInstanceID)
+}
@@ -0,0 +1,158 @@
+package balancer
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sort"
+)
+
+// NodeFilter narrows down the candidate nodes. It must return a new slice
+// rather than modify nodes in place.
+type NodeFilter func(nodes []*ServiceNode) []*ServiceNode
+
+// Chain returns a NodePicker running nodes through filters in order, then
+// picking with picker, e.g.
+//
+//	Chain(NewWeightedRandomPicker(), SubsetFilter(20, hostname), ZoneFilter("us-east-1a"))
+//
+// A filter leaving no node makes the picker return nil.
+func Chain(picker NodePicker, filters ...NodeFilter) NodePicker {
+	return func(nodes []*ServiceNode) *ServiceNode {
+		for _, filter := range filters {
+			nodes = filter(nodes)
+			if len(nodes) == 0 {
+				return nil
+			}
+		}
+		return picker(nodes)
+	}
+}
+
+// Fallback returns a filter applying filter unless that leaves no node.
+func Fallback(filter NodeFilter) NodeFilter {
+	return func(nodes []*ServiceNode) []*ServiceNode {
+		if filtered := filter(nodes); len(filtered) > 0 {
+			return filtered
+		}
+		return nodes
+	}
+}
+
+// MatchFilter keeps the nodes match returns true for.
+func MatchFilter(match func(node *ServiceNode) bool) NodeFilter {
+	return func(nodes []*ServiceNode) []*ServiceNode {
+		var out []*ServiceNode
+		for _, node := range nodes {
+			if match(node) {
+				out = append(out, node)
+			}
+		}
+		return out
+	}
+}
+
+// ZoneFilter keeps the nodes in zones.
+func ZoneFilter(zones ...string) NodeFilter {
+	return MatchFilter(func(node *ServiceNode) bool {
+		for _, zone := range zones {
+			if node.Zone == zone {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// TagFilter keeps the nodes carrying tag.
+func TagFilter(tag string) NodeFilter {
+	return MatchFilter(func(node *ServiceNode) bool {
+		return node.HasTag(tag)
+	})
+}
+
+// SubsetFilter keeps size nodes chosen by rendezvous hashing on seed, so a
+// client keeps the same subset while the pool changes little.
+func SubsetFilter(size int, seed string) NodeFilter {
+	return func(nodes []*ServiceNode) []*ServiceNode {
+		if len(nodes) <= size {
+			return nodes
+		}
+		scores := make(map[*ServiceNode]uint64, len(nodes))
+		out := append([]*ServiceNode(nil), nodes...)
+		for _, node := range out {
+			h := fnv.New64a()
+			h.Write([]byte(seed))
+			h.Write([]byte(nodeKey(node)))
+			scores[node] = h.Sum64()
+		}
+		sort.Slice(out, func(i, j int) bool {
+			return scores[out[i]] > scores[out[j]]
+		})
+		return out[:size]
+	}
+}
+
+// NewWeightedRandomPicker returns a NodePicker sampling nodes in proportion
+// to their CurrentFactor.
+func NewWeightedRandomPicker() NodePicker {
+	return func(nodes []*ServiceNode) *ServiceNode {
+		var total float64
+		for _, node := range nodes {
+			total += node.CurrentFactor
+		}
+		if len(nodes) == 0 {
+			return nil
+		}
+		if total <= 0 {
+			return nodes[rand.Intn(len(nodes))]
+		}
+		x := rand.Float64() * total
+		for _, node := range nodes {
+			x -= node.CurrentFactor
+			if x < 0 {
+				return node
+			}
+		}
+		return nodes[len(nodes)-1]
+	}
+}
+
+// SetPicker makes SelectNode pick from the candidate pool with picker,
+// typically a Chain, instead of the built-in weighted round robin. Pinning,
+// metrics and observer mode still apply. Passing nil restores the default.
+// picker runs under the resolver lock and must not call the resolver.
+func (r *ConsulResolver) SetPicker(picker NodePicker) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.picker = picker
+}
+
+// pickIndex leaves out the nodes skip, which may be nil, returns true for.
+// It must be called with r.mutex held.
+func (r *ConsulResolver) pickIndex(skip func(i int) bool) int {
+	if r.picker == nil {
+		idx := r.pick(func(i int) bool {
+			return (skip != nil && skip(i)) || r.skipNode(i)
+		})
+		if idx < 0 {
+			idx = r.pick(skip)
+		}
+		return idx
+	}
+	nodes := make([]*ServiceNode, 0, len(r.candidatePool.Nodes))
+	for i, n := range r.candidatePool.Nodes {
+		if skip == nil || !skip(i) {
+			nodes = append(nodes, n)
+		}
+	}
+	if len(nodes) == 0 {
+		return -1
+	}
+	node := r.picker(nodes)
+	for i, n := range r.candidatePool.Nodes {
+		if n == node {
+			return i
+		}
+	}
+	return -1
+}
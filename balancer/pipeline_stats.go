@@ -0,0 +1,133 @@
+package balancer
+
+import (
+	"sync"
+)
+
+// StageStats counts what one stage of a picker pipeline did since it was
+// created.
+type StageStats struct {
+	Name string `json:"name"`
+	// Calls is the number of times the stage ran.
+	Calls uint64 `json:"calls"`
+	// Removed is the number of nodes the stage took out over all calls.
+	Removed uint64 `json:"removed"`
+	// Emptied is the number of calls that left no node.
+	Emptied uint64 `json:"emptied"`
+	// Fallbacks is the number of calls of a Fallback stage that kept its
+	// input because the filter left no node.
+	Fallbacks uint64 `json:"fallbacks"`
+}
+
+// PipelineStats collects per-stage statistics of the filters of a Chain,
+// to see which policy is actually shaping traffic, e.g.
+//
+//	stats := NewPipelineStats()
+//	Chain(NewWeightedRandomPicker(), stats.Stage("subset", SubsetFilter(20, hostname)), stats.Fallback("zone", ZoneFilter("us-east-1a")))
+//
+// Stages sharing a name share their counters.
+type PipelineStats struct {
+	mutex  sync.Mutex
+	stages []*StageStats
+}
+
+func NewPipelineStats() *PipelineStats {
+	return &PipelineStats{}
+}
+
+func (s *PipelineStats) stage(name string) *StageStats {
+	for _, stage := range s.stages {
+		if stage.Name == name {
+			return stage
+		}
+	}
+	stage := &StageStats{Name: name}
+	s.stages = append(s.stages, stage)
+	return stage
+}
+
+func (s *PipelineStats) record(name string, in, out int, fallback bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stage := s.stage(name)
+	stage.Calls++
+	stage.Removed += uint64(in - out)
+	if out == 0 {
+		stage.Emptied++
+	}
+	if fallback {
+		stage.Fallbacks++
+	}
+}
+
+// Stage returns filter counting its calls under name.
+func (s *PipelineStats) Stage(name string, filter NodeFilter) NodeFilter {
+	s.mutex.Lock()
+	s.stage(name)
+	s.mutex.Unlock()
+	return func(nodes []*ServiceNode) []*ServiceNode {
+		out := filter(nodes)
+		s.record(name, len(nodes), len(out), false)
+		return out
+	}
+}
+
+// Fallback is Fallback(filter) counting its calls and the times it fell
+// back under name.
+func (s *PipelineStats) Fallback(name string, filter NodeFilter) NodeFilter {
+	s.mutex.Lock()
+	s.stage(name)
+	s.mutex.Unlock()
+	return func(nodes []*ServiceNode) []*ServiceNode {
+		if out := filter(nodes); len(out) > 0 {
+			s.record(name, len(nodes), len(out), false)
+			return out
+		}
+		s.record(name, len(nodes), len(nodes), true)
+		return nodes
+	}
+}
+
+// Stats returns the counters of the stages in the order they were added.
+func (s *PipelineStats) Stats() []StageStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stats := make([]StageStats, len(s.stages))
+	for i, stage := range s.stages {
+		stats[i] = *stage
+	}
+	return stats
+}
+
+// SetPipelineStats adds the stage counters of stats to DebugHandler and
+// exports them every update cycle to the MetricsSink as
+// clb_pipeline_calls, clb_pipeline_removed, clb_pipeline_emptied and
+// clb_pipeline_fallbacks labeled with the stage name.
+func (r *ConsulResolver) SetPipelineStats(stats *PipelineStats) {
+	r.mutex.Lock()
+	r.pipelineStats = stats
+	r.mutex.Unlock()
+}
+
+func (r *ConsulResolver) pipelineStatsSnapshot() []StageStats {
+	r.mutex.Lock()
+	stats := r.pipelineStats
+	r.mutex.Unlock()
+	if stats == nil {
+		return nil
+	}
+	return stats.Stats()
+}
+
+func (r *ConsulResolver) exportPipeline() {
+	if r.metricsSink == nil {
+		return
+	}
+	for _, stage := range r.pipelineStatsSnapshot() {
+		labels := map[string]string{"service": r.service, "stage": stage.Name}
+		r.metricsSink.SetGauge("clb_pipeline_calls", float64(stage.Calls), labels)
+		r.metricsSink.SetGauge("clb_pipeline_removed", float64(stage.Removed), labels)
+		r.metricsSink.SetGauge("clb_pipeline_emptied", float64(stage.Emptied), labels)
+		r.metricsSink.SetGauge("clb_pipeline_fallbacks", float64(stage.Fallbacks), labels)
+	}
+}
@@ -0,0 +1,53 @@
+package balancer_test
+
+import (
+	"testing"
+
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestChain(t *testing.T) {
+	Convey("Test Chain", t, func() {
+		var nodes []*balancer.ServiceNode
+		for _, n := range testNodes() {
+			node := n
+			nodes = append(nodes, &node)
+		}
+		Convey("Given a zone filter, only nodes of that zone are picked", func() {
+			picker := balancer.Chain(balancer.NewRoundRobinPicker(), balancer.ZoneFilter("b"))
+			for i := 0; i < 5; i++ {
+				So(picker(nodes).InstanceID, ShouldEqual, "i-3")
+			}
+		})
+		Convey("Given a filter leaving no node, the picker returns nil unless wrapped in Fallback", func() {
+			So(balancer.Chain(balancer.NewRoundRobinPicker(), balancer.ZoneFilter("c"))(nodes), ShouldBeNil)
+			So(balancer.Chain(balancer.NewRoundRobinPicker(), balancer.Fallback(balancer.ZoneFilter("c")))(nodes), ShouldNotBeNil)
+		})
+		Convey("Given a subset filter, the subset is stable for a seed", func() {
+			subset := balancer.SubsetFilter(2, "client-1")
+			first := subset(nodes)
+			So(len(first), ShouldEqual, 2)
+			So(subset(nodes[:]), ShouldResemble, first)
+		})
+		Convey("Given PipelineStats, each stage counts removals and fallbacks", func() {
+			stats := balancer.NewPipelineStats()
+			picker := balancer.Chain(balancer.NewRoundRobinPicker(), stats.Stage("zone", balancer.ZoneFilter("a")), stats.Fallback("tag", balancer.TagFilter("v2")))
+			for i := 0; i < 4; i++ {
+				So(picker(nodes), ShouldNotBeNil)
+			}
+			So(stats.Stats(), ShouldResemble, []balancer.StageStats{
+				{Name: "zone", Calls: 4, Removed: 4},
+				{Name: "tag", Calls: 4, Fallbacks: 4},
+			})
+		})
+		Convey("Given SetPicker, SelectNode picks through the pipeline", func() {
+			r, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+			So(err, ShouldBeNil)
+			r.SetPicker(balancer.Chain(balancer.NewRoundRobinPicker(), balancer.MatchFilter(func(node *balancer.ServiceNode) bool {
+				return node.InstanceID == "i-1"
+			})))
+			So(countSelect(r, 10)["i-1"], ShouldEqual, 10)
+		})
+	})
+}
@@ -0,0 +1,128 @@
+package balancer
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// POOL_HISTORY_SIZE is the number of recent pool snapshots, the serving
+// one included, kept for Snapshot and PoolDiffHandler.
+const POOL_HISTORY_SIZE = 16
+
+// PoolSnapshot is a copy of the serving pool at one epoch.
+type PoolSnapshot struct {
+	Epoch uint64        `json:"epoch"`
+	Nodes []ServiceNode `json:"nodes"`
+}
+
+// FactorDelta is a node whose factor changed between two snapshots. Node
+// is the node in the newer one.
+type FactorDelta struct {
+	Node      ServiceNode `json:"node"`
+	OldFactor float64     `json:"oldFactor"`
+	NewFactor float64     `json:"newFactor"`
+}
+
+// PoolDiff is the change from one pool snapshot to another.
+type PoolDiff struct {
+	From    uint64        `json:"from"`
+	To      uint64        `json:"to"`
+	Added   []ServiceNode `json:"added"`
+	Removed []ServiceNode `json:"removed"`
+	Changed []FactorDelta `json:"changed"`
+}
+
+// Empty reports whether nothing changed.
+func (d PoolDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// DiffSnapshots returns the nodes added to and removed from old in new
+// and the factor changes of the nodes in both, in the order of the
+// snapshots, so consumers can reconcile connection pools or hash rings.
+func DiffSnapshots(old, new PoolSnapshot) PoolDiff {
+	diff := PoolDiff{From: old.Epoch, To: new.Epoch}
+	oldNodes := make(map[string]*ServiceNode, len(old.Nodes))
+	for i := range old.Nodes {
+		oldNodes[nodeKey(&old.Nodes[i])] = &old.Nodes[i]
+	}
+	seen := make(map[string]bool, len(new.Nodes))
+	for _, node := range new.Nodes {
+		key := nodeKey(&node)
+		seen[key] = true
+		o, ok := oldNodes[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, node)
+		case o.CurrentFactor != node.CurrentFactor:
+			diff.Changed = append(diff.Changed, FactorDelta{Node: node, OldFactor: o.CurrentFactor, NewFactor: node.CurrentFactor})
+		}
+	}
+	for _, node := range old.Nodes {
+		if !seen[nodeKey(&node)] {
+			diff.Removed = append(diff.Removed, node)
+		}
+	}
+	return diff
+}
+
+// PoolSnapshot returns a snapshot of the serving pool as it was published.
+func (r *ConsulResolver) PoolSnapshot() PoolSnapshot {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.poolHistory) == 0 {
+		return PoolSnapshot{}
+	}
+	return r.poolHistory[len(r.poolHistory)-1]
+}
+
+// Snapshot returns the snapshot of the pool at epoch, if it is the serving
+// pool or one of the POOL_HISTORY_SIZE-1 before it.
+func (r *ConsulResolver) Snapshot(epoch uint64) (PoolSnapshot, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, s := range r.poolHistory {
+		if s.Epoch == epoch {
+			return s, true
+		}
+	}
+	return PoolSnapshot{}, false
+}
+
+// recordHistory keeps a snapshot of the pool being served. It must be
+// called with r.mutex held.
+func (r *ConsulResolver) recordHistory(pool *CandidatePool) {
+	s := PoolSnapshot{Epoch: pool.Epoch, Nodes: make([]ServiceNode, len(pool.Nodes))}
+	for i, node := range pool.Nodes {
+		s.Nodes[i] = *node
+	}
+	r.poolHistory = append(r.poolHistory, s)
+	if len(r.poolHistory) > POOL_HISTORY_SIZE {
+		r.poolHistory = r.poolHistory[len(r.poolHistory)-POOL_HISTORY_SIZE:]
+	}
+}
+
+// PoolDiffHandler serves the PoolDiff from the pool at ?from=<epoch> to
+// the serving pool as JSON, or 410 Gone when that epoch is no longer kept.
+func (r *ConsulResolver) PoolDiffHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		from, err := strconv.ParseUint(req.URL.Query().Get("from"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid from", http.StatusBadRequest)
+			return
+		}
+		old, ok := r.Snapshot(from)
+		if !ok {
+			http.Error(w, "epoch "+strconv.FormatUint(from, 10)+" no longer kept", http.StatusGone)
+			return
+		}
+		diff := DiffSnapshots(old, r.PoolSnapshot())
+		data, err := r.serializerFor("").Marshal(&diff)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+}
@@ -0,0 +1,63 @@
+package balancer
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// PORT_META_PREFIX prefixes the service meta keys naming extra ports,
+// e.g. "port.grpc" = "9090".
+const PORT_META_PREFIX = "port."
+
+func parsePorts(meta map[string]string) map[string]int {
+	var ports map[string]int
+	for k, v := range meta {
+		if !strings.HasPrefix(k, PORT_META_PREFIX) {
+			continue
+		}
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			continue
+		}
+		if ports == nil {
+			ports = make(map[string]int)
+		}
+		ports[strings.TrimPrefix(k, PORT_META_PREFIX)] = port
+	}
+	return ports
+}
+
+// NamedPort returns the port registered as name, or the service port for
+// an empty name.
+func (n *ServiceNode) NamedPort(name string) (int, bool) {
+	if name == "" {
+		return n.Port, true
+	}
+	port, ok := n.Ports[name]
+	return port, ok
+}
+
+// Addr returns host:port for the named port.
+func (n *ServiceNode) Addr(name string) (string, bool) {
+	port, ok := n.NamedPort(name)
+	if !ok {
+		return "", false
+	}
+	return net.JoinHostPort(n.Host, strconv.Itoa(port)), true
+}
+
+// SelectAddr selects a node and returns its address for the named port.
+// It fails when the selected node did not register that port.
+func (r *ConsulResolver) SelectAddr(name string) (string, error) {
+	node := r.SelectNode()
+	if node == nil {
+		return "", ErrNoNode
+	}
+	addr, ok := node.Addr(name)
+	if !ok {
+		return "", fmt.Errorf("node %s has no port %q", node.InstanceID, name)
+	}
+	return addr, nil
+}
@@ -0,0 +1,45 @@
+package balancer
+
+import (
+	"github.com/hashicorp/consul/api"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// SetPreparedQuery resolves the service nodes by executing the Consul
+// prepared query with the given name or ID instead of the health query, so
+// its failover and nearness settings apply. The factor weighting is layered
+// on the returned nodes as usual. Nodes from a failover datacenter carry it
+// in ServiceNode.Datacenter. Prepared queries cannot block, so streaming
+// has no effect in this mode.
+func (r *ConsulResolver) SetPreparedQuery(query string) {
+	r.preparedQuery = query
+}
+
+func (r *ConsulResolver) executePreparedQuery() ([]ServiceNode, error) {
+	_, end := r.startSpan(r.updateContext(), "consul_lb.query.execute", attribute.String("query", r.preparedQuery))
+	var res *api.PreparedQueryExecuteResponse
+	err := r.retry(func() (err error) {
+		ctx, cancel := r.callContext(r.timeout)
+		defer cancel()
+		res, _, err = r.client.PreparedQuery().Execute(r.preparedQuery, r.queryOptions(&api.QueryOptions{}).WithContext(ctx))
+		if err != nil {
+			return classifyError(r.preparedQuery, err)
+		}
+		return nil
+	})
+	end(err)
+	if err != nil {
+		return nil, err
+	}
+	serviceNodes := make([]ServiceNode, len(res.Nodes))
+	for i := range res.Nodes {
+		serviceNodes[i] = newServiceNode(&res.Nodes[i])
+		if res.Failovers > 0 {
+			serviceNodes[i].Datacenter = res.Datacenter
+		}
+	}
+	if res.Failovers > 0 {
+		r.logger.Warnf("service: %s, prepared query %s failed over to datacenter %s", r.service, r.preparedQuery, res.Datacenter)
+	}
+	return r.filterTags(serviceNodes), nil
+}
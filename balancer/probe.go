@@ -0,0 +1,191 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HealthProbe checks whether node is reachable. It must return once ctx is
+// done.
+type HealthProbe func(ctx context.Context, node *ServiceNode) error
+
+// TCPProbe dials the service port of the node.
+func TCPProbe() HealthProbe {
+	return func(ctx context.Context, node *ServiceNode) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(node.Host, fmt.Sprint(node.Port)))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// HTTPProbe sends GET http://host:port/path to the node and expects a 2xx
+// or 3xx status.
+func HTTPProbe(path string) HealthProbe {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	return func(ctx context.Context, node *ServiceNode) error {
+		url := fmt.Sprintf("http://%s%s", net.JoinHostPort(node.Host, fmt.Sprint(node.Port)), path)
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		res, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode >= 400 {
+			return fmt.Errorf("probe %s status %d", url, res.StatusCode)
+		}
+		return nil
+	}
+}
+
+type healthProber struct {
+	probe       HealthProbe
+	interval    time.Duration
+	timeout     time.Duration
+	mutex       sync.Mutex
+	unreachable map[string]ServiceNode
+	cancel      context.CancelFunc
+}
+
+// SetHealthProbe probes every candidate node each interval between Consul
+// updates. Nodes failing the probe within timeout are skipped right away
+// and left out of the pool until a probe succeeds again. A nil probe
+// disables probing. It must be called before Start.
+func (r *ConsulResolver) SetHealthProbe(probe HealthProbe, interval, timeout time.Duration) {
+	if probe == nil {
+		r.prober = nil
+		return
+	}
+	r.prober = &healthProber{
+		probe:       probe,
+		interval:    interval,
+		timeout:     timeout,
+		unreachable: make(map[string]ServiceNode),
+	}
+}
+
+func (r *ConsulResolver) startProbe() {
+	if r.prober == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(r.lifecycleContext())
+	r.prober.cancel = cancel
+	go func() {
+		tk := time.NewTicker(r.prober.interval)
+		defer tk.Stop()
+		for {
+			select {
+			case <-tk.C:
+				r.ProbeNodes(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (r *ConsulResolver) stopProbe() {
+	if r.prober != nil && r.prober.cancel != nil {
+		r.prober.cancel()
+	}
+}
+
+// ProbeNodes probes every candidate node and every node currently
+// unreachable once, concurrently, and updates the set of unreachable nodes.
+// Unreachable nodes no longer registered with the service are dropped.
+func (r *ConsulResolver) ProbeNodes(ctx context.Context) {
+	if r.prober == nil {
+		return
+	}
+	nodes := r.CandidateNodes()
+	r.mutex.Lock()
+	var registered map[string]bool
+	if r.candidatePool != nil {
+		registered = make(map[string]bool)
+		for _, node := range r.allNodes() {
+			registered[nodeKey(node)] = true
+		}
+	}
+	r.mutex.Unlock()
+	r.prober.mutex.Lock()
+	for key, node := range r.prober.unreachable {
+		if registered != nil && !registered[key] {
+			delete(r.prober.unreachable, key)
+			r.logger.Infof("service: %s, unreachable node %s deregistered, stop probing it", r.service, key)
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	r.prober.mutex.Unlock()
+	if len(nodes) == 0 {
+		return
+	}
+	results := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i := range nodes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pctx, cancel := context.WithTimeout(ctx, r.prober.timeout)
+			defer cancel()
+			results[i] = r.prober.probe(pctx, &nodes[i])
+		}(i)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	r.prober.mutex.Lock()
+	defer r.prober.mutex.Unlock()
+	for i, err := range results {
+		key := nodeKey(&nodes[i])
+		_, known := r.prober.unreachable[key]
+		switch {
+		case err != nil && !known:
+			r.prober.unreachable[key] = nodes[i]
+			r.logger.Warnf("service: %s, node %s unreachable. err: %s", r.service, key, err.Error())
+		case err == nil && known:
+			delete(r.prober.unreachable, key)
+			r.logger.Infof("service: %s, node %s reachable again", r.service, key)
+		}
+	}
+}
+
+func (r *ConsulResolver) unreachable(node *ServiceNode) bool {
+	if r.prober == nil {
+		return false
+	}
+	r.prober.mutex.Lock()
+	defer r.prober.mutex.Unlock()
+	_, ok := r.prober.unreachable[nodeKey(node)]
+	return ok
+}
+
+func (r *ConsulResolver) applyProbe(pool *CandidatePool) *CandidatePool {
+	if r.prober == nil {
+		return pool
+	}
+	r.prober.mutex.Lock()
+	defer r.prober.mutex.Unlock()
+	if len(r.prober.unreachable) == 0 {
+		return pool
+	}
+	return r.scalePool(pool, ADJUST_UNREACHABLE, func(node *ServiceNode) float64 {
+		if _, ok := r.prober.unreachable[nodeKey(node)]; ok {
+			return 0
+		}
+		return 1
+	})
+}
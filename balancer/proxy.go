@@ -0,0 +1,48 @@
+package balancer
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+)
+
+var ErrNoNode = errors.New("no node available")
+
+// NewReverseProxy returns a reverse proxy sending every request to a node
+// picked with r.SelectNode over plain HTTP. X-Forwarded-For is appended
+// by httputil.ReverseProxy; X-Forwarded-Host and X-Forwarded-Proto are
+// set from the incoming request.
+func NewReverseProxy(r *ConsulResolver) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			proto := "http"
+			if req.TLS != nil {
+				proto = "https"
+			}
+			req.Header.Set("X-Forwarded-Host", req.Host)
+			req.Header.Set("X-Forwarded-Proto", proto)
+			req.URL.Scheme = "http"
+			req.URL.Host = ""
+			if node := r.SelectNode(); node != nil {
+				req.URL.Host = net.JoinHostPort(node.Host, strconv.Itoa(node.Port))
+			}
+			if _, ok := req.Header["User-Agent"]; !ok {
+				req.Header.Set("User-Agent", "")
+			}
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			if req.URL.Host == "" {
+				err = ErrNoNode
+			}
+			// the error names the node, keep it out of the response
+			r.logger.Warnf("proxy %s to %s failed. err: %s", req.URL.Path, req.URL.Host, err.Error())
+			if err == ErrNoNode {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		},
+	}
+}
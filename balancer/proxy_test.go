@@ -0,0 +1,57 @@
+package balancer_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReverseProxy(t *testing.T) {
+	Convey("Test ReverseProxy", t, func() {
+		node := func(addr string) []balancer.ServiceNode {
+			host, port, _ := net.SplitHostPort(addr)
+			p, _ := strconv.Atoi(port)
+			return []balancer.ServiceNode{{InstanceID: "i-1", Host: host, Port: p, Zone: "a", BalanceFactor: 1}}
+		}
+
+		Convey("Given a node up, the request is forwarded to it", func() {
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Write([]byte(req.Header.Get("X-Forwarded-Host") + " " + req.URL.Path))
+			}))
+			defer backend.Close()
+			r, err := balancer.NewSimpleResolver("a", node(backend.Listener.Addr().String()), nil, 0)
+			So(err, ShouldBeNil)
+			w := httptest.NewRecorder()
+			balancer.NewReverseProxy(r).ServeHTTP(w, httptest.NewRequest("GET", "http://front/ping", nil))
+			So(w.Code, ShouldEqual, http.StatusOK)
+			So(w.Body.String(), ShouldEqual, "front /ping")
+		})
+		Convey("Given a node down, a generic 502 is returned", func() {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			So(err, ShouldBeNil)
+			addr := l.Addr().String()
+			l.Close()
+			r, err := balancer.NewSimpleResolver("a", node(addr), nil, 0)
+			So(err, ShouldBeNil)
+			w := httptest.NewRecorder()
+			balancer.NewReverseProxy(r).ServeHTTP(w, httptest.NewRequest("GET", "http://front/ping", nil))
+			So(w.Code, ShouldEqual, http.StatusBadGateway)
+			So(strings.TrimSpace(w.Body.String()), ShouldEqual, http.StatusText(http.StatusBadGateway))
+			So(w.Body.String(), ShouldNotContainSubstring, addr)
+		})
+		Convey("Given no node, 503 is returned", func() {
+			r, err := balancer.NewSimpleResolver("a", node("127.0.0.1:80"), nil, 0)
+			So(err, ShouldBeNil)
+			So(r.SetNodes(nil), ShouldBeNil)
+			w := httptest.NewRecorder()
+			balancer.NewReverseProxy(r).ServeHTTP(w, httptest.NewRequest("GET", "http://front/ping", nil))
+			So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+		})
+	})
+}
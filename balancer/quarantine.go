@@ -0,0 +1,133 @@
+package balancer
+
+import (
+	"sync"
+	"time"
+)
+
+// QuarantineList is the KV document an external anomaly detector writes
+// to quarantine instances. Entries maps an instanceID to the unix time, in
+// seconds, at which its quarantine lapses.
+type QuarantineList struct {
+	Updated int64            `json:"updated"`
+	Entries map[string]int64 `json:"entries"`
+}
+
+type quarantine struct {
+	mutex  sync.Mutex
+	local  map[string]time.Time
+	remote map[string]time.Time
+}
+
+// until returns when the quarantine of instanceID lapses, dropping expired
+// entries. It must be called with q.mutex held.
+func (q *quarantine) until(instanceID string, now time.Time) (time.Time, bool) {
+	var until time.Time
+	for _, m := range []map[string]time.Time{q.local, q.remote} {
+		t, ok := m[instanceID]
+		if !ok {
+			continue
+		}
+		if !now.Before(t) {
+			delete(m, instanceID)
+			continue
+		}
+		if t.After(until) {
+			until = t
+		}
+	}
+	return until, !until.IsZero()
+}
+
+// Quarantine keeps instanceID out of selection for ttl. It takes effect on
+// the next selection; the node leaves the candidate pool on the next update
+// cycle and rejoins on the first cycle after ttl lapses.
+func (r *ConsulResolver) Quarantine(instanceID string, ttl time.Duration) {
+	r.quarantine.mutex.Lock()
+	defer r.quarantine.mutex.Unlock()
+	if r.quarantine.local == nil {
+		r.quarantine.local = make(map[string]time.Time)
+	}
+	r.quarantine.local[instanceID] = time.Now().Add(ttl)
+	r.logger.Infof("service: %s, instance %s quarantined for %s", r.service, instanceID, ttl)
+}
+
+// Unquarantine lifts a quarantine set by Quarantine. Entries read from the
+// quarantine key are lifted by removing them from the KV document.
+func (r *ConsulResolver) Unquarantine(instanceID string) {
+	r.quarantine.mutex.Lock()
+	defer r.quarantine.mutex.Unlock()
+	delete(r.quarantine.local, instanceID)
+}
+
+// Quarantined returns the quarantined instanceIDs and when their
+// quarantine lapses.
+func (r *ConsulResolver) Quarantined() map[string]time.Time {
+	r.quarantine.mutex.Lock()
+	defer r.quarantine.mutex.Unlock()
+	now := time.Now()
+	m := make(map[string]time.Time)
+	for _, entries := range []map[string]time.Time{r.quarantine.local, r.quarantine.remote} {
+		for id := range entries {
+			if until, ok := r.quarantine.until(id, now); ok {
+				m[id] = until
+			}
+		}
+	}
+	return m
+}
+
+// SetQuarantineKey makes the resolver read the QuarantineList at key every
+// update cycle in addition to the entries set by Quarantine. A missing key
+// means no instance is quarantined through KV.
+func (r *ConsulResolver) SetQuarantineKey(key string) {
+	r.quarantineKey = key
+}
+
+func (r *ConsulResolver) updateQuarantine() error {
+	if r.quarantineKey == "" {
+		return nil
+	}
+	var q QuarantineList
+	err := r.getKV(r.quarantineKey, &q)
+	if ue, ok := err.(*UpdateError); ok && ue.Class == ERROR_KEY_MISSING {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	remote := make(map[string]time.Time, len(q.Entries))
+	for id, until := range q.Entries {
+		remote[id] = time.Unix(until, 0)
+	}
+	r.quarantine.mutex.Lock()
+	r.quarantine.remote = remote
+	r.quarantine.mutex.Unlock()
+	r.logger.Debugf("update quarantine: %+v, key: %s", q.Entries, r.quarantineKey)
+	return nil
+}
+
+func (r *ConsulResolver) quarantined(node *ServiceNode) bool {
+	r.quarantine.mutex.Lock()
+	defer r.quarantine.mutex.Unlock()
+	if len(r.quarantine.local) == 0 && len(r.quarantine.remote) == 0 {
+		return false
+	}
+	_, ok := r.quarantine.until(node.InstanceID, time.Now())
+	return ok
+}
+
+func (r *ConsulResolver) applyQuarantine(pool *CandidatePool) *CandidatePool {
+	r.quarantine.mutex.Lock()
+	defer r.quarantine.mutex.Unlock()
+	if len(r.quarantine.local) == 0 && len(r.quarantine.remote) == 0 {
+		return pool
+	}
+	now := time.Now()
+	return r.scalePool(pool, ADJUST_QUARANTINE, func(node *ServiceNode) float64 {
+		if _, ok := r.quarantine.until(node.InstanceID, now); ok {
+			return 0
+		}
+		return 1
+	})
+}
@@ -0,0 +1,47 @@
+package balancer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestQuarantine(t *testing.T) {
+	Convey("Test Quarantine", t, func() {
+		f, server := newFakeConsul()
+		defer server.Close()
+		r := newFakeResolver(server.URL)
+		r.SetQuarantineKey("clb/quarantine")
+		So(r.Start(), ShouldBeNil)
+		defer r.Stop()
+		So(len(r.CandidateNodes()), ShouldEqual, 2)
+
+		Convey("Given a KV entry, the node leaves the pool until its TTL lapses", func() {
+			f.mutex.Lock()
+			f.kv["clb/quarantine"] = balancer.QuarantineList{Entries: map[string]int64{
+				"i-1": time.Now().Add(time.Minute).Unix(),
+				"i-2": time.Now().Add(-time.Minute).Unix(),
+			}}
+			f.mutex.Unlock()
+			So(r.Update(), ShouldBeNil)
+			So(len(r.CandidateNodes()), ShouldEqual, 1)
+			So(r.CandidateNodes()[0].InstanceID, ShouldEqual, "i-2")
+			_, ok := r.Quarantined()["i-1"]
+			So(ok, ShouldBeTrue)
+		})
+		Convey("Given a local quarantine, selection skips the node at once and Unquarantine lifts it", func() {
+			r.Quarantine("i-1", time.Minute)
+			for i := 0; i < 20; i++ {
+				node, err := r.SelectNodeE()
+				So(err, ShouldBeNil)
+				So(node.InstanceID, ShouldEqual, "i-2")
+			}
+			r.Unquarantine("i-1")
+			So(r.Quarantined(), ShouldBeEmpty)
+			So(r.Update(), ShouldBeNil)
+			So(len(r.CandidateNodes()), ShouldEqual, 2)
+		})
+	})
+}
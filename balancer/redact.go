@@ -0,0 +1,63 @@
+package balancer
+
+import (
+	"github.com/mae-pax/consul-loadbalancer/util"
+)
+
+// SetRedaction masks node hosts, public IPs and any IP address in the
+// resolver, watcher, learning and selection logs. InstanceIDs are kept. It
+// may be switched at any time, the loggers set before are covered too.
+func (r *ConsulResolver) SetRedaction(redact bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.redact = redact
+	if redact && r.redactLogger == nil {
+		r.redactLogger = util.NewRedactLogger(util.NopLogger{})
+	}
+	r.swapLoggers()
+}
+
+// Redacting reports whether redaction is on, for callers exporting node
+// hosts or public IPs outside the resolver.
+func (r *ConsulResolver) Redacting() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.redact
+}
+
+// swapLoggers installs the resolver and watcher loggers, masking when
+// redaction is on. It must be called with r.mutex held.
+func (r *ConsulResolver) swapLoggers() {
+	r.logs.Swap(r.redacted(r.baseLogger))
+	r.watcherLogs.Swap(r.redacted(r.watcherLogger))
+}
+
+// redacted returns logger masking when redaction is on. It must be called
+// with r.mutex held.
+func (r *ConsulResolver) redacted(logger util.Logger) util.Logger {
+	if !r.redact || logger == nil {
+		return logger
+	}
+	return r.redactLogger.With(logger)
+}
+
+// redactor returns the logger holding the masked words, nil when
+// redaction is off.
+func (r *ConsulResolver) redactor() *util.RedactLogger {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.redact {
+		return nil
+	}
+	return r.redactLogger
+}
+
+func (r *ConsulResolver) redactNodes(nodes []ServiceNode) {
+	redactor := r.redactor()
+	if redactor == nil {
+		return
+	}
+	for _, node := range nodes {
+		redactor.AddWords(node.Host, node.PublicIP)
+	}
+}
@@ -0,0 +1,41 @@
+package balancer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	"github.com/mae-pax/consul-loadbalancer/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRedaction(t *testing.T) {
+	Convey("Test Redaction", t, func() {
+		r, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+		So(err, ShouldBeNil)
+		logger := util.NewRingLogger(1000)
+		selections := util.NewRingLogger(10)
+		r.SetLogger(logger)
+		r.SetSelectionLog(1, selections)
+		leaked := func(l *util.RingLogger) bool {
+			return strings.Contains(strings.Join(l.Lines(), "\n"), "10.0.0.")
+		}
+
+		Convey("Given redaction set before Start, the logs are masked", func() {
+			r.SetRedaction(true)
+			So(r.Update(), ShouldBeNil)
+			So(r.SelectNode(), ShouldNotBeNil)
+			So(len(logger.Lines()), ShouldBeGreaterThan, 0)
+			So(leaked(logger), ShouldBeFalse)
+			So(leaked(selections), ShouldBeFalse)
+
+			Convey("Given redaction switched off, the hosts are logged again", func() {
+				r.SetRedaction(false)
+				So(r.Update(), ShouldBeNil)
+				So(r.SelectNode(), ShouldNotBeNil)
+				So(leaked(logger), ShouldBeTrue)
+				So(leaked(selections), ShouldBeTrue)
+			})
+		})
+	})
+}
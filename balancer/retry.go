@@ -0,0 +1,84 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// ErrUpdateCircuitOpen is returned by update cycles skipped while the
+// update circuit is open.
+var ErrUpdateCircuitOpen = errors.New("update circuit open, serving cached state")
+
+// SetRetry retries a failed Consul read inside the update cycle up to
+// attempts times in total, sleeping a random duration up to
+// baseDelay * 2^n, capped at maxDelay, before retry n. Only timeouts,
+// server and connection errors are retried.
+func (r *ConsulResolver) SetRetry(attempts int, baseDelay, maxDelay time.Duration) {
+	r.updateMutex.Lock()
+	defer r.updateMutex.Unlock()
+	r.retryAttempts = attempts
+	r.retryBaseDelay = baseDelay
+	r.retryMaxDelay = maxDelay
+}
+
+// SetUpdateCircuit stops reading Consul for coolDown after failures update
+// cycles failed in a row. The resolver keeps serving the last pool; the
+// first cycle after coolDown is a trial that closes the circuit on success
+// and opens it again on failure. Zero failures disables it.
+func (r *ConsulResolver) SetUpdateCircuit(failures int, coolDown time.Duration) {
+	r.updateMutex.Lock()
+	defer r.updateMutex.Unlock()
+	r.circuitFailures = failures
+	r.circuitCoolDown = coolDown
+}
+
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	ue, ok := err.(*UpdateError)
+	if !ok {
+		return true
+	}
+	switch ue.Class {
+	case ERROR_TIMEOUT, ERROR_SERVER, ERROR_TRANSPORT, ERROR_OTHER:
+		return true
+	}
+	return false
+}
+
+// retry calls f until it succeeds, fails with an error that is not
+// retryable or the attempts of SetRetry are used up.
+func (r *ConsulResolver) retry(f func() error) error {
+	err := f()
+	for n := 0; err != nil && n+1 < r.retryAttempts && retryable(err); n++ {
+		delay := r.retryBaseDelay << uint(n)
+		if delay > r.retryMaxDelay || delay <= 0 {
+			delay = r.retryMaxDelay
+		}
+		if delay > 0 {
+			select {
+			case <-time.After(time.Duration(rand.Int63n(int64(delay) + 1))):
+			case <-r.updateContext().Done():
+				return err
+			}
+		}
+		r.logger.Debugf("retry %d after err: %s", n+1, err.Error())
+		err = f()
+	}
+	return err
+}
+
+// circuitOpen reports whether the update cycle must be skipped. It must
+// be called with r.updateMutex held.
+func (r *ConsulResolver) circuitOpen() bool {
+	if r.circuitFailures <= 0 || r.ConsecutiveFailures() < r.circuitFailures {
+		return false
+	}
+	r.errorMutex.Lock()
+	lastErrorTime := r.lastErrorTime
+	r.errorMutex.Unlock()
+	return time.Since(lastErrorTime) < r.circuitCoolDown
+}
@@ -0,0 +1,37 @@
+package balancer
+
+import (
+	"hash/fnv"
+)
+
+// LabRollout canaries an alternative onlineLab config on a deterministic
+// percentage of clients, bucketed by the client instanceID.
+type LabRollout struct {
+	Percentage float64    `json:"percentage"`
+	Lab        *OnlineLab `json:"lab"`
+}
+
+// SetInstanceID sets the identity of the consuming instance used for
+// rollout bucketing. It defaults to the hostname.
+func (r *ConsulResolver) SetInstanceID(instanceID string) {
+	r.instanceID = instanceID
+}
+
+func rolloutBucket(instanceID string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(instanceID))
+	return float64(h.Sum32() % 100)
+}
+
+func (r *ConsulResolver) applyRollout() {
+	ro := r.onlineLab.Rollout
+	if ro == nil || ro.Lab == nil {
+		return
+	}
+	if rolloutBucket(r.instanceID) >= ro.Percentage {
+		r.logger.Debugf("instance %s not in rollout, percentage: %f", r.instanceID, ro.Percentage)
+		return
+	}
+	r.onlineLab = ro.Lab
+	r.logger.Debugf("instance %s in rollout, apply onlineLab: %+v", r.instanceID, r.onlineLab)
+}
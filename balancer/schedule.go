@@ -0,0 +1,71 @@
+package balancer
+
+import (
+	"time"
+)
+
+// LabSchedule overrides balancing parameters during a daily local time
+// window. Start and End are "15:04" clock times; a window whose End is
+// before its Start spans midnight.
+type LabSchedule struct {
+	Start         string   `json:"start"`
+	End           string   `json:"end"`
+	CPUThreshold  *float64 `json:"cpuThreshold,omitempty"`
+	CrossZoneRate *float64 `json:"crossZoneRate,omitempty"`
+}
+
+// Active reports whether t falls inside the schedule window.
+func (s *LabSchedule) Active(t time.Time) bool {
+	start, err := time.Parse("15:04", s.Start)
+	if err != nil {
+		return false
+	}
+	end, err := time.Parse("15:04", s.End)
+	if err != nil {
+		return false
+	}
+	now := t.Hour()*60 + t.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+	if from <= to {
+		return now >= from && now < to
+	}
+	return now >= from || now < to
+}
+
+// applySchedule selects the schedule active at t. The fetched threshold
+// and lab are left as they are, so the overrides end with their window
+// and the saved generations hold the documents as fetched.
+func (r *ConsulResolver) applySchedule(t time.Time) {
+	r.schedule = nil
+	if r.onlineLab == nil {
+		return
+	}
+	for i := range r.onlineLab.Schedules {
+		s := &r.onlineLab.Schedules[i]
+		if !s.Active(t) {
+			continue
+		}
+		r.schedule = s
+		r.logger.Debugf("apply schedule %s-%s, cpuThreshold: %f, crossZoneRate: %f", s.Start, s.End, r.scheduledCPUThreshold(), r.scheduledCrossZoneRate())
+		return
+	}
+}
+
+// scheduledCPUThreshold is the cpu threshold with the active schedule
+// applied. It must be called with r.updateMutex held.
+func (r *ConsulResolver) scheduledCPUThreshold() float64 {
+	if r.schedule != nil && r.schedule.CPUThreshold != nil {
+		return *r.schedule.CPUThreshold
+	}
+	return r.cpuThreshold
+}
+
+// scheduledCrossZoneRate is the cross zone rate of the lab with the active
+// schedule applied. It must be called with r.updateMutex held.
+func (r *ConsulResolver) scheduledCrossZoneRate() float64 {
+	if r.schedule != nil && r.schedule.CrossZoneRate != nil {
+		return *r.schedule.CrossZoneRate
+	}
+	return r.onlineLab.CrossZoneRate
+}
@@ -0,0 +1,64 @@
+package balancer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLabScheduleActive(t *testing.T) {
+	Convey("Test LabSchedule Active", t, func() {
+		at := func(clock string) time.Time {
+			tm, _ := time.Parse("15:04", clock)
+			return tm
+		}
+		Convey("Given a daytime window", func() {
+			s := balancer.LabSchedule{Start: "09:00", End: "18:00"}
+			So(s.Active(at("09:00")), ShouldBeTrue)
+			So(s.Active(at("17:59")), ShouldBeTrue)
+			So(s.Active(at("18:00")), ShouldBeFalse)
+			So(s.Active(at("03:00")), ShouldBeFalse)
+		})
+		Convey("Given a window spanning midnight", func() {
+			s := balancer.LabSchedule{Start: "22:00", End: "04:00"}
+			So(s.Active(at("23:30")), ShouldBeTrue)
+			So(s.Active(at("01:00")), ShouldBeTrue)
+			So(s.Active(at("12:00")), ShouldBeFalse)
+		})
+		Convey("Given an invalid window", func() {
+			s := balancer.LabSchedule{Start: "9am", End: "18:00"}
+			So(s.Active(at("10:00")), ShouldBeFalse)
+		})
+	})
+}
+
+func TestScheduleOverrides(t *testing.T) {
+	Convey("Test schedule overrides", t, func() {
+		f, server := newFakeConsul()
+		defer server.Close()
+		threshold, rate := 10.0, 0.9
+		lab := balancer.DefaultOnlineLab()
+		lab.CrossZoneRate = 0.2
+		// together the windows span the whole day
+		lab.Schedules = []balancer.LabSchedule{
+			{Start: "00:00", End: "12:00", CPUThreshold: &threshold, CrossZoneRate: &rate},
+			{Start: "12:00", End: "00:00", CPUThreshold: &threshold, CrossZoneRate: &rate},
+		}
+		f.kv["clb/lab"] = lab
+		r := newFakeResolver(server.URL)
+		var changes []balancer.ConfigChange
+		r.OnConfigChanged(func(change balancer.ConfigChange) { changes = append(changes, change) })
+		So(r.Start(), ShouldBeNil)
+		defer r.Stop()
+
+		Convey("Given an active schedule, the fetched documents are left as they are", func() {
+			So(r.Update(), ShouldBeNil)
+			So(r.Update(), ShouldBeNil)
+			So(changes, ShouldHaveLength, 1)
+			So(changes[0].CPUThreshold, ShouldEqual, 50)
+			So(changes[0].OnlineLab.CrossZoneRate, ShouldEqual, 0.2)
+		})
+	})
+}
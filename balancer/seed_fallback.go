@@ -0,0 +1,69 @@
+package balancer
+
+import (
+	"time"
+)
+
+// FALLBACK_WINDOW is how long update cycles may fail before the fallback
+// nodes are served.
+const FALLBACK_WINDOW = time.Minute
+
+// SetFallbackNodes sets the static seed nodes served when the health query
+// returns no passing instance, e.g. during a mass health check flap, or
+// when update cycles have been failing for longer than window
+// (FALLBACK_WINDOW when not positive), and by Start when its first update
+// cycle fails. Their factors are their BalanceFactor, or uniform when it
+// is not positive. The resolver reports Degraded while serving them
+// because of failed updates.
+func (r *ConsulResolver) SetFallbackNodes(nodes []ServiceNode, window time.Duration) {
+	if window <= 0 {
+		window = FALLBACK_WINDOW
+	}
+	r.fallbackNodes = nodes
+	r.fallbackWindow = window
+}
+
+// seedNodes returns serviceNodes, or the fallback nodes when it is empty.
+func (r *ConsulResolver) seedNodes(serviceNodes []ServiceNode) []ServiceNode {
+	if len(serviceNodes) > 0 || len(r.fallbackNodes) == 0 {
+		return serviceNodes
+	}
+	r.logger.Warnf("service: %s, no passing instance, use %d fallback nodes", r.service, len(r.fallbackNodes))
+	return append([]ServiceNode(nil), r.fallbackNodes...)
+}
+
+// serveSeeds serves the fallback nodes as they are. It reports whether
+// there are any.
+func (r *ConsulResolver) serveSeeds() bool {
+	if len(r.fallbackNodes) == 0 {
+		return false
+	}
+	nodes := make([]*ServiceNode, len(r.fallbackNodes))
+	for i := range r.fallbackNodes {
+		node := r.fallbackNodes[i]
+		if node.BalanceFactor <= 0 {
+			node.BalanceFactor = 1
+		}
+		node.CurrentFactor = node.BalanceFactor
+		node.AdjustReason = ADJUST_NONE
+		nodes[i] = &node
+	}
+	r.serveFallback(nodes)
+	return true
+}
+
+// fallBackAfterOutage serves the fallback nodes once update cycles have
+// been failing for longer than the fallback window.
+func (r *ConsulResolver) fallBackAfterOutage(cause error) {
+	if len(r.fallbackNodes) == 0 {
+		return
+	}
+	r.mutex.Lock()
+	last, degraded := r.lastUpdate, r.degraded
+	r.mutex.Unlock()
+	if last.IsZero() || degraded || time.Since(last) < r.fallbackWindow {
+		return
+	}
+	r.serveSeeds()
+	r.logger.Warnf("service: %s, update failing since %s, serve %d fallback nodes. err: %s", r.service, last.Format(time.RFC3339), len(r.fallbackNodes), cause.Error())
+}
@@ -0,0 +1,59 @@
+package balancer
+
+import (
+	"time"
+)
+
+type selectCache struct {
+	window time.Duration
+	calls  int
+	pool   *CandidatePool
+	idx    int
+	uses   int
+	until  time.Time
+}
+
+// SetSelectCache reuses the last selected node for up to calls selections
+// or for window, whichever ends first; a zero value disables that limit.
+// Reusing by call count keeps the long-run distribution of the weighted
+// picker, a time window bounds the error by the QPS within it. The cache
+// is dropped whenever the candidate pool changes.
+func (r *ConsulResolver) SetSelectCache(window time.Duration, calls int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if window <= 0 && calls <= 0 {
+		r.selectCache = nil
+		return
+	}
+	r.selectCache = &selectCache{window: window, calls: calls}
+}
+
+// cachedSelect must be called with r.mutex held.
+func (r *ConsulResolver) cachedSelect() (int, bool) {
+	c := r.selectCache
+	if c == nil || c.pool != r.candidatePool || c.idx >= len(r.candidatePool.Nodes) {
+		return -1, false
+	}
+	if c.calls > 0 && c.uses >= c.calls {
+		return -1, false
+	}
+	if c.window > 0 && !time.Now().Before(c.until) {
+		return -1, false
+	}
+	c.uses++
+	return c.idx, true
+}
+
+// storeSelect must be called with r.mutex held.
+func (r *ConsulResolver) storeSelect(idx int) {
+	c := r.selectCache
+	if c == nil {
+		return
+	}
+	c.pool = r.candidatePool
+	c.idx = idx
+	c.uses = 1
+	if c.window > 0 {
+		c.until = time.Now().Add(c.window)
+	}
+}
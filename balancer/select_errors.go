@@ -0,0 +1,53 @@
+package balancer
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNoCandidates is returned when the pool has no node to select. It
+	// is ErrNoNode, so callers of the proxy and of SelectNodeE match one.
+	ErrNoCandidates = ErrNoNode
+	// ErrNotStarted is returned before the first successful update.
+	ErrNotStarted = errors.New("resolver not started")
+	// ErrStopped is returned after Stop.
+	ErrStopped = errors.New("resolver stopped")
+)
+
+// SelectNodeE is SelectNode telling why no node was selected: one of
+// ErrNotStarted, ErrStopped, ErrNoCandidates or a *StaleError.
+func (r *ConsulResolver) SelectNodeE() (*ServiceNode, error) {
+	if r.selectDuration != nil {
+		defer r.recordSelect(time.Now())
+	}
+	if err := r.selectState(); err != nil {
+		return nil, err
+	}
+	if err := r.ensureFresh(); err != nil {
+		return nil, err
+	}
+	node, _ := r.selectNode(nil, nil)
+	if node == nil {
+		return nil, ErrNoCandidates
+	}
+	return r.observe(node), nil
+}
+
+func (r *ConsulResolver) selectState() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	switch {
+	case r.stopped:
+		return ErrStopped
+	case r.candidatePool == nil:
+		return ErrNotStarted
+	}
+	return nil
+}
+
+func (r *ConsulResolver) setStopped(stopped bool) {
+	r.mutex.Lock()
+	r.stopped = stopped
+	r.mutex.Unlock()
+}
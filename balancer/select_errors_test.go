@@ -0,0 +1,39 @@
+package balancer_test
+
+import (
+	"testing"
+
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSelectNodeE(t *testing.T) {
+	Convey("Test SelectNodeE", t, func() {
+		f, server := newFakeConsul()
+		defer server.Close()
+		r := newFakeResolver(server.URL)
+		Convey("Given a resolver not started yet, ErrNotStarted is returned", func() {
+			_, err := r.SelectNodeE()
+			So(err, ShouldEqual, balancer.ErrNotStarted)
+		})
+		Convey("Given a started resolver, a node is returned until it is stopped", func() {
+			So(r.Start(), ShouldBeNil)
+			node, err := r.SelectNodeE()
+			So(err, ShouldBeNil)
+			So(node, ShouldNotBeNil)
+			r.Stop()
+			_, err = r.SelectNodeE()
+			So(err, ShouldEqual, balancer.ErrStopped)
+		})
+		Convey("Given no local zone nodes, ErrNoCandidates is returned", func() {
+			f.mutex.Lock()
+			f.nodes = f.nodes[2:]
+			f.mutex.Unlock()
+			So(r.Start(), ShouldBeNil)
+			defer r.Stop()
+			_, err := r.SelectNodeE()
+			So(err, ShouldEqual, balancer.ErrNoCandidates)
+			So(err, ShouldEqual, balancer.ErrNoNode)
+		})
+	})
+}
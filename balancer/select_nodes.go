@@ -0,0 +1,61 @@
+package balancer
+
+// SelectNodes returns up to n distinct nodes for fanout or hedged
+// requests, in the order the weighted algorithm, or the picker set with
+// SetPicker, picks them: nodes of the local zone first, then those of the
+// other zones, the nodes SelectNode would skip, e.g. slow or ejected ones,
+// last within each. A pinned node comes first. In observer mode the nodes
+// come from the fallback picker. It returns nil when the pool is empty.
+func (r *ConsulResolver) SelectNodes(n int) []*ServiceNode {
+	if n <= 0 {
+		return nil
+	}
+	if err := r.ensureFresh(); err != nil {
+		return nil
+	}
+	return r.observeNodes(r.selectNodes(n))
+}
+
+func (r *ConsulResolver) selectNodes(n int) []*ServiceNode {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	pool := r.candidatePool
+	if pool == nil || len(pool.Nodes) == 0 {
+		return nil
+	}
+	if n > len(pool.Nodes) {
+		n = len(pool.Nodes)
+	}
+	nodes := make([]*ServiceNode, 0, n)
+	chosen := make([]bool, len(pool.Nodes))
+	if node := r.pinnedNode(); node != nil {
+		nodes = append(nodes, node)
+		for i, v := range pool.Nodes {
+			if nodeKey(v) == nodeKey(node) {
+				chosen[i] = true
+			}
+		}
+	}
+	passes := []func(i int) bool{
+		func(i int) bool { return chosen[i] || pool.Nodes[i].Zone != r.zone },
+		func(i int) bool { return chosen[i] },
+	}
+	for _, skip := range passes {
+		for len(nodes) < n {
+			idx := r.pickIndex(skip)
+			if idx < 0 {
+				break
+			}
+			chosen[idx] = true
+			r.countSelect(idx)
+			node := pool.Nodes[idx]
+			r.metric.selectNum += 1
+			if node.Zone != r.zone {
+				r.metric.crossZoneNum += 1
+			}
+			nodes = append(nodes, node)
+		}
+	}
+	r.logger.Debugf("select nodes: %d of %d", len(nodes), n)
+	return nodes
+}
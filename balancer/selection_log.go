@@ -0,0 +1,34 @@
+package balancer
+
+import (
+	"github.com/mae-pax/consul-loadbalancer/util"
+)
+
+// SetSelectionLog logs one out of every n selections at info level to
+// logger, or the resolver logger if nil, with the node, zone, factor and
+// pool epoch, as a low-volume audit trail of routing decisions. Zero
+// disables it.
+func (r *ConsulResolver) SetSelectionLog(n uint64, logger util.Logger) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.selectionLogEvery = n
+	r.selectionLog = logger
+}
+
+// logSelection must be called with r.mutex held.
+func (r *ConsulResolver) logSelection(idx int) {
+	if r.selectionLogEvery == 0 {
+		return
+	}
+	r.selectionLogCount++
+	if r.selectionLogCount%r.selectionLogEvery != 0 {
+		return
+	}
+	logger := r.redacted(r.selectionLog)
+	if logger == nil {
+		logger = r.logger
+	}
+	node := r.candidatePool.Nodes[idx]
+	logger.Infof("service: %s, select node: %s, host: %s, zone: %s, factor: %f, epoch: %d",
+		r.service, node.InstanceID, node.Host, node.Zone, r.candidatePool.Factors[idx], r.candidatePool.Epoch)
+}
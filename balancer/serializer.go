@@ -0,0 +1,64 @@
+package balancer
+
+import (
+	"encoding/json"
+)
+
+// Serializer decodes and encodes the JSON documents of the resolver.
+// jsoniter.API and sonic.API both satisfy it, e.g.
+// r.SetSerializer(sonic.ConfigStd).
+type Serializer interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type stdSerializer struct{}
+
+func (stdSerializer) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (stdSerializer) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// StdSerializer is the encoding/json Serializer.
+var StdSerializer Serializer = stdSerializer{}
+
+// SetSerializer replaces the default Serializer, which is jsoniter unless
+// built with the nojsoniter tag.
+func (r *ConsulResolver) SetSerializer(s Serializer) {
+	r.serializer = s
+}
+
+// SetDocumentSerializer uses s for the document at key only.
+func (r *ConsulResolver) SetDocumentSerializer(key string, s Serializer) {
+	r.setDocumentSerializers(map[string]Serializer{key: s})
+}
+
+// setDocumentSerializers adds serializers to a copy of the document
+// serializers and swaps it in, so serializerFor reads without a lock.
+func (r *ConsulResolver) setDocumentSerializers(serializers map[string]Serializer) {
+	r.serializerMutex.Lock()
+	defer r.serializerMutex.Unlock()
+	current, _ := r.documentSerializers.Load().(map[string]Serializer)
+	m := make(map[string]Serializer, len(current)+len(serializers))
+	for k, v := range current {
+		m[k] = v
+	}
+	for k, v := range serializers {
+		m[k] = v
+	}
+	r.documentSerializers.Store(m)
+}
+
+func (r *ConsulResolver) serializerFor(key string) Serializer {
+	serializers, _ := r.documentSerializers.Load().(map[string]Serializer)
+	if s, ok := serializers[key]; ok {
+		return s
+	}
+	if r.serializer != nil {
+		return r.serializer
+	}
+	return DefaultSerializer
+}
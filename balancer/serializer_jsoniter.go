@@ -0,0 +1,11 @@
+//go:build !nojsoniter
+// +build !nojsoniter
+
+package balancer
+
+import (
+	jsoniter "github.com/json-iterator/go"
+)
+
+// DefaultSerializer is used when no Serializer is set.
+var DefaultSerializer Serializer = jsoniter.ConfigCompatibleWithStandardLibrary
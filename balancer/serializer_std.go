@@ -0,0 +1,7 @@
+//go:build nojsoniter
+// +build nojsoniter
+
+package balancer
+
+// DefaultSerializer is used when no Serializer is set.
+var DefaultSerializer Serializer = StdSerializer
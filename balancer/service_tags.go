@@ -0,0 +1,49 @@
+package balancer
+
+import (
+	"github.com/hashicorp/consul/api"
+)
+
+// SetTags restricts the candidate pool to nodes registered with every one
+// of tags, e.g. "v2" or "canary=false", so deployment tracks sharing a
+// service name can be resolved separately. Consul filters the health
+// query; nodes read from a k8s service key are filtered locally.
+func (r *ConsulResolver) SetTags(tags ...string) {
+	r.tags = tags
+}
+
+func (r *ConsulResolver) healthService(q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+	if len(r.tags) == 0 {
+		return r.client.Health().Service(r.service, "", true, q)
+	}
+	return r.client.Health().ServiceMultipleTags(r.service, r.tags, true, q)
+}
+
+func (r *ConsulResolver) filterTags(nodes []ServiceNode) []ServiceNode {
+	if len(r.tags) == 0 {
+		return nodes
+	}
+	out := nodes[:0:0]
+	for _, node := range nodes {
+		if hasTags(node.Tags, r.tags) {
+			out = append(out, node)
+		}
+	}
+	return out
+}
+
+func hasTags(tags, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, t := range tags {
+			if t == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
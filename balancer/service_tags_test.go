@@ -0,0 +1,25 @@
+package balancer_test
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTags(t *testing.T) {
+	Convey("Test Tags", t, func() {
+		f, server := newFakeConsul()
+		defer server.Close()
+		f.nodes[0].Tags = []string{"v1"}
+		f.nodes[1].Tags = []string{"v2", "canary=false"}
+		r := newFakeResolver(server.URL)
+
+		Convey("Given tags, only nodes with all of them enter the pool", func() {
+			r.SetTags("v2", "canary=false")
+			So(r.Start(), ShouldBeNil)
+			defer r.Stop()
+			So(len(r.CandidateNodes()), ShouldEqual, 1)
+			So(r.CandidateNodes()[0].InstanceID, ShouldEqual, "i-2")
+		})
+	})
+}
@@ -0,0 +1,91 @@
+package balancer
+
+import (
+	"time"
+)
+
+const (
+	STRATEGY_ROUND_ROBIN     = "round_robin"
+	STRATEGY_RANDOM          = "random"
+	STRATEGY_LEAST_IN_FLIGHT = "least_in_flight"
+)
+
+// ResolverSettings is the document stored at the settings key. It carries
+// the resolver's own knobs, which otherwise take a restart to change. Zero
+// values keep what the resolver was built with.
+type ResolverSettings struct {
+	IntervalMs int64 `json:"intervalMs"`
+	// CrossZone overrides the onlineLab crossZone when set.
+	CrossZone *bool `json:"crossZone"`
+	// FactorBounds replaces the BALANCEFACTOR_* clamps of every zone; the
+	// onlineLab zoneBounds still win for their zones.
+	FactorBounds *FactorBounds `json:"factorBounds"`
+	// Strategy is one of the STRATEGY_* constants.
+	Strategy string `json:"strategy"`
+}
+
+// SetSettingsKey makes the resolver read its ResolverSettings from key
+// every update cycle and apply them without a restart. A missing key
+// keeps the settings the resolver was built with.
+func (r *ConsulResolver) SetSettingsKey(key string) {
+	r.settingsKey = key
+}
+
+func (r *ConsulResolver) updateSettings() error {
+	if r.settingsKey == "" {
+		return nil
+	}
+	var s ResolverSettings
+	err := r.getKV(r.settingsKey, &s)
+	if ue, ok := err.(*UpdateError); ok && ue.Class == ERROR_KEY_MISSING {
+		r.settings = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	switch s.Strategy {
+	case "", STRATEGY_ROUND_ROBIN, STRATEGY_RANDOM, STRATEGY_LEAST_IN_FLIGHT:
+	default:
+		r.logger.Warnf("service: %s, unknown strategy %q in settings, key: %s", r.service, s.Strategy, r.settingsKey)
+		s.Strategy = ""
+	}
+	if s.IntervalMs < 0 {
+		s.IntervalMs = 0
+	}
+	r.settings = &s
+	return nil
+}
+
+// applySettings publishes the settings read by the selecting goroutines
+// and the update loop. It must be called with r.mutex held.
+func (r *ConsulResolver) applySettings() {
+	strategy, interval := "", time.Duration(0)
+	if s := r.settings; s != nil {
+		strategy = s.Strategy
+		interval = time.Duration(s.IntervalMs) * time.Millisecond
+	}
+	if strategy != r.strategy || interval != r.liveInterval {
+		r.logger.Infof("service: %s, settings applied, strategy: %q, interval: %s", r.service, strategy, interval)
+	}
+	r.strategy = strategy
+	r.liveInterval = interval
+}
+
+// loopInterval returns the interval of the update loop.
+func (r *ConsulResolver) loopInterval() time.Duration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.liveInterval > 0 {
+		return r.liveInterval
+	}
+	return r.interval
+}
+
+// crossZone reports whether the pool may spill into other zones.
+func (r *ConsulResolver) crossZone() bool {
+	if r.settings != nil && r.settings.CrossZone != nil {
+		return *r.settings.CrossZone
+	}
+	return r.onlineLab.CrossZone
+}
@@ -0,0 +1,45 @@
+package balancer_test
+
+import (
+	"testing"
+
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSettings(t *testing.T) {
+	Convey("Test Settings", t, func() {
+		f, server := newFakeConsul()
+		defer server.Close()
+		r := newFakeResolver(server.URL)
+		r.SetSettingsKey("clb/settings")
+		So(r.Start(), ShouldBeNil)
+		defer r.Stop()
+
+		Convey("Given a strategy in the settings, it is applied without a restart", func() {
+			f.mutex.Lock()
+			f.kv["clb/settings"] = balancer.ResolverSettings{Strategy: balancer.STRATEGY_LEAST_IN_FLIGHT, IntervalMs: 500}
+			f.mutex.Unlock()
+			So(r.Update(), ShouldBeNil)
+			So(countSelect(r, 10)["i-2"], ShouldEqual, 10)
+
+			f.mutex.Lock()
+			delete(f.kv, "clb/settings")
+			f.mutex.Unlock()
+			So(r.Update(), ShouldBeNil)
+			So(countSelect(r, 10)["i-1"], ShouldBeGreaterThan, 0)
+		})
+		Convey("Given crossZone in the settings, it overrides the online lab", func() {
+			crossZone := true
+			f.mutex.Lock()
+			f.kv["clb/settings"] = balancer.ResolverSettings{CrossZone: &crossZone}
+			f.mutex.Unlock()
+			remote := newFakeResolver(server.URL)
+			remote.SetSettingsKey("clb/settings")
+			remote.SetZone("c")
+			So(remote.Start(), ShouldBeNil)
+			defer remote.Stop()
+			So(len(remote.CandidateNodes()), ShouldEqual, 3)
+		})
+	})
+}
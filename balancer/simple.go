@@ -0,0 +1,79 @@
+package balancer
+
+import (
+	"time"
+)
+
+// SIMPLE_INTERVAL is the update interval of a SimpleResolver built with a
+// non-positive one.
+const SIMPLE_INTERVAL = 10 * time.Second
+
+// DefaultOnlineLab is the OnlineLab a SimpleResolver starts with.
+func DefaultOnlineLab() *OnlineLab {
+	return &OnlineLab{
+		FactorCacheExpire: 1000,
+		FactorStartRate:   1,
+		LearningRate:      0.05,
+		RateThreshold:     0.1,
+	}
+}
+
+// NewSimpleResolver builds a resolver over a static node list, running the
+// same factor learning and pickers without any Consul connection. It suits
+// tools, simulations and tests of calling code. onlineLab may be nil for
+// DefaultOnlineLab. Start runs the update cycle every interval as usual,
+// SIMPLE_INTERVAL when interval is not positive; Update runs it once.
+func NewSimpleResolver(zone string, nodes []ServiceNode, onlineLab *OnlineLab, interval time.Duration) (*ConsulResolver, error) {
+	if onlineLab == nil {
+		onlineLab = DefaultOnlineLab()
+	}
+	if nodes == nil {
+		nodes = []ServiceNode{}
+	}
+	if interval <= 0 {
+		interval = SIMPLE_INTERVAL
+	}
+	r := &ConsulResolver{
+		zone:               zone,
+		interval:           interval,
+		static:             true,
+		onlineLab:          onlineLab,
+		streamNodes:        nodes,
+		zoneCPUMap:         make(map[string]float64),
+		instanceFactorMap:  make(map[string]float64),
+		balanceFactorCache: make(map[string]float64),
+		errorCounts:        make(map[ErrorClass]int),
+	}
+	r.initLogger()
+	if err := r.updateAll(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Update runs one update cycle of a SimpleResolver.
+func (r *ConsulResolver) Update() error {
+	return r.updateAll()
+}
+
+// SetNodes replaces the node list of a SimpleResolver and updates it.
+func (r *ConsulResolver) SetNodes(nodes []ServiceNode) error {
+	if nodes == nil {
+		nodes = []ServiceNode{}
+	}
+	r.updateMutex.Lock()
+	r.streamNodes = nodes
+	r.updateMutex.Unlock()
+	return r.updateAll()
+}
+
+// SetWorkloads sets the cpu utilization of instances and zones, in percent,
+// that a SimpleResolver learns from on the next update.
+func (r *ConsulResolver) SetWorkloads(instances, zones map[string]float64) {
+	r.updateMutex.Lock()
+	defer r.updateMutex.Unlock()
+	r.instanceFactorMap = instances
+	r.zoneCPUMap = zones
+	r.zoneCPUUpdated = true
+	r.zoneCPUTime = 0
+}
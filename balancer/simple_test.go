@@ -0,0 +1,741 @@
+package balancer_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	"github.com/mae-pax/consul-loadbalancer/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testNodes() []balancer.ServiceNode {
+	return []balancer.ServiceNode{
+		{InstanceID: "i-1", Host: "10.0.0.1", Port: 80, Zone: "a", BalanceFactor: 300},
+		{InstanceID: "i-2", Host: "10.0.0.2", Port: 80, Zone: "a", BalanceFactor: 900},
+		{InstanceID: "i-3", Host: "10.0.1.1", Port: 80, Zone: "b", BalanceFactor: 300},
+	}
+}
+
+func countSelect(r *balancer.ConsulResolver, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		node := r.SelectNode()
+		if node == nil {
+			counts[""]++
+			continue
+		}
+		counts[node.InstanceID]++
+	}
+	return counts
+}
+
+func TestSimpleResolver(t *testing.T) {
+	Convey("Test SimpleResolver", t, func() {
+		Convey("Given static nodes, SelectNode follows the local zone factors", func() {
+			r, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+			So(err, ShouldBeNil)
+			counts := countSelect(r, 400)
+			So(counts["i-1"], ShouldEqual, 100)
+			So(counts["i-2"], ShouldEqual, 300)
+			So(counts["i-3"], ShouldEqual, 0)
+			m := r.Metrics()
+			So(m.SelectNum, ShouldEqual, 400)
+			So(m.CrossZoneNum, ShouldEqual, 0)
+			So(m.CandidatePoolSize, ShouldEqual, 2)
+			So(m.LastError, ShouldBeNil)
+		})
+		Convey("Given no interval, Start runs the update loop and Stop ends it", func() {
+			r, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+			So(err, ShouldBeNil)
+			So(r.Start(), ShouldBeNil)
+			So(r.SelectNode(), ShouldNotBeNil)
+			r.Stop()
+			_, err = r.SelectNodeE()
+			So(err, ShouldEqual, balancer.ErrStopped)
+		})
+		Convey("Given no node in the local zone and crossZone off, SelectNode returns nil", func() {
+			r, err := balancer.NewSimpleResolver("c", testNodes(), nil, 0)
+			So(err, ShouldBeNil)
+			So(r.SelectNode(), ShouldBeNil)
+		})
+		Convey("Given SetNodes, the pool is rebuilt", func() {
+			r, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+			So(err, ShouldBeNil)
+			So(r.SetNodes(testNodes()[:1]), ShouldBeNil)
+			So(countSelect(r, 10)["i-1"], ShouldEqual, 10)
+		})
+		Convey("Given an update, the pool epoch increments", func() {
+			r, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+			So(err, ShouldBeNil)
+			_, epoch := r.SelectNodeEpoch()
+			So(epoch, ShouldEqual, 1)
+			So(r.Update(), ShouldBeNil)
+			_, epoch = r.SelectNodeEpoch()
+			So(epoch, ShouldEqual, 2)
+		})
+		Convey("Given soft removal, a vanished node stays at reduced weight", func() {
+			r, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+			So(err, ShouldBeNil)
+			r.SetSoftRemoval(time.Hour, 0.5)
+			So(r.Update(), ShouldBeNil)
+			nodes := testNodes()
+			So(r.SetNodes([]balancer.ServiceNode{nodes[0], nodes[2]}), ShouldBeNil)
+			counts := countSelect(r, 750)
+			So(counts["i-1"], ShouldEqual, 300)
+			So(counts["i-2"], ShouldEqual, 450)
+		})
+		Convey("Given a caller label, selections are counted per caller", func() {
+			r, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+			So(err, ShouldBeNil)
+			ctx := balancer.WithCaller(context.Background(), "route-1")
+			for i := 0; i < 5; i++ {
+				So(r.SelectNodeContext(ctx), ShouldNotBeNil)
+			}
+			r.SelectNode()
+			m := r.CallerMetrics()
+			So(len(m), ShouldEqual, 1)
+			So(m["route-1"].SelectNum, ShouldEqual, 5)
+		})
+		Convey("Given weighted random, every selection is a local zone node", func() {
+			r, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+			So(err, ShouldBeNil)
+			r.SetWeightedRandom(true)
+			counts := countSelect(r, 100)
+			So(counts["i-1"]+counts["i-2"], ShouldEqual, 100)
+		})
+	})
+}
+
+func TestMaxStaleness(t *testing.T) {
+	Convey("Test MaxStaleness", t, func() {
+		r, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+		So(err, ShouldBeNil)
+		Convey("Given a stale pool, selecting refreshes it synchronously", func() {
+			r.MaxStaleness(time.Millisecond)
+			time.Sleep(5 * time.Millisecond)
+			_, epoch := r.SelectNodeEpoch()
+			So(epoch, ShouldEqual, 2)
+			node, err := r.SelectNodeFresh()
+			So(err, ShouldBeNil)
+			So(node, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestSelectNodeByKey(t *testing.T) {
+	Convey("Test SelectNodeByKey", t, func() {
+		r, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+		So(err, ShouldBeNil)
+		Convey("Given the same key, the same node is selected", func() {
+			node := r.SelectNodeByKey("user-1")
+			So(node, ShouldNotBeNil)
+			for i := 0; i < 10; i++ {
+				So(r.SelectNodeByKey("user-1"), ShouldEqual, node)
+			}
+		})
+		Convey("Given many keys, they spread in proportion to the factors", func() {
+			counts := make(map[string]int)
+			for i := 0; i < 4000; i++ {
+				counts[r.SelectNodeByKey(fmt.Sprintf("user-%d", i)).InstanceID]++
+			}
+			So(counts["i-2"], ShouldBeBetween, 2700, 3300)
+			So(counts["i-3"], ShouldEqual, 0)
+		})
+	})
+}
+
+func TestLeastInFlight(t *testing.T) {
+	Convey("Test LeastInFlight", t, func() {
+		r, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+		So(err, ShouldBeNil)
+		r.SetLeastInFlight(true)
+		Convey("Given idle nodes, the highest factor is picked", func() {
+			So(r.SelectNode().InstanceID, ShouldEqual, "i-2")
+		})
+		Convey("Given a busy node, the less loaded one is picked until done", func() {
+			node := r.SelectNode()
+			var dones []func()
+			for i := 0; i < 5; i++ {
+				dones = append(dones, r.Acquire(node))
+			}
+			So(r.InFlight(node), ShouldEqual, 5)
+			So(r.SelectNode().InstanceID, ShouldEqual, "i-1")
+			for _, done := range dones {
+				done()
+				done()
+			}
+			So(r.InFlight(node), ShouldEqual, 0)
+			So(r.SelectNode().InstanceID, ShouldEqual, "i-2")
+		})
+		Convey("Given a node without a factor, it is picked only when alone", func() {
+			nodes := testNodes()[:1]
+			nodes = append(nodes, balancer.ServiceNode{InstanceID: "i-0", Host: "10.0.0.9", Port: 80, Zone: "a"})
+			r.SetStaticWeights(true)
+			So(r.SetNodes(nodes), ShouldBeNil)
+			So(countSelect(r, 10)["i-1"], ShouldEqual, 10)
+			So(r.SetNodes(nodes[1:]), ShouldBeNil)
+			So(countSelect(r, 10)["i-0"], ShouldEqual, 10)
+		})
+		Convey("Given a Release without an Acquire, the count stays at zero", func() {
+			node := r.SelectNode()
+			r.Release(node)
+			So(r.InFlight(node), ShouldEqual, 0)
+			done := r.Acquire(node)
+			So(r.InFlight(node), ShouldEqual, 1)
+			done()
+			So(r.InFlight(node), ShouldEqual, 0)
+		})
+		Convey("Given a node leaves the pool, its count is dropped", func() {
+			node := r.SelectNode()
+			r.Acquire(node)
+			So(r.SetNodes(testNodes()[:1]), ShouldBeNil)
+			So(r.InFlight(node), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestReportResult(t *testing.T) {
+	Convey("Test ReportResult", t, func() {
+		r, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+		So(err, ShouldBeNil)
+		r.SetFeedback(0)
+		Convey("Given a failing node, its factor drops to the floor on the next update", func() {
+			nodes := r.CandidateNodes()
+			for i := 0; i < 30; i++ {
+				r.ReportResult(&nodes[0], time.Millisecond, nil)
+				r.ReportResult(&nodes[1], time.Millisecond, errors.New("failed"))
+			}
+			So(r.Update(), ShouldBeNil)
+			factors := make(map[string]float64)
+			for _, node := range r.CandidateNodes() {
+				factors[node.InstanceID] = node.CurrentFactor
+			}
+			So(factors["i-1"], ShouldEqual, 300)
+			So(factors["i-2"], ShouldAlmostEqual, 90)
+		})
+	})
+}
+
+func TestMaxCrossZones(t *testing.T) {
+	Convey("Test MaxCrossZones", t, func() {
+		lab := balancer.DefaultOnlineLab()
+		lab.CrossZone = true
+		lab.MaxCrossZones = 1
+		lab.ZoneCosts = map[string]float64{"b": 1, "a": 5}
+		Convey("Given more remote zones than MaxCrossZones, only the cheapest are candidates", func() {
+			r, err := balancer.NewSimpleResolver("x", testNodes(), lab, 0)
+			So(err, ShouldBeNil)
+			nodes := r.CandidateNodes()
+			So(len(nodes), ShouldEqual, 1)
+			So(nodes[0].Zone, ShouldEqual, "b")
+		})
+	})
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	Convey("Test CircuitBreaker", t, func() {
+		r, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+		So(err, ShouldBeNil)
+		r.SetCircuitBreaker(3, 50*time.Millisecond, 0.5)
+		nodes := r.CandidateNodes()
+		Convey("Given consecutive failures, the node is ejected and then re-admitted at a reduced factor", func() {
+			for i := 0; i < 3; i++ {
+				r.ReportResult(&nodes[1], time.Millisecond, errors.New("failed"))
+			}
+			So(countSelect(r, 100)["i-2"], ShouldEqual, 0)
+			So(r.Update(), ShouldBeNil)
+			So(len(r.CandidateNodes()), ShouldEqual, 1)
+
+			time.Sleep(60 * time.Millisecond)
+			So(r.Update(), ShouldBeNil)
+			factors := make(map[string]float64)
+			for _, node := range r.CandidateNodes() {
+				factors[node.InstanceID] = node.CurrentFactor
+			}
+			So(factors["i-2"], ShouldAlmostEqual, 450)
+		})
+		Convey("Given a success in between, the failure count resets", func() {
+			for i := 0; i < 5; i++ {
+				r.ReportResult(&nodes[1], time.Millisecond, errors.New("failed"))
+				r.ReportResult(&nodes[1], time.Millisecond, nil)
+			}
+			So(countSelect(r, 100)["i-2"], ShouldBeGreaterThan, 0)
+		})
+	})
+}
+
+func TestHealthProbe(t *testing.T) {
+	Convey("Test HealthProbe", t, func() {
+		var mutex sync.Mutex
+		r, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+		So(err, ShouldBeNil)
+		down := map[string]bool{"i-2": true}
+		probes := make(map[string]int)
+		r.SetHealthProbe(func(ctx context.Context, node *balancer.ServiceNode) error {
+			mutex.Lock()
+			probes[node.InstanceID]++
+			mutex.Unlock()
+			if down[node.InstanceID] {
+				return errors.New("connection refused")
+			}
+			return nil
+		}, time.Second, time.Second)
+		Convey("Given an unreachable node, it is skipped until a probe succeeds", func() {
+			r.ProbeNodes(context.Background())
+			So(countSelect(r, 100)["i-2"], ShouldEqual, 0)
+			So(r.Update(), ShouldBeNil)
+			So(len(r.CandidateNodes()), ShouldEqual, 1)
+
+			down["i-2"] = false
+			r.ProbeNodes(context.Background())
+			So(r.Update(), ShouldBeNil)
+			So(len(r.CandidateNodes()), ShouldEqual, 2)
+		})
+		Convey("Given an unreachable node deregistered, it is no longer probed", func() {
+			r.ProbeNodes(context.Background())
+			So(r.SetNodes(testNodes()[:1]), ShouldBeNil)
+			r.ProbeNodes(context.Background())
+			probed := probes["i-2"]
+			r.ProbeNodes(context.Background())
+			So(probes["i-2"], ShouldEqual, probed)
+		})
+	})
+}
+
+func TestWarmup(t *testing.T) {
+	Convey("Test Warmup", t, func() {
+		nodes := testNodes()
+		nodes[1].StartTime = time.Now().Add(-30 * time.Second)
+		r, err := balancer.NewSimpleResolver("a", nodes, nil, 0)
+		So(err, ShouldBeNil)
+		Convey("Given a node started half the warmup ago, it serves at half its factor", func() {
+			factors := make(map[string]float64)
+			for _, node := range r.CandidateNodes() {
+				factors[node.InstanceID] = node.CurrentFactor
+			}
+			So(factors["i-1"], ShouldEqual, 300)
+			So(factors["i-2"], ShouldAlmostEqual, 450, 5)
+		})
+		Convey("Given a cold node failing, the circuit breaker leaves it alone", func() {
+			r.SetCircuitBreaker(1, time.Minute, 0)
+			candidates := r.CandidateNodes()
+			So(r.Cold(&candidates[1]), ShouldBeTrue)
+			r.ReportResult(&candidates[1], time.Millisecond, errors.New("failed"))
+			So(countSelect(r, 100)["i-2"], ShouldBeGreaterThan, 0)
+		})
+	})
+}
+
+func TestFactorCache(t *testing.T) {
+	Convey("Test FactorCache", t, func() {
+		cache := balancer.NewFactorCache()
+		workloads := map[string]float64{"i-1": 20, "i-2": 80, "i-3": 50}
+		zones := map[string]float64{"a": 50, "b": 50}
+		var resolvers []*balancer.ConsulResolver
+		for i := 0; i < 2; i++ {
+			r, err := balancer.NewSimpleResolver("a", testNodes(), nil, time.Minute)
+			So(err, ShouldBeNil)
+			r.SetFactorCache(cache)
+			r.SetWorkloads(workloads, zones)
+			resolvers = append(resolvers, r)
+		}
+		Convey("Given two resolvers sharing the cache, an instance is learned once per interval", func() {
+			So(resolvers[0].Update(), ShouldBeNil)
+			learned := cache.Factors()["i-2"]
+			So(learned, ShouldBeLessThan, 900)
+			So(resolvers[1].Update(), ShouldBeNil)
+			So(cache.Factors()["i-2"], ShouldEqual, learned)
+			for _, node := range resolvers[1].CandidateNodes() {
+				if node.InstanceID == "i-2" {
+					So(node.CurrentFactor, ShouldEqual, learned)
+				}
+			}
+		})
+	})
+}
+
+func TestStateStore(t *testing.T) {
+	Convey("Test StateStore", t, func() {
+		dir, err := ioutil.TempDir("", "clb-state")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		store := balancer.FileStore{Dir: dir}
+		Convey("Given no saved state, loading reports not found", func() {
+			_, err := store.Load("as/state")
+			So(err, ShouldEqual, balancer.ErrStateNotFound)
+		})
+		Convey("Given a saved state, a new resolver resumes from the learned factors", func() {
+			r, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+			So(err, ShouldBeNil)
+			r.SetStateStore(store, "as/state", 0)
+			r.SetWorkloads(map[string]float64{"i-1": 20, "i-2": 80}, map[string]float64{"a": 50})
+			So(r.Update(), ShouldBeNil)
+			var data []byte
+			for i := 0; i < 100 && data == nil; i++ {
+				time.Sleep(10 * time.Millisecond)
+				data, _ = store.Load("as/state")
+			}
+			So(data, ShouldNotBeNil)
+
+			restored, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+			So(err, ShouldBeNil)
+			restored.SetStateStore(store, "as/state", 0)
+			So(restored.LoadState(), ShouldBeNil)
+			So(restored.Update(), ShouldBeNil)
+			for _, node := range restored.CandidateNodes() {
+				if node.InstanceID == "i-2" {
+					So(node.CurrentFactor, ShouldBeLessThan, 900)
+				}
+			}
+		})
+	})
+}
+
+func TestSelectionLog(t *testing.T) {
+	Convey("Test SelectionLog", t, func() {
+		r, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+		So(err, ShouldBeNil)
+		logger := util.NewRingLogger(100)
+		r.SetSelectionLog(10, logger)
+		Convey("Given 100 selections, one in ten is logged", func() {
+			countSelect(r, 100)
+			lines := logger.Lines()
+			So(len(lines), ShouldEqual, 10)
+			So(lines[0], ShouldContainSubstring, "epoch: ")
+		})
+	})
+}
+
+func TestTrafficFloor(t *testing.T) {
+	Convey("Test TrafficFloor", t, func() {
+		lab := balancer.DefaultOnlineLab()
+		lab.MinNodeShare = 0.4
+		r, err := balancer.NewSimpleResolver("a", testNodes(), lab, 0)
+		So(err, ShouldBeNil)
+		Convey("Given a node below the minimum share, it is raised to exactly the floor", func() {
+			factors := make(map[string]float64)
+			for _, node := range r.CandidateNodes() {
+				factors[node.InstanceID] = node.CurrentFactor
+			}
+			So(factors["i-1"], ShouldAlmostEqual, 600)
+			So(factors["i-2"], ShouldEqual, 900)
+			counts := countSelect(r, 100)
+			So(counts["i-1"], ShouldEqual, 40)
+		})
+	})
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	Convey("Test DiffSnapshots", t, func() {
+		r, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+		So(err, ShouldBeNil)
+		old := r.PoolSnapshot()
+		Convey("Given a node replaced, the diff lists it as removed and added", func() {
+			nodes := testNodes()
+			nodes[0].InstanceID = "i-4"
+			So(r.SetNodes(nodes), ShouldBeNil)
+			diff := balancer.DiffSnapshots(old, r.PoolSnapshot())
+			So(diff.From, ShouldEqual, old.Epoch)
+			So(len(diff.Added), ShouldEqual, 1)
+			So(diff.Added[0].InstanceID, ShouldEqual, "i-4")
+			So(len(diff.Removed), ShouldEqual, 1)
+			So(diff.Removed[0].InstanceID, ShouldEqual, "i-1")
+
+			snapshot, ok := r.Snapshot(old.Epoch)
+			So(ok, ShouldBeTrue)
+			So(balancer.DiffSnapshots(snapshot, old).Empty(), ShouldBeTrue)
+
+			w := httptest.NewRecorder()
+			r.PoolDiffHandler().ServeHTTP(w, httptest.NewRequest("GET", "/pool/diff?from=12345", nil))
+			So(w.Code, ShouldEqual, http.StatusGone)
+		})
+	})
+}
+
+func TestDeployDraining(t *testing.T) {
+	Convey("Test DeployDraining", t, func() {
+		nodes := testNodes()
+		nodes[0].DeployBatch = 2
+		nodes[1].DeployBatch = 3
+		nodes[1].DeployProgress = 2
+		r, err := balancer.NewSimpleResolver("a", nodes, nil, 0)
+		So(err, ShouldBeNil)
+		factor := func(instanceID string) (float64, string) {
+			for _, node := range r.CandidateNodes() {
+				if node.InstanceID == instanceID {
+					return node.CurrentFactor, node.AdjustReason
+				}
+			}
+			return 0, ""
+		}
+		before, _ := factor("i-1")
+
+		Convey("Given batch 2 in flight, its instances are drained", func() {
+			r.SetDeployDraining(0.5)
+			So(r.Update(), ShouldBeNil)
+			after, reason := factor("i-1")
+			So(after, ShouldAlmostEqual, before*0.5)
+			So(reason, ShouldEqual, balancer.ADJUST_DEPLOY)
+		})
+		Convey("Given a replacement started after batch 2 went in flight, it is not drained", func() {
+			r.SetDeployDraining(0.5)
+			r.SetWarmup(-1)
+			So(r.Update(), ShouldBeNil)
+			nodes[0].StartTime = time.Now().Add(time.Second)
+			So(r.SetNodes(nodes), ShouldBeNil)
+			after, reason := factor("i-1")
+			So(after, ShouldAlmostEqual, before)
+			So(reason, ShouldNotEqual, balancer.ADJUST_DEPLOY)
+		})
+		Convey("Given the last batch done, draining stops", func() {
+			r.SetDeployDraining(0.5)
+			nodes[0].DeployBatch = 5
+			nodes[1].DeployProgress = 5
+			nodes[1].DeployTotal = 5
+			So(r.SetNodes(nodes), ShouldBeNil)
+			after, reason := factor("i-1")
+			So(after, ShouldAlmostEqual, before)
+			So(reason, ShouldNotEqual, balancer.ADJUST_DEPLOY)
+		})
+	})
+}
+
+func TestAdaptiveThreshold(t *testing.T) {
+	Convey("Test AdaptiveThreshold", t, func() {
+		workloads := map[string]float64{"i-1": 40, "i-2": 60, "i-3": 50}
+		zones := map[string]float64{"a": 50, "b": 50}
+		factor := func(r *balancer.ConsulResolver) float64 {
+			for _, node := range r.CandidateNodes() {
+				if node.InstanceID == "i-2" {
+					return node.CurrentFactor
+				}
+			}
+			return 0
+		}
+
+		Convey("Given noisy workloads, the fixed threshold learns and the adaptive one does not", func() {
+			fixed, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+			So(err, ShouldBeNil)
+			fixed.SetWorkloads(workloads, zones)
+			So(fixed.Update(), ShouldBeNil)
+			So(factor(fixed), ShouldBeLessThan, 900)
+
+			lab := balancer.DefaultOnlineLab()
+			lab.AdaptiveThreshold = &balancer.AdaptiveThreshold{K: 2}
+			adaptive, err := balancer.NewSimpleResolver("a", testNodes(), lab, 0)
+			So(err, ShouldBeNil)
+			adaptive.SetWorkloads(workloads, zones)
+			So(adaptive.Update(), ShouldBeNil)
+			So(factor(adaptive), ShouldEqual, 900)
+		})
+	})
+}
+
+func TestDuplicatePolicy(t *testing.T) {
+	Convey("Test DuplicatePolicy", t, func() {
+		nodes := testNodes()
+		restarted := nodes[0]
+		restarted.Host = "10.0.0.9"
+		restarted.StartTime = time.Now()
+		nodes = append(nodes, restarted)
+		r, err := balancer.NewSimpleResolver("a", nodes, nil, 0)
+		So(err, ShouldBeNil)
+		hosts := func() map[string]string {
+			m := make(map[string]string)
+			for _, node := range r.CandidateNodes() {
+				m[node.Host] = node.InstanceID
+			}
+			return m
+		}
+		So(len(hosts()), ShouldEqual, 3)
+
+		Convey("Given DUPLICATE_NEWEST, only the latest registration is kept", func() {
+			r.SetDuplicatePolicy(balancer.DUPLICATE_NEWEST)
+			So(r.Update(), ShouldBeNil)
+			So(hosts(), ShouldResemble, map[string]string{"10.0.0.9": "i-1", "10.0.0.2": "i-2"})
+		})
+		Convey("Given DUPLICATE_EXCLUDE, every registration of the instance is dropped", func() {
+			r.SetDuplicatePolicy(balancer.DUPLICATE_EXCLUDE)
+			So(r.Update(), ShouldBeNil)
+			So(hosts(), ShouldResemble, map[string]string{"10.0.0.2": "i-2"})
+		})
+		Convey("Given registrations without an instanceID, they are not duplicates of each other", func() {
+			nodes := []balancer.ServiceNode{
+				{Host: "10.0.1.1", Port: 80, Zone: "a", BalanceFactor: 1},
+				{Host: "10.0.1.2", Port: 80, Zone: "a", BalanceFactor: 1},
+			}
+			r, err := balancer.NewSimpleResolver("a", nodes, nil, 0)
+			So(err, ShouldBeNil)
+			r.SetDuplicatePolicy(balancer.DUPLICATE_EXCLUDE)
+			So(r.Update(), ShouldBeNil)
+			So(r.CandidateNodes(), ShouldHaveLength, 2)
+		})
+	})
+}
+
+func TestSelectNodes(t *testing.T) {
+	Convey("Test SelectNodes", t, func() {
+		lab := balancer.DefaultOnlineLab()
+		lab.CrossZone = true
+		r, err := balancer.NewSimpleResolver("", testNodes(), lab, 0)
+		So(err, ShouldBeNil)
+		// the pool spans every zone, selections prefer a
+		r.SetZone("a")
+		So(r.CandidateNodes(), ShouldHaveLength, 3)
+		ids := func(nodes []*balancer.ServiceNode) []string {
+			var s []string
+			for _, node := range nodes {
+				s = append(s, node.InstanceID)
+			}
+			return s
+		}
+
+		Convey("Given n nodes asked, they are distinct and local first", func() {
+			for i := 0; i < 10; i++ {
+				nodes := ids(r.SelectNodes(2))
+				So(nodes, ShouldHaveLength, 2)
+				So(nodes, ShouldContain, "i-1")
+				So(nodes, ShouldContain, "i-2")
+			}
+		})
+		Convey("Given more nodes asked than the pool has, the whole pool is returned", func() {
+			nodes := ids(r.SelectNodes(5))
+			So(nodes, ShouldHaveLength, 3)
+			So(nodes[2], ShouldEqual, "i-3")
+		})
+		Convey("Given no node asked, none is returned", func() {
+			So(r.SelectNodes(0), ShouldBeNil)
+		})
+		Convey("Given a picker, it picks the nodes, local first", func() {
+			var calls int
+			r.SetPicker(func(nodes []*balancer.ServiceNode) *balancer.ServiceNode {
+				calls++
+				return nodes[len(nodes)-1]
+			})
+			nodes := r.SelectNodes(3)
+			So(calls, ShouldEqual, 3)
+			So(nodes, ShouldHaveLength, 3)
+			So(nodes[0].Zone, ShouldEqual, "a")
+			So(nodes[1].Zone, ShouldEqual, "a")
+			So(nodes[2].InstanceID, ShouldEqual, "i-3")
+		})
+		Convey("Given observer mode, the fallback picks the nodes", func() {
+			var calls int
+			picker := balancer.NewRoundRobinPicker()
+			r.SetObserverMode(func(nodes []*balancer.ServiceNode) *balancer.ServiceNode {
+				calls++
+				return picker(nodes)
+			})
+			So(ids(r.SelectNodes(3)), ShouldHaveLength, 3)
+			So(calls, ShouldEqual, 3)
+			So(r.Metrics().SelectNum, ShouldEqual, 3)
+		})
+	})
+}
+
+func TestObserverMode(t *testing.T) {
+	Convey("Test observer mode", t, func() {
+		r, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+		So(err, ShouldBeNil)
+		r.SetObserverMode(balancer.NewRoundRobinPicker())
+
+		Convey("Given updates running, the fallback picks from every zone", func() {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 20; i++ {
+					r.Update()
+				}
+			}()
+			counts := countSelect(r, 300)
+			<-done
+			So(counts[""], ShouldEqual, 0)
+			So(counts["i-3"], ShouldBeGreaterThan, 0)
+		})
+		Convey("Given observer mode left, the resolver selects again", func() {
+			r.SetObserverMode(nil)
+			So(countSelect(r, 40)["i-3"], ShouldEqual, 0)
+		})
+	})
+}
+
+func TestMirror(t *testing.T) {
+	Convey("Test SelectNodeWithMirror", t, func() {
+		lab := balancer.DefaultOnlineLab()
+		lab.Mirror = &balancer.MirrorConfig{Zone: "b", Rate: 1}
+		r, err := balancer.NewSimpleResolver("a", testNodes(), lab, 0)
+		So(err, ShouldBeNil)
+
+		Convey("Given updates running, every selection is mirrored to zone b", func() {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 20; i++ {
+					r.Update()
+				}
+			}()
+			for i := 0; i < 100; i++ {
+				node, mirror := r.SelectNodeWithMirror()
+				So(node, ShouldNotBeNil)
+				So(mirror, ShouldNotBeNil)
+				So(mirror.InstanceID, ShouldEqual, "i-3")
+			}
+			<-done
+		})
+	})
+}
+
+func TestPinNode(t *testing.T) {
+	Convey("Test PinNode", t, func() {
+		r, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+		So(err, ShouldBeNil)
+
+		Convey("Given pinning is disabled, PinNode fails", func() {
+			So(r.PinNode("i-1", time.Minute), ShouldEqual, balancer.ErrPinDisabled)
+		})
+		Convey("Given a node of another zone pinned, updates keep serving it", func() {
+			r.SetPinEnabled(true)
+			So(r.PinNode("i-9", time.Minute), ShouldEqual, balancer.ErrNodeNotFound)
+			So(r.PinNode("i-3", time.Minute), ShouldBeNil)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 20; i++ {
+					r.Update()
+				}
+			}()
+			counts := countSelect(r, 100)
+			<-done
+			So(counts["i-3"], ShouldEqual, 100)
+			r.UnpinNode()
+			So(countSelect(r, 40)["i-3"], ShouldEqual, 0)
+		})
+	})
+}
+
+func TestSelectNodeExcept(t *testing.T) {
+	Convey("Test SelectNodeExcept", t, func() {
+		r, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+		So(err, ShouldBeNil)
+
+		Convey("Given a node skipped, the others are selected once per call", func() {
+			skip := func(node *balancer.ServiceNode) bool { return node.InstanceID == "i-2" }
+			for i := 0; i < 10; i++ {
+				So(r.SelectNodeExcept(skip).InstanceID, ShouldEqual, "i-1")
+			}
+			So(r.Metrics().SelectNum, ShouldEqual, 10)
+		})
+		Convey("Given every node skipped, none is selected", func() {
+			So(r.SelectNodeExcept(func(*balancer.ServiceNode) bool { return true }), ShouldBeNil)
+		})
+	})
+}
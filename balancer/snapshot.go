@@ -0,0 +1,26 @@
+package balancer
+
+// Service returns the name of the resolved service.
+func (r *ConsulResolver) Service() string {
+	return r.service
+}
+
+// Zone returns the local zone of the resolver.
+func (r *ConsulResolver) Zone() string {
+	return r.zone
+}
+
+// CandidateNodes returns a copy of the nodes in the serving candidate pool
+// with their current factors.
+func (r *ConsulResolver) CandidateNodes() []ServiceNode {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.candidatePool == nil {
+		return nil
+	}
+	nodes := make([]ServiceNode, len(r.candidatePool.Nodes))
+	for i, node := range r.candidatePool.Nodes {
+		nodes[i] = *node
+	}
+	return nodes
+}
@@ -0,0 +1,77 @@
+package balancer
+
+import (
+	"time"
+)
+
+const SOFT_REMOVAL_RATE = 0.5
+
+type vanishedNode struct {
+	node  ServiceNode
+	since time.Time
+}
+
+// SetSoftRemoval keeps a node that disappears from the health response in
+// the pool for grace, with its factor scaled by rate (SOFT_REMOVAL_RATE
+// when not positive), so short anti-entropy blips in Consul do not churn
+// connections across the fleet. A node that comes back within grace is
+// restored at full weight.
+func (r *ConsulResolver) SetSoftRemoval(grace time.Duration, rate float64) {
+	if rate <= 0 {
+		rate = SOFT_REMOVAL_RATE
+	}
+	r.softRemovalGrace = grace
+	r.softRemovalRate = rate
+}
+
+func (r *ConsulResolver) retainVanished(nodes []ServiceNode, now time.Time) []ServiceNode {
+	if r.softRemovalGrace <= 0 {
+		return nodes
+	}
+	if r.vanished == nil {
+		r.vanished = make(map[string]*vanishedNode)
+	}
+	current := make(map[string]bool, len(nodes))
+	for i := range nodes {
+		key := nodeKey(&nodes[i])
+		current[key] = true
+		delete(r.vanished, key)
+	}
+	for key, node := range r.knownNodes {
+		if _, ok := r.vanished[key]; !current[key] && !ok {
+			r.vanished[key] = &vanishedNode{node: node, since: now}
+			r.logger.Infof("service: %s, node %s vanished, retained for %s", r.service, key, r.softRemovalGrace)
+		}
+	}
+
+	out := make([]ServiceNode, len(nodes), len(nodes)+len(r.vanished))
+	copy(out, nodes)
+	softRemoved := make(map[string]bool, len(r.vanished))
+	for key, v := range r.vanished {
+		if now.Sub(v.since) >= r.softRemovalGrace {
+			delete(r.vanished, key)
+			continue
+		}
+		out = append(out, v.node)
+		softRemoved[key] = true
+	}
+	known := make(map[string]ServiceNode, len(out))
+	for _, node := range out {
+		known[nodeKey(&node)] = node
+	}
+	r.knownNodes = known
+	r.softRemoved = softRemoved
+	return out
+}
+
+func (r *ConsulResolver) softRemoveNodes(pool *CandidatePool) *CandidatePool {
+	if len(r.softRemoved) == 0 {
+		return pool
+	}
+	return r.scalePool(pool, ADJUST_SOFT_REMOVED, func(node *ServiceNode) float64 {
+		if r.softRemoved[nodeKey(node)] {
+			return r.softRemovalRate
+		}
+		return 1
+	})
+}
@@ -0,0 +1,63 @@
+package balancer
+
+import (
+	"time"
+)
+
+const (
+	SOURCE_HEALTH          = "health"
+	SOURCE_INSTANCE_FACTOR = "instanceFactor"
+	SOURCE_ZONE_CPU        = "zoneCPU"
+	SOURCE_CONFIG          = "config"
+)
+
+// SourceIntervals sets how often each data source is fetched. Config
+// covers the online lab and cpu threshold keys. Zero fetches the source on
+// every update cycle.
+type SourceIntervals struct {
+	Health         time.Duration `json:"health"`
+	InstanceFactor time.Duration `json:"instanceFactor"`
+	ZoneCPU        time.Duration `json:"zoneCPU"`
+	Config         time.Duration `json:"config"`
+}
+
+// SetSourceIntervals refreshes the data sources at their own intervals.
+// The update cycle still runs every interval, which should be the shortest
+// of them, and fetches a source on the first cycle after its interval has
+// elapsed; in between the last fetched value is used.
+func (r *ConsulResolver) SetSourceIntervals(intervals SourceIntervals) {
+	r.updateMutex.Lock()
+	r.sourceIntervals = intervals
+	r.updateMutex.Unlock()
+}
+
+func (r *ConsulResolver) sourceInterval(source string) time.Duration {
+	switch source {
+	case SOURCE_HEALTH:
+		return r.sourceIntervals.Health
+	case SOURCE_INSTANCE_FACTOR:
+		return r.sourceIntervals.InstanceFactor
+	case SOURCE_ZONE_CPU:
+		return r.sourceIntervals.ZoneCPU
+	case SOURCE_CONFIG:
+		return r.sourceIntervals.Config
+	}
+	return 0
+}
+
+// sourceDue reports whether source must be fetched in this cycle. It must
+// be called with r.updateMutex held.
+func (r *ConsulResolver) sourceDue(source string) bool {
+	fetched, ok := r.sourceFetched[source]
+	return !ok || time.Since(fetched) >= r.sourceInterval(source)
+}
+
+// fetched records a successful fetch of source. It must be called with
+// r.updateMutex held.
+func (r *ConsulResolver) fetched(source string) {
+	if r.sourceFetched == nil {
+		r.sourceFetched = make(map[string]time.Time)
+	}
+	r.sourceFetched[source] = time.Now()
+	r.reportSuccess(source)
+}
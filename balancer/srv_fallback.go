@@ -0,0 +1,101 @@
+package balancer
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const SRV_DOMAIN = "consul"
+
+// SetSRVFallback makes the resolver look the service up with Consul DNS
+// SRV queries, <service>.service.<domain>, whenever an update cycle fails
+// because the HTTP API is unavailable, and serve the nodes found with
+// uniform factors, so a basic node list keeps flowing through a second,
+// independent discovery path. server is the Consul DNS address, e.g.
+// "127.0.0.1:8600", or empty for the system resolver; domain defaults to
+// SRV_DOMAIN. Of the tags set with SetTags only the first is queried, the
+// DNS interface filters on one. The resolver reports Degraded meanwhile.
+func (r *ConsulResolver) SetSRVFallback(server, domain string) {
+	if domain == "" {
+		domain = SRV_DOMAIN
+	}
+	r.srvServer = server
+	r.srvDomain = domain
+}
+
+// apiUnavailable reports whether err means the Consul HTTP API could not
+// be reached, rather than a document being missing, invalid or denied. A
+// cycle skipped by the open update circuit counts as unavailable too.
+func apiUnavailable(err error) bool {
+	if err == ErrUpdateCircuitOpen {
+		return true
+	}
+	ue, ok := err.(*UpdateError)
+	if !ok {
+		return false
+	}
+	switch ue.Class {
+	case ERROR_TIMEOUT, ERROR_SERVER, ERROR_TRANSPORT:
+		return true
+	}
+	return false
+}
+
+// fallBackToSRV serves the nodes found over DNS after an update cycle
+// failed with cause. It reports whether it did.
+func (r *ConsulResolver) fallBackToSRV(cause error) bool {
+	if r.srvDomain == "" || !apiUnavailable(cause) {
+		return false
+	}
+	ctx, cancel := withTimeout(r.lifecycleContext(), r.timeout)
+	defer cancel()
+	nodes, err := r.lookupSRV(ctx)
+	if err != nil || len(nodes) == 0 {
+		r.logger.Warnf("service: %s, srv fallback found no node. err: %v", r.service, err)
+		return false
+	}
+	r.serveFallback(nodes)
+	r.logger.Warnf("service: %s, update failed, serve %d nodes found over dns. err: %s", r.service, len(nodes), cause.Error())
+	return true
+}
+
+func (r *ConsulResolver) lookupSRV(ctx context.Context) ([]*ServiceNode, error) {
+	resolver := net.DefaultResolver
+	if r.srvServer != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, r.srvServer)
+			},
+		}
+	}
+	name := r.service + ".service." + r.srvDomain
+	if len(r.tags) > 0 {
+		name = r.tags[0] + "." + name
+	}
+	_, srvs, err := resolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]*ServiceNode, 0, len(srvs))
+	for _, srv := range srvs {
+		host := strings.TrimSuffix(srv.Target, ".")
+		if addrs, err := resolver.LookupHost(ctx, host); err == nil && len(addrs) > 0 {
+			host = addrs[0]
+		}
+		port := int(srv.Port)
+		nodes = append(nodes, &ServiceNode{
+			InstanceID:    net.JoinHostPort(host, strconv.Itoa(port)),
+			Host:          host,
+			Port:          port,
+			Zone:          r.zone,
+			BalanceFactor: 1,
+			CurrentFactor: 1,
+			AdjustReason:  ADJUST_NONE,
+		})
+	}
+	return nodes, nil
+}
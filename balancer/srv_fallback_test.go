@@ -0,0 +1,113 @@
+package balancer_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// serveDNS answers SRV queries for as.service.consul with two nodes and A
+// queries for their targets on a local UDP port.
+func serveDNS() (*net.UDPConn, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			var req dnsmessage.Message
+			if req.Unpack(buf[:n]) != nil || len(req.Questions) == 0 {
+				continue
+			}
+			q := req.Questions[0]
+			res := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: req.ID, Response: true, Authoritative: true},
+				Questions: req.Questions,
+			}
+			header := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 0}
+			switch {
+			case q.Type == dnsmessage.TypeSRV && q.Name.String() == "as.service.consul.":
+				for i, target := range []string{"n1.node.dc1.consul.", "n2.node.dc1.consul."} {
+					h := header
+					h.Type = dnsmessage.TypeSRV
+					res.Answers = append(res.Answers, dnsmessage.Resource{Header: h, Body: &dnsmessage.SRVResource{
+						Priority: 1, Weight: 1, Port: uint16(8080 + i), Target: dnsmessage.MustNewName(target),
+					}})
+				}
+			case q.Type == dnsmessage.TypeA && q.Name.String() == "n1.node.dc1.consul.":
+				h := header
+				h.Type = dnsmessage.TypeA
+				res.Answers = append(res.Answers, dnsmessage.Resource{Header: h, Body: &dnsmessage.AResource{A: [4]byte{10, 2, 0, 1}}})
+			case q.Type == dnsmessage.TypeA && q.Name.String() == "n2.node.dc1.consul.":
+				h := header
+				h.Type = dnsmessage.TypeA
+				res.Answers = append(res.Answers, dnsmessage.Resource{Header: h, Body: &dnsmessage.AResource{A: [4]byte{10, 2, 0, 2}}})
+			default:
+				res.RCode = dnsmessage.RCodeNameError
+			}
+			if out, err := res.Pack(); err == nil {
+				conn.WriteToUDP(out, addr)
+			}
+		}
+	}()
+	return conn, nil
+}
+
+func TestSRVFallback(t *testing.T) {
+	Convey("Test SRVFallback", t, func() {
+		f, server := newFakeConsul()
+		defer server.Close()
+		dns, err := serveDNS()
+		So(err, ShouldBeNil)
+		defer dns.Close()
+		r := newFakeResolver(server.URL)
+		r.SetSRVFallback(dns.LocalAddr().String(), "")
+
+		Convey("Given the HTTP API down, the nodes found over DNS are served", func() {
+			f.mutex.Lock()
+			f.failing["health"] = 1000
+			f.mutex.Unlock()
+			So(r.Start(), ShouldBeNil)
+			defer r.Stop()
+			So(r.Degraded(), ShouldBeTrue)
+			hosts := make(map[string]int)
+			for i := 0; i < 10; i++ {
+				node := r.SelectNode()
+				So(node, ShouldNotBeNil)
+				hosts[node.Host] = node.Port
+			}
+			So(hosts, ShouldResemble, map[string]int{"10.2.0.1": 8080, "10.2.0.2": 8081})
+		})
+		Convey("Given an invalid document, DNS is not used until the update circuit opens", func() {
+			r.SetUpdateCircuit(1, time.Minute)
+			So(r.Start(), ShouldBeNil)
+			defer r.Stop()
+			f.mutex.Lock()
+			f.kv["clb/lab"] = "invalid"
+			f.mutex.Unlock()
+			So(r.Update(), ShouldNotBeNil)
+			So(r.Degraded(), ShouldBeFalse)
+			So(r.Update(), ShouldEqual, balancer.ErrUpdateCircuitOpen)
+			So(r.Degraded(), ShouldBeTrue)
+			So(r.SelectNode().Host, ShouldStartWith, "10.2.0.")
+		})
+		Convey("Given Consul unreachable, the nodes found over DNS are served", func() {
+			So(r.Start(), ShouldBeNil)
+			defer r.Stop()
+			server.Close()
+			err := r.Update()
+			So(err, ShouldNotBeNil)
+			So(r.ErrorCounts()[balancer.ERROR_TRANSPORT], ShouldEqual, 1)
+			So(r.Degraded(), ShouldBeTrue)
+		})
+	})
+}
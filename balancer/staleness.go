@@ -0,0 +1,72 @@
+package balancer
+
+import (
+	"fmt"
+	"time"
+)
+
+// StaleError is returned when the serving pool is older than the
+// configured MaxStaleness and a synchronous refresh failed.
+type StaleError struct {
+	Age          time.Duration
+	MaxStaleness time.Duration
+	Err          error
+}
+
+func (e *StaleError) Error() string {
+	return fmt.Sprintf("candidate pool is %s old, max staleness %s: %v", e.Age, e.MaxStaleness, e.Err)
+}
+
+func (e *StaleError) Unwrap() error {
+	return e.Err
+}
+
+// MaxStaleness bounds the age of the data selections are made with. When
+// the last successful update is older than d, selecting first runs an
+// update synchronously; if the data is still too old, SelectNode returns
+// nil and SelectNodeFresh a *StaleError. At most one synchronous update is
+// tried per update interval, callers in between fail right away instead
+// of each waiting on an unreachable Consul. Zero disables the check.
+func (r *ConsulResolver) MaxStaleness(d time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.maxStaleness = d
+}
+
+// SelectNodeFresh is SelectNode honoring MaxStaleness with an error.
+func (r *ConsulResolver) SelectNodeFresh() (*ServiceNode, error) {
+	if err := r.ensureFresh(); err != nil {
+		return nil, err
+	}
+	node, _ := r.selectNode(nil, nil)
+	return r.observe(node), nil
+}
+
+func (r *ConsulResolver) staleness() (time.Duration, time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return time.Since(r.lastUpdate), r.maxStaleness
+}
+
+func (r *ConsulResolver) ensureFresh() error {
+	age, maxStaleness := r.staleness()
+	if maxStaleness <= 0 || age <= maxStaleness {
+		return nil
+	}
+	r.refreshMutex.Lock()
+	defer r.refreshMutex.Unlock()
+	// another caller may have refreshed meanwhile
+	if age, _ = r.staleness(); age <= maxStaleness {
+		return nil
+	}
+	if time.Since(r.refreshTried) < r.loopInterval() {
+		return &StaleError{Age: age, MaxStaleness: maxStaleness, Err: r.refreshErr}
+	}
+	r.refreshTried = time.Now()
+	r.refreshErr = r.updateAll()
+	if age, _ = r.staleness(); age <= maxStaleness {
+		return nil
+	}
+	r.logger.Warnf("candidate pool is %s old, max staleness %s", age, maxStaleness)
+	return &StaleError{Age: age, MaxStaleness: maxStaleness, Err: r.refreshErr}
+}
@@ -0,0 +1,117 @@
+package balancer
+
+import (
+	"sync"
+	"time"
+)
+
+// ResolverPair serves selections from a primary resolver and keeps a warm
+// standby, usually built against a secondary Consul address, updating in
+// the background. When the active resolver fails failThreshold update
+// cycles in a row and the other one is healthy, the other one takes over.
+type ResolverPair struct {
+	primary       *ConsulResolver
+	standby       *ConsulResolver
+	failThreshold int
+	mutex         sync.RWMutex
+	active        *ConsulResolver
+	// lifecycleMutex serializes Start and Stop; done is closed to stop the
+	// monitor and is nil while it is not running.
+	lifecycleMutex sync.Mutex
+	done           chan struct{}
+}
+
+func NewResolverPair(primary, standby *ConsulResolver, failThreshold int) *ResolverPair {
+	return &ResolverPair{
+		primary:       primary,
+		standby:       standby,
+		failThreshold: failThreshold,
+		active:        primary,
+	}
+}
+
+// Start starts both resolvers. The pair starts if either of them does.
+// Starting a running pair does nothing.
+func (p *ResolverPair) Start() error {
+	p.lifecycleMutex.Lock()
+	defer p.lifecycleMutex.Unlock()
+	if p.done != nil {
+		return nil
+	}
+	perr := p.primary.Start()
+	serr := p.standby.Start()
+	if perr != nil && serr != nil {
+		return perr
+	}
+	if perr != nil {
+		p.mutex.Lock()
+		p.active = p.standby
+		p.mutex.Unlock()
+		p.standby.logger.Warnf("primary resolver failed to start, use standby. err: %s", perr.Error())
+	}
+
+	done := make(chan struct{})
+	p.done = done
+	go func() {
+		tk := time.NewTicker(p.primary.interval)
+		defer tk.Stop()
+		for {
+			select {
+			case <-tk.C:
+				p.check()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops the monitor and both resolvers. Stopping a pair that is not
+// running, e.g. after a failed Start, only stops the running resolvers.
+func (p *ResolverPair) Stop() {
+	p.lifecycleMutex.Lock()
+	defer p.lifecycleMutex.Unlock()
+	if p.done != nil {
+		close(p.done)
+		p.done = nil
+	}
+	if p.primary.isRunning() {
+		p.primary.Stop()
+	}
+	if p.standby.isRunning() {
+		p.standby.Stop()
+	}
+}
+
+func (p *ResolverPair) check() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	other := p.primary
+	if p.active == p.primary {
+		other = p.standby
+	}
+	if p.active.ConsecutiveFailures() >= p.failThreshold && other.ConsecutiveFailures() == 0 {
+		other.logger.Warnf("resolver %s failed %d times, promote %s", p.active.address, p.active.ConsecutiveFailures(), other.address)
+		p.active = other
+	}
+}
+
+// Active returns the resolver currently serving selections.
+func (p *ResolverPair) Active() *ConsulResolver {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.active
+}
+
+func (p *ResolverPair) SelectNode() *ServiceNode {
+	return p.Active().SelectNode()
+}
+
+// ConsecutiveFailures returns the number of update cycles that failed in
+// a row since the last successful one.
+func (r *ConsulResolver) ConsecutiveFailures() int {
+	r.errorMutex.Lock()
+	defer r.errorMutex.Unlock()
+	return r.consecutiveFailures
+}
@@ -0,0 +1,209 @@
+package balancer
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// ErrStateNotFound is returned by a StateStore without state under key.
+var ErrStateNotFound = errors.New("state not found")
+
+// StateStore persists resolver state across restarts.
+type StateStore interface {
+	Load(key string) ([]byte, error)
+	Save(key string, data []byte) error
+}
+
+// FileStore keeps every key as a file in a directory.
+type FileStore struct {
+	Dir string
+}
+
+func (s FileStore) Load(key string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.Dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil, ErrStateNotFound
+	}
+	return data, err
+}
+
+// Save writes to a temporary file and renames it, so a crash never leaves
+// a truncated state behind.
+func (s FileStore) Save(key string, data []byte) error {
+	path := filepath.Join(s.Dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ConsulStore keeps every key under Prefix in Consul KV.
+type ConsulStore struct {
+	Client *api.Client
+	Prefix string
+}
+
+func (s ConsulStore) Load(key string) ([]byte, error) {
+	pair, _, err := s.Client.KV().Get(s.Prefix+key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if pair == nil {
+		return nil, ErrStateNotFound
+	}
+	return pair.Value, nil
+}
+
+func (s ConsulStore) Save(key string, data []byte) error {
+	_, err := s.Client.KV().Put(&api.KVPair{Key: s.Prefix + key, Value: data}, nil)
+	return err
+}
+
+// StoreFuncs adapts a pair of functions to StateStore, e.g. for Redis:
+//
+//	balancer.StoreFuncs{
+//		LoadFunc: func(key string) ([]byte, error) {
+//			data, err := rdb.Get(ctx, key).Bytes()
+//			if err == redis.Nil {
+//				return nil, balancer.ErrStateNotFound
+//			}
+//			return data, err
+//		},
+//		SaveFunc: func(key string, data []byte) error {
+//			return rdb.Set(ctx, key, data, 0).Err()
+//		},
+//	}
+type StoreFuncs struct {
+	LoadFunc func(key string) ([]byte, error)
+	SaveFunc func(key string, data []byte) error
+}
+
+func (s StoreFuncs) Load(key string) ([]byte, error) {
+	return s.LoadFunc(key)
+}
+
+func (s StoreFuncs) Save(key string, data []byte) error {
+	return s.SaveFunc(key, data)
+}
+
+// ResolverState is the state a resolver saves with SetStateStore.
+type ResolverState struct {
+	Updated   int64              `json:"updated"`
+	Service   string             `json:"service"`
+	Zone      string             `json:"zone"`
+	Factors   map[string]float64 `json:"factors"`
+	Pool      []ServiceNode      `json:"pool,omitempty"`
+	Documents *StateDocuments    `json:"documents,omitempty"`
+}
+
+// StateDocuments are the KV documents in effect when the state was saved.
+type StateDocuments struct {
+	CPUThreshold    float64            `json:"cpuThreshold"`
+	ZoneCPU         map[string]float64 `json:"zoneCPU"`
+	InstanceFactors map[string]float64 `json:"instanceFactors"`
+	OnlineLab       *OnlineLab         `json:"onlineLab"`
+}
+
+// SetStateStore saves the learned balance factors, the candidate pool and
+// the KV documents to store under key at most once every saveInterval, and
+// Start restores them, so a restarted client resumes from what it learned
+// instead of the registered factors. When the first update cycle fails,
+// e.g. because Consul is unreachable at process start, Start serves the
+// saved pool and documents instead and Degraded reports true until an
+// update cycle succeeds. A FileStore keeps such a snapshot on local disk.
+// Saves are asynchronous and never fail an update.
+func (r *ConsulResolver) SetStateStore(store StateStore, key string, saveInterval time.Duration) {
+	r.stateStore = store
+	r.stateKey = key
+	r.stateSaveInterval = saveInterval
+}
+
+// LoadState restores the balance factors saved in the state store. It is
+// called by Start; ErrStateNotFound means nothing was saved yet.
+func (r *ConsulResolver) LoadState() error {
+	if r.stateStore == nil {
+		return nil
+	}
+	data, err := r.stateStore.Load(r.stateKey)
+	if err != nil {
+		return err
+	}
+	var state ResolverState
+	if err := r.serializerFor(r.stateKey).Unmarshal(data, &state); err != nil {
+		return err
+	}
+	r.updateMutex.Lock()
+	defer r.updateMutex.Unlock()
+	factors, unlock := r.lockFactorCache()
+	for k, v := range state.Factors {
+		factors[k] = v
+	}
+	unlock()
+	r.savedPool = state.Pool
+	if d := state.Documents; d != nil && r.onlineLab == nil && r.readsDocuments() {
+		r.cpuThreshold = d.CPUThreshold
+		r.zoneCPUMap = d.ZoneCPU
+		r.instanceFactorMap = d.InstanceFactors
+		r.onlineLab = d.OnlineLab
+	}
+	r.logger.Infof("service: %s, restored %d factors saved at %s", r.service, len(state.Factors), time.Unix(state.Updated, 0).Format(time.RFC3339))
+	return nil
+}
+
+func (r *ConsulResolver) loadState() {
+	if err := r.LoadState(); err != nil && err != ErrStateNotFound {
+		r.logger.Warnf("load state %s failed. err: %s", r.stateKey, err.Error())
+	}
+}
+
+func (r *ConsulResolver) saveState(pool *CandidatePool) {
+	if r.stateStore == nil {
+		return
+	}
+	now := time.Now()
+	if now.Sub(r.lastStateSave) < r.stateSaveInterval {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&r.stateSaving, 0, 1) {
+		return
+	}
+	r.lastStateSave = now
+	state := ResolverState{
+		Updated: now.Unix(),
+		Service: r.service,
+		Zone:    r.zone,
+		Factors: r.factorCacheSnapshot(),
+		Pool:    make([]ServiceNode, len(pool.Nodes)),
+	}
+	for i, node := range pool.Nodes {
+		state.Pool[i] = *node
+	}
+	if r.onlineLab != nil {
+		state.Documents = &StateDocuments{
+			CPUThreshold:    r.cpuThreshold,
+			ZoneCPU:         r.zoneCPUMap,
+			InstanceFactors: r.instanceFactorMap,
+			OnlineLab:       r.onlineLab,
+		}
+	}
+	go func() {
+		defer atomic.StoreInt32(&r.stateSaving, 0)
+		data, err := r.serializerFor(r.stateKey).Marshal(&state)
+		if err == nil {
+			err = r.stateStore.Save(r.stateKey, data)
+		}
+		if err != nil {
+			r.logger.Warnf("save state %s failed. err: %s", r.stateKey, err.Error())
+		}
+	}()
+}
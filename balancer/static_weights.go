@@ -0,0 +1,51 @@
+package balancer
+
+// SetStaticWeights turns the resolver into zone aware weighted round robin
+// over the registered balanceFactors. No KV document is read and nothing
+// is learned: the local zone nodes are the candidates, or every node when
+// the local zone has none. The serving-time adjustments such as drain,
+// soft removal, warmup and feedback still apply. The online lab defaults
+// to DefaultOnlineLab and can be replaced with SetOnlineLab.
+func (r *ConsulResolver) SetStaticWeights(static bool) {
+	r.updateMutex.Lock()
+	defer r.updateMutex.Unlock()
+	r.staticWeights = static
+	if static && r.onlineLab == nil {
+		r.onlineLab = DefaultOnlineLab()
+	}
+}
+
+// SetOnlineLab sets the online lab of a resolver that does not read it
+// from Consul, i.e. a SimpleResolver or one with static weights.
+func (r *ConsulResolver) SetOnlineLab(onlineLab *OnlineLab) {
+	r.updateMutex.Lock()
+	r.onlineLab = onlineLab
+	r.updateMutex.Unlock()
+}
+
+// readsDocuments reports whether the update cycle reads the KV documents.
+func (r *ConsulResolver) readsDocuments() bool {
+	return !r.static && !r.staticWeights
+}
+
+func (r *ConsulResolver) staticPool(serviceZones []*ServiceZone) *CandidatePool {
+	pool := new(CandidatePool)
+	add := func(zone *ServiceZone) {
+		for _, node := range zone.Nodes {
+			node.CurrentFactor = node.BalanceFactor
+			node.AdjustReason = ADJUST_NONE
+			pool.Nodes = append(pool.Nodes, node)
+			pool.Factors = append(pool.Factors, node.BalanceFactor)
+			pool.Weights = append(pool.Weights, 0)
+			pool.FactorSum += node.BalanceFactor
+		}
+	}
+	if r.localZone != nil && len(r.localZone.Nodes) > 0 {
+		add(r.localZone)
+		return pool
+	}
+	for _, zone := range serviceZones {
+		add(zone)
+	}
+	return pool
+}
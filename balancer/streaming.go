@@ -0,0 +1,89 @@
+package balancer
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const STREAMING_WAIT_TIME = 5 * time.Minute
+
+// SetStreaming keeps a health query open against Consul and rebuilds the
+// pool as soon as the service changes, instead of polling it every
+// interval. On agents with use_streaming_backend enabled these queries are
+// served from the streaming backend, which only ships incremental events.
+// It has no effect when resolving from a k8s service key.
+func (r *ConsulResolver) SetStreaming(streaming bool) {
+	r.streaming = streaming
+}
+
+func (r *ConsulResolver) watchService(ctx context.Context) {
+	var index uint64
+	for {
+		qm := r.queryOptions(&api.QueryOptions{WaitIndex: index, WaitTime: STREAMING_WAIT_TIME})
+		res, meta, err := r.healthService(qm.WithContext(ctx))
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			r.logger.Warnf("watch service %s failed, poll it until the watch recovers. err: %s", r.service, err.Error())
+			r.updateMutex.Lock()
+			r.streamNodes = nil
+			r.updateMutex.Unlock()
+			r.reportError(err)
+			select {
+			case <-time.After(r.interval):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		r.updateMutex.Lock()
+		r.streamUpdate = time.Now()
+		r.updateMutex.Unlock()
+		if meta.LastIndex < index {
+			index = 0
+			continue
+		}
+		if meta.LastIndex == index && r.hasStreamNodes() {
+			continue
+		}
+		index = meta.LastIndex
+
+		serviceNodes := make([]ServiceNode, len(res))
+		for i, entry := range res {
+			serviceNodes[i] = newServiceNode(entry)
+		}
+		serviceNodes, _ = r.failover(ctx, serviceNodes)
+		r.updateMutex.Lock()
+		r.streamNodes = serviceNodes
+		if err := r.updateServiceZone(); err != nil {
+			r.logger.Warnf("update service zone failed. err: %s", err.Error())
+		} else {
+			r.updateCandidatePool()
+		}
+		r.updateMutex.Unlock()
+		r.runHooks()
+		r.logger.Debugf("service %s changed, index: %d, nodes: %d", r.service, index, len(serviceNodes))
+	}
+}
+
+func (r *ConsulResolver) hasStreamNodes() bool {
+	r.updateMutex.Lock()
+	defer r.updateMutex.Unlock()
+	return r.streamNodes != nil
+}
+
+// streamedNodes returns the nodes of the service watch. The watch answers
+// at least every STREAMING_WAIT_TIME; once it has not for an interval
+// longer, its nodes are dropped and the update cycle polls the service, so
+// a stuck watch can't keep stale nodes serving as fresh. It must be called
+// with r.updateMutex held.
+func (r *ConsulResolver) streamedNodes() []ServiceNode {
+	if r.streaming && r.streamNodes != nil && time.Since(r.streamUpdate) > STREAMING_WAIT_TIME+r.interval {
+		r.logger.Warnf("watch service %s silent since %s, poll it", r.service, r.streamUpdate.Format(time.RFC3339))
+		r.streamNodes = nil
+	}
+	return r.streamNodes
+}
@@ -0,0 +1,103 @@
+package balancer
+
+import (
+	"strconv"
+)
+
+const SUBSCRIBE_BUFFER_SIZE = 16
+
+// PoolDelta describes a change of the serving candidate pool. Nodes carry
+// their new CurrentFactor. When Reset is set, Added holds the complete
+// pool and the subscriber must discard its previous state. Epoch is the
+// epoch of the new pool.
+type PoolDelta struct {
+	Epoch   uint64
+	Reset   bool
+	Added   []*ServiceNode
+	Removed []*ServiceNode
+	Changed []*ServiceNode
+}
+
+type subscriber struct {
+	ch     chan *PoolDelta
+	resync bool
+}
+
+// Subscribe returns a channel of pool deltas, starting with a Reset delta
+// of the current pool, and a func to cancel the subscription. A subscriber
+// that falls behind receives a Reset delta once it catches up.
+func (r *ConsulResolver) Subscribe() (<-chan *PoolDelta, func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	s := &subscriber{ch: make(chan *PoolDelta, SUBSCRIBE_BUFFER_SIZE)}
+	s.ch <- diffPool(nil, r.candidatePool, true)
+	r.subscribers = append(r.subscribers, s)
+	return s.ch, func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		for i, v := range r.subscribers {
+			if v == s {
+				r.subscribers = append(r.subscribers[:i], r.subscribers[i+1:]...)
+				close(s.ch)
+				return
+			}
+		}
+	}
+}
+
+// notifySubscribers must be called with r.mutex held.
+func (r *ConsulResolver) notifySubscribers(prev, next *CandidatePool) {
+	if len(r.subscribers) == 0 {
+		return
+	}
+	delta := diffPool(prev, next, false)
+	for _, s := range r.subscribers {
+		d := delta
+		if s.resync {
+			d = diffPool(nil, next, true)
+		}
+		select {
+		case s.ch <- d:
+			s.resync = false
+		default:
+			s.resync = true
+			r.logger.Warnf("pool subscriber falls behind, will resync")
+		}
+	}
+}
+
+func nodeKey(node *ServiceNode) string {
+	if node.InstanceID != "" {
+		return node.InstanceID
+	}
+	return node.Host + ":" + strconv.Itoa(node.Port)
+}
+
+func diffPool(prev, next *CandidatePool, reset bool) *PoolDelta {
+	delta := &PoolDelta{Reset: reset}
+	oldNodes := make(map[string]*ServiceNode)
+	if prev != nil {
+		for _, node := range prev.Nodes {
+			oldNodes[nodeKey(node)] = node
+		}
+	}
+	if next != nil {
+		delta.Epoch = next.Epoch
+		for _, node := range next.Nodes {
+			key := nodeKey(node)
+			o, ok := oldNodes[key]
+			if !ok {
+				delta.Added = append(delta.Added, node)
+				continue
+			}
+			delete(oldNodes, key)
+			if o.CurrentFactor != node.CurrentFactor {
+				delta.Changed = append(delta.Changed, node)
+			}
+		}
+	}
+	for _, node := range oldNodes {
+		delta.Removed = append(delta.Removed, node)
+	}
+	return delta
+}
@@ -0,0 +1,88 @@
+package balancer
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const INSTRUMENTATION_NAME = "github.com/mae-pax/consul-loadbalancer/balancer"
+
+// SetTracerProvider records a span for every updateAll and, as its
+// children, for every KV and health fetch.
+func (r *ConsulResolver) SetTracerProvider(tp trace.TracerProvider) {
+	r.tracer = tp.Tracer(INSTRUMENTATION_NAME)
+}
+
+// SetMeterProvider records the SelectNode latency histogram
+// consul_lb.select.duration and observes the consul_lb.cross_zone.ratio and
+// consul_lb.pool.size gauges.
+func (r *ConsulResolver) SetMeterProvider(mp metric.MeterProvider) error {
+	meter := mp.Meter(INSTRUMENTATION_NAME)
+	selectDuration, err := meter.Float64Histogram("consul_lb.select.duration",
+		metric.WithUnit("s"), metric.WithDescription("SelectNode latency"))
+	if err != nil {
+		return err
+	}
+	crossZoneRatio, err := meter.Float64ObservableGauge("consul_lb.cross_zone.ratio",
+		metric.WithDescription("share of selections routed to another zone"))
+	if err != nil {
+		return err
+	}
+	poolSize, err := meter.Int64ObservableGauge("consul_lb.pool.size",
+		metric.WithDescription("candidate pool size"))
+	if err != nil {
+		return err
+	}
+	attrs := metric.WithAttributes(attribute.String("service", r.service))
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		if r.metric == nil {
+			return nil
+		}
+		if r.metric.selectNum > 0 {
+			o.ObserveFloat64(crossZoneRatio, float64(r.metric.crossZoneNum)/float64(r.metric.selectNum), attrs)
+		}
+		o.ObserveInt64(poolSize, int64(r.metric.candidatePoolSize), attrs)
+		return nil
+	}, crossZoneRatio, poolSize)
+	if err != nil {
+		return err
+	}
+	r.selectDuration = selectDuration
+	return nil
+}
+
+func (r *ConsulResolver) recordSelect(start time.Time) {
+	r.selectDuration.Record(context.Background(), time.Since(start).Seconds(),
+		metric.WithAttributes(attribute.String("service", r.service)))
+}
+
+// startSpan returns a func ending the span with err. Without a tracer both
+// are no-ops.
+func (r *ConsulResolver) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, func(error)) {
+	if r.tracer == nil {
+		return ctx, func(error) {}
+	}
+	ctx, span := r.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// updateContext is the context of the running updateAll span.
+func (r *ConsulResolver) updateContext() context.Context {
+	if r.updateCtx == nil {
+		return context.Background()
+	}
+	return r.updateCtx
+}
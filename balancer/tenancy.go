@@ -0,0 +1,69 @@
+package balancer
+
+import (
+	"net/http"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// SetNamespace makes every health query and KV read or write of the
+// resolver target the Consul Enterprise namespace instead of the default
+// one of the client. Clients used outside the resolver, e.g. by a
+// ConsulStore or a ResolverManager, take it from api.Config.Namespace.
+func (r *ConsulResolver) SetNamespace(namespace string) {
+	r.namespace = namespace
+}
+
+func (r *ConsulResolver) queryOptions(q *api.QueryOptions) *api.QueryOptions {
+	if r.namespace != "" {
+		q.Namespace = r.namespace
+	}
+	return q
+}
+
+func (r *ConsulResolver) writeOptions(w *api.WriteOptions) *api.WriteOptions {
+	if r.namespace != "" {
+		w.Namespace = r.namespace
+	}
+	return w
+}
+
+// PartitionConfig returns a copy of config whose requests target the
+// Consul Enterprise admin partition, for NewConsulResolverWithConfig. The
+// consul api this module is built against has no partition query option,
+// so the partition is added to every request by the HTTP transport.
+func PartitionConfig(config *api.Config, partition string) (*api.Config, error) {
+	c := *config
+	if c.HttpClient == nil {
+		transport := c.Transport
+		if transport == nil {
+			transport = api.DefaultConfig().Transport
+		}
+		client, err := api.NewHttpClient(transport, c.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		c.HttpClient = client
+	}
+	client := *c.HttpClient
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	client.Transport = &partitionTransport{next: next, partition: partition}
+	c.HttpClient = &client
+	return &c, nil
+}
+
+type partitionTransport struct {
+	next      http.RoundTripper
+	partition string
+}
+
+func (t *partitionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	q := req.URL.Query()
+	q.Set("partition", t.partition)
+	req.URL.RawQuery = q.Encode()
+	return t.next.RoundTrip(req)
+}
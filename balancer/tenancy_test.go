@@ -0,0 +1,38 @@
+package balancer_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	"github.com/mae-pax/consul-loadbalancer/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTenancy(t *testing.T) {
+	Convey("Test Tenancy", t, func() {
+		f, server := newFakeConsul()
+		defer server.Close()
+
+		Convey("Given a namespace and a partition, every request carries both", func() {
+			config := api.DefaultConfig()
+			config.Address = strings.TrimPrefix(server.URL, "http://")
+			config, err := balancer.PartitionConfig(config, "part-a")
+			So(err, ShouldBeNil)
+			r, err := balancer.NewConsulResolverWithConfig("", config, "as", "clb/cpu", "clb/zone", "clb/factor", "clb/lab", time.Minute, time.Second)
+			So(err, ShouldBeNil)
+			r.SetLogger(util.NewRingLogger(100))
+			r.SetZone("a")
+			r.SetNamespace("team-a")
+			So(r.Start(), ShouldBeNil)
+			defer r.Stop()
+			So(len(r.CandidateNodes()), ShouldEqual, 2)
+			f.mutex.Lock()
+			defer f.mutex.Unlock()
+			So(f.params.Get("ns"), ShouldEqual, "team-a")
+			So(f.params.Get("partition"), ShouldEqual, "part-a")
+		})
+	})
+}
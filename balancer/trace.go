@@ -0,0 +1,129 @@
+package balancer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mae-pax/consul-loadbalancer/util"
+)
+
+const TRACE_BUFFER_SIZE = 1024
+
+type traceIDKey struct{}
+
+// WithTraceID returns a context carrying a request ID for SelectNodeContext.
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// SelectTrace records why a traced request went to a node.
+type SelectTrace struct {
+	TraceID    string
+	Time       time.Time
+	InstanceID string
+	Zone       string
+	CrossZone  bool
+	Pinned     bool
+	Epoch      uint64
+	Factors    map[string]float64
+}
+
+type traceBuffer struct {
+	mutex  sync.Mutex
+	traces []*SelectTrace
+	index  map[string]*SelectTrace
+	next   int
+}
+
+func newTraceBuffer(size int) *traceBuffer {
+	return &traceBuffer{traces: make([]*SelectTrace, size), index: make(map[string]*SelectTrace)}
+}
+
+func (b *traceBuffer) add(t *SelectTrace) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	// a trace ID recorded again points at its latest trace, keep it
+	if old := b.traces[b.next]; old != nil && b.index[old.TraceID] == old {
+		delete(b.index, old.TraceID)
+	}
+	b.traces[b.next] = t
+	b.index[t.TraceID] = t
+	b.next = (b.next + 1) % len(b.traces)
+}
+
+func (b *traceBuffer) get(traceID string) (*SelectTrace, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	t, ok := b.index[traceID]
+	return t, ok
+}
+
+// SetTraceSampleRate records a SelectTrace for the given fraction, in
+// [0, 1], of SelectNodeContext calls carrying a trace ID.
+func (r *ConsulResolver) SetTraceSampleRate(rate float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.traceSampleRate = rate
+	if rate > 0 && r.traces == nil {
+		r.traces = newTraceBuffer(TRACE_BUFFER_SIZE)
+	}
+}
+
+// traceSettings returns the trace buffer and the sample rate, under r.mutex.
+func (r *ConsulResolver) traceSettings() (*traceBuffer, float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.traces, r.traceSampleRate
+}
+
+// SelectNodeContext is SelectNode with the trace ID from ctx attached to a
+// sampled SelectTrace, and the selection counted for the caller from ctx.
+func (r *ConsulResolver) SelectNodeContext(ctx context.Context) *ServiceNode {
+	if caller, ok := ctx.Value(callerKey{}).(string); ok && caller != "" {
+		node := r.selectNodeContext(ctx)
+		r.countCaller(caller, node)
+		return node
+	}
+	return r.selectNodeContext(ctx)
+}
+
+func (r *ConsulResolver) selectNodeContext(ctx context.Context) *ServiceNode {
+	traceID, _ := ctx.Value(traceIDKey{}).(string)
+	if traceID == "" {
+		return r.SelectNode()
+	}
+	traces, rate := r.traceSettings()
+	if traces == nil || util.FloatPseudoRandom() >= rate {
+		return r.SelectNode()
+	}
+	trace := &SelectTrace{TraceID: traceID, Time: time.Now()}
+	node, _ := r.selectNode(trace, nil)
+	node = r.observe(node)
+	if node != nil {
+		trace.InstanceID = node.InstanceID
+		trace.Zone = node.Zone
+		trace.CrossZone = node.Zone != r.zone
+	}
+	traces.add(trace)
+	return node
+}
+
+// Trace returns the recorded SelectTrace for traceID, if it was sampled
+// and is still buffered.
+func (r *ConsulResolver) Trace(traceID string) (*SelectTrace, bool) {
+	traces, _ := r.traceSettings()
+	if traces == nil {
+		return nil, false
+	}
+	return traces.get(traceID)
+}
+
+// fill copies the factors considered from pool, under r.mutex.
+func (t *SelectTrace) fill(pool *CandidatePool) {
+	t.Epoch = pool.Epoch
+	t.Factors = make(map[string]float64, len(pool.Nodes))
+	for i, node := range pool.Nodes {
+		t.Factors[node.InstanceID] = pool.Factors[i]
+	}
+}
@@ -0,0 +1,47 @@
+package balancer_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSelectTrace(t *testing.T) {
+	Convey("Test SelectTrace", t, func() {
+		r, err := balancer.NewSimpleResolver("a", testNodes(), nil, 0)
+		So(err, ShouldBeNil)
+		r.SetTraceSampleRate(1)
+
+		Convey("Given a trace ID recorded twice, evicting the first keeps the second", func() {
+			ctx := balancer.WithTraceID(context.Background(), "x")
+			r.SelectNodeContext(ctx)
+			r.SelectNodeContext(ctx)
+			for i := 0; i < balancer.TRACE_BUFFER_SIZE-1; i++ {
+				r.SelectNodeContext(balancer.WithTraceID(context.Background(), strconv.Itoa(i)))
+			}
+			trace, ok := r.Trace("x")
+			So(ok, ShouldBeTrue)
+			So(trace.TraceID, ShouldEqual, "x")
+			_, ok = r.Trace("0")
+			So(ok, ShouldBeTrue)
+		})
+		Convey("Given the sample rate changed while selecting, the traces stay consistent", func() {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 100; i++ {
+					r.SetTraceSampleRate(float64(i%2) / 2)
+				}
+			}()
+			ctx := balancer.WithTraceID(context.Background(), "y")
+			for i := 0; i < 100; i++ {
+				So(r.SelectNodeContext(ctx), ShouldNotBeNil)
+				r.Trace("y")
+			}
+			<-done
+		})
+	})
+}
@@ -0,0 +1,83 @@
+package balancer
+
+// floorShares raises the weights whose share of the total is below floor
+// to exactly floor, keeping the proportions of the others. It returns
+// weights unchanged when the floor cannot be met by every weight.
+func floorShares(weights []float64, floor float64) []float64 {
+	if floor <= 0 || floor*float64(len(weights)) >= 1 {
+		return weights
+	}
+	floored := make([]bool, len(weights))
+	var total float64
+	for n, changed := 0, true; changed; {
+		changed = false
+		var rest float64
+		for i, w := range weights {
+			if !floored[i] {
+				rest += w
+			}
+		}
+		total = rest / (1 - float64(n)*floor)
+		for i, w := range weights {
+			if !floored[i] && w < floor*total {
+				floored[i] = true
+				n++
+				changed = true
+			}
+		}
+	}
+	res := make([]float64, len(weights))
+	for i, w := range weights {
+		res[i] = w
+		if floored[i] {
+			res[i] = floor * total
+		}
+	}
+	return res
+}
+
+// applyTrafficFloor raises zones below OnlineLab.MinZoneShare and then
+// nodes below OnlineLab.MinNodeShare of the pool factor sum, so down
+// weighted instances keep getting enough traffic to be learned again.
+func (r *ConsulResolver) applyTrafficFloor(pool *CandidatePool) *CandidatePool {
+	if r.onlineLab == nil || len(pool.Nodes) == 0 {
+		return pool
+	}
+	if floor := r.onlineLab.MinZoneShare; floor > 0 {
+		var zones []string
+		var sums []float64
+		index := make(map[string]int)
+		for i, node := range pool.Nodes {
+			z, ok := index[node.Zone]
+			if !ok {
+				z = len(zones)
+				index[node.Zone] = z
+				zones = append(zones, node.Zone)
+				sums = append(sums, 0)
+			}
+			sums[z] += pool.Factors[i]
+		}
+		floored := floorShares(sums, floor)
+		pool = r.scalePool(pool, ADJUST_FLOOR, func(node *ServiceNode) float64 {
+			z := index[node.Zone]
+			if sums[z] <= 0 {
+				return 1
+			}
+			return floored[z] / sums[z]
+		})
+	}
+	if floor := r.onlineLab.MinNodeShare; floor > 0 {
+		floored := floorShares(pool.Factors, floor)
+		scale := make(map[*ServiceNode]float64, len(pool.Nodes))
+		for i, node := range pool.Nodes {
+			scale[node] = 1
+			if pool.Factors[i] > 0 {
+				scale[node] = floored[i] / pool.Factors[i]
+			}
+		}
+		pool = r.scalePool(pool, ADJUST_FLOOR, func(node *ServiceNode) float64 {
+			return scale[node]
+		})
+	}
+	return pool
+}
@@ -0,0 +1,138 @@
+package balancer
+
+import (
+	"errors"
+	"time"
+)
+
+// STAGE_UPDATE is the stage of update errors not tied to a data source.
+const STAGE_UPDATE = "update"
+
+// SourceStatus is the state of one data source of the update cycle.
+type SourceStatus struct {
+	LastSuccess   time.Time
+	LastError     error
+	LastErrorTime time.Time
+}
+
+// HealthStatus is the state of the data the resolver serves from.
+// Healthy is false, with Reason set, when the resolver is not running,
+// has no candidate, serves fallback nodes or has not updated for longer
+// than the readiness max age.
+type HealthStatus struct {
+	Healthy    bool
+	Reason     string
+	LastUpdate time.Time
+	Age        time.Duration
+	Degraded   bool
+	// Sources are keyed by stage, see OnUpdateError.
+	Sources map[string]SourceStatus
+}
+
+// OnUpdateError calls fn with every failed read of the update cycle,
+// including those partial updates keep serving through. stage is one of
+// the SOURCE_* constants, the KV key of the other documents, or
+// STAGE_UPDATE. fn runs on the updating goroutine and must not block.
+func (r *ConsulResolver) OnUpdateError(fn func(stage string, err error)) {
+	r.errorMutex.Lock()
+	defer r.errorMutex.Unlock()
+	r.errorHooks = append(r.errorHooks, fn)
+}
+
+// LastUpdate returns the time of the last successful update cycle.
+func (r *ConsulResolver) LastUpdate() time.Time {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.lastUpdate
+}
+
+// IsHealthy reports whether the resolver serves fresh data from Consul.
+func (r *ConsulResolver) IsHealthy() bool {
+	return r.Health().Healthy
+}
+
+// Health returns the age of the data and the state of every source.
+func (r *ConsulResolver) Health() HealthStatus {
+	maxAge := r.readinessAge()
+	r.mutex.Lock()
+	h := HealthStatus{LastUpdate: r.lastUpdate, Degraded: r.degraded}
+	running := r.running
+	poolSize := 0
+	if r.candidatePool != nil {
+		poolSize = len(r.candidatePool.Nodes)
+	}
+	r.mutex.Unlock()
+	if !h.LastUpdate.IsZero() {
+		h.Age = time.Since(h.LastUpdate)
+	}
+
+	r.errorMutex.Lock()
+	h.Sources = make(map[string]SourceStatus, len(r.sourceStatus))
+	for stage, s := range r.sourceStatus {
+		h.Sources[stage] = s
+	}
+	r.errorMutex.Unlock()
+
+	switch {
+	case !running:
+		h.Reason = "resolver not running"
+	case poolSize == 0:
+		h.Reason = "candidate pool is empty"
+	case h.Degraded:
+		h.Reason = "serving fallback nodes"
+	case h.LastUpdate.IsZero() || h.Age > maxAge:
+		h.Reason = "resolver data is stale"
+	default:
+		h.Healthy = true
+	}
+	return h
+}
+
+// stageOf returns the stage err was returned by.
+func (r *ConsulResolver) stageOf(err error) string {
+	var ue *UpdateError
+	if !errors.As(err, &ue) {
+		return STAGE_UPDATE
+	}
+	switch ue.Key {
+	case r.service, r.preparedQuery, r.k8sServiceKey:
+		return SOURCE_HEALTH
+	case r.cpuThresholdKey, r.onlineLabKey, r.configKey(r.cpuThresholdKey), r.configKey(r.onlineLabKey):
+		return SOURCE_CONFIG
+	case r.zoneCPUKey:
+		return SOURCE_ZONE_CPU
+	case r.instanceFactorKey:
+		return SOURCE_INSTANCE_FACTOR
+	}
+	return ue.Key
+}
+
+// reportError records err against its stage and calls the error hooks.
+func (r *ConsulResolver) reportError(err error) {
+	stage := r.stageOf(err)
+	r.errorMutex.Lock()
+	if r.sourceStatus == nil {
+		r.sourceStatus = make(map[string]SourceStatus)
+	}
+	s := r.sourceStatus[stage]
+	s.LastError = err
+	s.LastErrorTime = time.Now()
+	r.sourceStatus[stage] = s
+	hooks := r.errorHooks
+	r.errorMutex.Unlock()
+	for _, fn := range hooks {
+		fn(stage, err)
+	}
+}
+
+// reportSuccess records a successful fetch of source.
+func (r *ConsulResolver) reportSuccess(source string) {
+	r.errorMutex.Lock()
+	defer r.errorMutex.Unlock()
+	if r.sourceStatus == nil {
+		r.sourceStatus = make(map[string]SourceStatus)
+	}
+	s := r.sourceStatus[source]
+	s.LastSuccess = time.Now()
+	r.sourceStatus[source] = s
+}
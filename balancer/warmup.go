@@ -0,0 +1,72 @@
+package balancer
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	// START_TIME_META is the service meta key holding the instance start
+	// time, in unix seconds or RFC3339.
+	START_TIME_META = "startTime"
+	// WARMUP_DURATION is how long a node counts as cold after it started.
+	WARMUP_DURATION = 60 * time.Second
+	// WARMUP_MIN_RATE is the factor multiplier of a node that just started.
+	WARMUP_MIN_RATE = 0.1
+)
+
+func parseStartTime(meta map[string]string) time.Time {
+	v := meta[START_TIME_META]
+	if v == "" {
+		return time.Time{}
+	}
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0)
+	}
+	t, _ := time.Parse(time.RFC3339, v)
+	return t
+}
+
+// SetWarmup sets how long a node counts as cold after the start time in
+// its meta. Cold nodes ramp up linearly from WARMUP_MIN_RATE of their factor
+// and are never ejected by the circuit breaker. It defaults to
+// WARMUP_DURATION; a negative duration disables it.
+func (r *ConsulResolver) SetWarmup(warmup time.Duration) {
+	r.warmup = warmup
+}
+
+func (r *ConsulResolver) warmupDuration() time.Duration {
+	if r.warmup == 0 {
+		return WARMUP_DURATION
+	}
+	return r.warmup
+}
+
+// warmupRate returns the factor multiplier of node, 1 for a warm node.
+func (r *ConsulResolver) warmupRate(node *ServiceNode, now time.Time) float64 {
+	warmup := r.warmupDuration()
+	if warmup < 0 || node.StartTime.IsZero() {
+		return 1
+	}
+	age := now.Sub(node.StartTime)
+	if age >= warmup {
+		return 1
+	}
+	rate := float64(age) / float64(warmup)
+	if rate < WARMUP_MIN_RATE {
+		rate = WARMUP_MIN_RATE
+	}
+	return rate
+}
+
+// Cold reports whether node started less than the warmup duration ago.
+func (r *ConsulResolver) Cold(node *ServiceNode) bool {
+	return r.warmupRate(node, time.Now()) < 1
+}
+
+func (r *ConsulResolver) applyWarmup(pool *CandidatePool) *CandidatePool {
+	now := time.Now()
+	return r.scalePool(pool, ADJUST_WARMUP, func(node *ServiceNode) float64 {
+		return r.warmupRate(node, now)
+	})
+}
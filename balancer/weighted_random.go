@@ -0,0 +1,62 @@
+package balancer
+
+import (
+	"math/rand"
+)
+
+// SetWeightedRandom switches SelectNode from smooth weighted round robin to
+// factor-proportional random sampling. It keeps no per-pool picker state,
+// at the cost of short-term smoothness.
+func (r *ConsulResolver) SetWeightedRandom(weightedRandom bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.weightedRandom = weightedRandom
+}
+
+func (r *ConsulResolver) pickRandom(skip func(i int) bool) int {
+	pool := r.candidatePool
+	idx := -1
+	var total float64
+	for i := 0; i < len(pool.Factors); i++ {
+		if skip != nil && skip(i) {
+			continue
+		}
+		if idx < 0 {
+			idx = i
+		}
+		total += pool.Factors[i]
+	}
+	if total <= 0 {
+		return idx
+	}
+	x := rand.Float64() * total
+	for i := 0; i < len(pool.Factors); i++ {
+		if skip != nil && skip(i) {
+			continue
+		}
+		idx = i
+		x -= pool.Factors[i]
+		if x < 0 {
+			break
+		}
+	}
+	return idx
+}
+
+func (r *ConsulResolver) pick(skip func(i int) bool) int {
+	switch r.strategy {
+	case STRATEGY_ROUND_ROBIN:
+		return r.pickWeighted(skip)
+	case STRATEGY_RANDOM:
+		return r.pickRandom(skip)
+	case STRATEGY_LEAST_IN_FLIGHT:
+		return r.pickLeastInFlight(skip)
+	}
+	if r.leastInFlight {
+		return r.pickLeastInFlight(skip)
+	}
+	if r.weightedRandom {
+		return r.pickRandom(skip)
+	}
+	return r.pickWeighted(skip)
+}
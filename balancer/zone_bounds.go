@@ -0,0 +1,56 @@
+package balancer
+
+// FactorBounds overrides the balance factor clamps for one zone. Zero
+// values fall back to the BALANCEFACTOR_* defaults. Bounds putting a
+// minimum above its maximum are ignored.
+type FactorBounds struct {
+	MaxLocal float64 `json:"maxLocal"`
+	MinLocal float64 `json:"minLocal"`
+	MaxCross float64 `json:"maxCross"`
+	MinCross float64 `json:"minCross"`
+}
+
+func (r *ConsulResolver) factorBounds(zone string) FactorBounds {
+	b := FactorBounds{
+		MaxLocal: BALANCEFACTOR_MAX_LOCAL,
+		MinLocal: BALANCEFACTOR_MIN_LOCAL,
+		MaxCross: BALANCEFACTOR_MAX_CROSS,
+		MinCross: BALANCEFACTOR_MIN_CROSS,
+	}
+	if r.settings != nil && r.settings.FactorBounds != nil {
+		b = r.applyBounds(b, *r.settings.FactorBounds, "settings")
+	}
+	zb, ok := r.onlineLab.ZoneBounds[zone]
+	if !ok {
+		return b
+	}
+	return r.applyBounds(b, zb, "zone: "+zone)
+}
+
+// applyBounds returns b overridden by o, or b as is when the result would
+// put a minimum above its maximum.
+func (r *ConsulResolver) applyBounds(b, o FactorBounds, source string) FactorBounds {
+	nb := b.override(o)
+	if nb.MinLocal > nb.MaxLocal || nb.MinCross > nb.MaxCross {
+		r.logger.Warnf("service: %s, %s, factorBounds %+v put a minimum above its maximum, ignored", r.service, source, o)
+		return b
+	}
+	return nb
+}
+
+// override returns b with the positive bounds of o.
+func (b FactorBounds) override(o FactorBounds) FactorBounds {
+	if o.MaxLocal > 0 {
+		b.MaxLocal = o.MaxLocal
+	}
+	if o.MinLocal > 0 {
+		b.MinLocal = o.MinLocal
+	}
+	if o.MaxCross > 0 {
+		b.MaxCross = o.MaxCross
+	}
+	if o.MinCross > 0 {
+		b.MinCross = o.MinCross
+	}
+	return b
+}
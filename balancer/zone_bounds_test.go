@@ -0,0 +1,34 @@
+package balancer_test
+
+import (
+	"testing"
+
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestZoneBounds(t *testing.T) {
+	Convey("Test ZoneBounds", t, func() {
+		nodes := []balancer.ServiceNode{
+			{InstanceID: "i-1", Host: "10.0.0.1", Port: 80, Zone: "a", BalanceFactor: 5000},
+		}
+		currentFactor := func(bounds balancer.FactorBounds) float64 {
+			lab := balancer.DefaultOnlineLab()
+			lab.ZoneBounds = map[string]balancer.FactorBounds{"a": bounds}
+			r, err := balancer.NewSimpleResolver("a", nodes, lab, 0)
+			So(err, ShouldBeNil)
+			So(r.Update(), ShouldBeNil)
+			pool := r.CandidateNodes()
+			So(pool, ShouldHaveLength, 1)
+			return pool[0].CurrentFactor
+		}
+
+		Convey("Given zone bounds, the factor is clamped to them", func() {
+			So(currentFactor(balancer.FactorBounds{MaxLocal: 2000}), ShouldEqual, 2000)
+		})
+		Convey("Given zone bounds with a minimum above the maximum, they are ignored", func() {
+			So(currentFactor(balancer.FactorBounds{MinLocal: 4000, MaxLocal: 100}), ShouldEqual, balancer.BALANCEFACTOR_MAX_LOCAL)
+			So(currentFactor(balancer.FactorBounds{MinLocal: 3500}), ShouldEqual, balancer.BALANCEFACTOR_MAX_LOCAL)
+		})
+	})
+}
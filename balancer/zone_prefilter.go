@@ -0,0 +1,42 @@
+package balancer
+
+import (
+	"math"
+	"sort"
+)
+
+// nearestZones keeps the local zone and the OnlineLab.MaxCrossZones remote
+// zones with the lowest OnlineLab.ZoneCosts, so services spanning many
+// zones keep a bounded pool with some spillover capacity. Zones without a
+// cost come last, ordered by workload.
+func (r *ConsulResolver) nearestZones(serviceZones []*ServiceZone) []*ServiceZone {
+	k := r.onlineLab.MaxCrossZones
+	if k <= 0 || len(serviceZones) <= k {
+		return serviceZones
+	}
+	var out, remote []*ServiceZone
+	for _, z := range serviceZones {
+		if z.Zone == r.zone {
+			out = append(out, z)
+		} else {
+			remote = append(remote, z)
+		}
+	}
+	if len(remote) <= k {
+		return serviceZones
+	}
+	cost := func(z *ServiceZone) float64 {
+		if c, ok := r.onlineLab.ZoneCosts[z.Zone]; ok {
+			return c
+		}
+		return math.Inf(1)
+	}
+	sort.SliceStable(remote, func(i, j int) bool {
+		ci, cj := cost(remote[i]), cost(remote[j])
+		if ci != cj {
+			return ci < cj
+		}
+		return remote[i].WorkLoad < remote[j].WorkLoad
+	})
+	return append(out, remote[:k]...)
+}
@@ -0,0 +1,312 @@
+// Package config builds a balancer.ConsulResolver from a YAML or JSON
+// file, so services embedding the resolver do not each map their own
+// settings onto its constructor and setters.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	"github.com/mae-pax/consul-loadbalancer/util"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	STRATEGY_ROUND_ROBIN     = balancer.STRATEGY_ROUND_ROBIN
+	STRATEGY_RANDOM          = balancer.STRATEGY_RANDOM
+	STRATEGY_LEAST_IN_FLIGHT = balancer.STRATEGY_LEAST_IN_FLIGHT
+)
+
+const (
+	DEFAULT_INTERVAL = 10 * time.Second
+	DEFAULT_TIMEOUT  = 2 * time.Second
+)
+
+// Duration is a time.Duration written as a string such as "10s".
+type Duration time.Duration
+
+func (d *Duration) set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"10s\": %v", err)
+	}
+	return d.set(s)
+}
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	return d.set(s)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Config is the schema of the file. Only Address and Service are
+// required; every other zero value keeps the resolver default. Without
+// the cpuThreshold, zoneCPU, instanceFactor and onlineLab keys the
+// resolver serves static weights, like lb.New. Without Zone the zone is
+// read from CONSUL_LB_ZONE or the metadata of Cloud, queried with
+// ZoneTimeout, and NewResolver fails when it can't be found.
+type Config struct {
+	Cloud         string   `json:"cloud" yaml:"cloud"`
+	Address       string   `json:"address" yaml:"address"`
+	Service       string   `json:"service" yaml:"service"`
+	K8sServiceKey string   `json:"k8sServiceKey" yaml:"k8sServiceKey"`
+	Zone          string   `json:"zone" yaml:"zone"`
+	ZoneTimeout   Duration `json:"zoneTimeout" yaml:"zoneTimeout"`
+	Namespace     string   `json:"namespace" yaml:"namespace"`
+	Tags          []string `json:"tags" yaml:"tags"`
+	Datacenters   []string `json:"datacenters" yaml:"datacenters"`
+	PreparedQuery string   `json:"preparedQuery" yaml:"preparedQuery"`
+	Keys          Keys     `json:"keys" yaml:"keys"`
+	Interval      Duration `json:"interval" yaml:"interval"`
+	Timeout       Duration `json:"timeout" yaml:"timeout"`
+	Streaming     bool     `json:"streaming" yaml:"streaming"`
+	KVWatch       bool     `json:"kvWatch" yaml:"kvWatch"`
+	StaticWeights bool     `json:"staticWeights" yaml:"staticWeights"`
+	// Strategy is one of the STRATEGY_* constants, round_robin by default.
+	Strategy string    `json:"strategy" yaml:"strategy"`
+	Limits   Limits    `json:"limits" yaml:"limits"`
+	Fallback *Fallback `json:"fallback" yaml:"fallback"`
+}
+
+// Keys are the Consul KV keys the resolver reads.
+type Keys struct {
+	CPUThreshold   string `json:"cpuThreshold" yaml:"cpuThreshold"`
+	ZoneCPU        string `json:"zoneCPU" yaml:"zoneCPU"`
+	InstanceFactor string `json:"instanceFactor" yaml:"instanceFactor"`
+	OnlineLab      string `json:"onlineLab" yaml:"onlineLab"`
+	Cordon         string `json:"cordon" yaml:"cordon"`
+	Quarantine     string `json:"quarantine" yaml:"quarantine"`
+	ConfigSet      string `json:"configSet" yaml:"configSet"`
+	Discovery      string `json:"discovery" yaml:"discovery"`
+	// Settings holds the balancer.ResolverSettings applied at runtime.
+	Settings string `json:"settings" yaml:"settings"`
+}
+
+// learning reports whether any of the documents the factors are learned
+// from is set.
+func (k Keys) learning() bool {
+	return k.CPUThreshold != "" || k.ZoneCPU != "" || k.InstanceFactor != "" || k.OnlineLab != ""
+}
+
+// Limits bound retries, failures and the share of any single node.
+type Limits struct {
+	RetryAttempts    int      `json:"retryAttempts" yaml:"retryAttempts"`
+	RetryBaseDelay   Duration `json:"retryBaseDelay" yaml:"retryBaseDelay"`
+	RetryMaxDelay    Duration `json:"retryMaxDelay" yaml:"retryMaxDelay"`
+	UpdateFailures   int      `json:"updateFailures" yaml:"updateFailures"`
+	UpdateCoolDown   Duration `json:"updateCoolDown" yaml:"updateCoolDown"`
+	BreakerFailures  int      `json:"breakerFailures" yaml:"breakerFailures"`
+	BreakerCoolDown  Duration `json:"breakerCoolDown" yaml:"breakerCoolDown"`
+	BreakerRecovery  float64  `json:"breakerRecovery" yaml:"breakerRecovery"`
+	FairnessMultiple float64  `json:"fairnessMultiple" yaml:"fairnessMultiple"`
+	FairnessWindow   int      `json:"fairnessWindow" yaml:"fairnessWindow"`
+	RequestDeadline  Duration `json:"requestDeadline" yaml:"requestDeadline"`
+}
+
+// Fallback is what the resolver serves when Consul cannot.
+type Fallback struct {
+	Nodes     []Node   `json:"nodes" yaml:"nodes"`
+	Window    Duration `json:"window" yaml:"window"`
+	DNSHost   string   `json:"dnsHost" yaml:"dnsHost"`
+	DNSPort   int      `json:"dnsPort" yaml:"dnsPort"`
+	SRVServer string   `json:"srvServer" yaml:"srvServer"`
+	SRVDomain string   `json:"srvDomain" yaml:"srvDomain"`
+}
+
+// Node is a static fallback node.
+type Node struct {
+	InstanceID string   `json:"instanceID" yaml:"instanceID"`
+	Host       string   `json:"host" yaml:"host"`
+	Port       int      `json:"port" yaml:"port"`
+	Zone       string   `json:"zone" yaml:"zone"`
+	Tags       []string `json:"tags" yaml:"tags"`
+	Factor     float64  `json:"factor" yaml:"factor"`
+}
+
+// Load reads the file at path, as YAML when its extension is .yaml or .yml
+// and as JSON otherwise, and validates it.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	default:
+		err = json.Unmarshal(data, c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %v", path, err)
+	}
+	if err = c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", path, err)
+	}
+	return c, nil
+}
+
+// Validate checks the required fields and the strategy.
+func (c *Config) Validate() error {
+	if c.Address == "" {
+		return errors.New("address is required")
+	}
+	if c.Service == "" {
+		return errors.New("service is required")
+	}
+	switch c.Strategy {
+	case "", STRATEGY_ROUND_ROBIN, STRATEGY_RANDOM, STRATEGY_LEAST_IN_FLIGHT:
+	default:
+		return fmt.Errorf("unknown strategy %q", c.Strategy)
+	}
+	if k := c.Keys; !c.StaticWeights && k.learning() && (k.CPUThreshold == "" || k.ZoneCPU == "" || k.InstanceFactor == "" || k.OnlineLab == "") {
+		return errors.New("keys cpuThreshold, zoneCPU, instanceFactor and onlineLab are all required unless staticWeights is set")
+	}
+	if c.Interval < 0 || c.Timeout < 0 {
+		return errors.New("interval and timeout must not be negative")
+	}
+	if c.Fallback != nil {
+		for _, node := range c.Fallback.Nodes {
+			if node.Host == "" || node.Port <= 0 {
+				return fmt.Errorf("fallback node %q needs a host and a port", node.InstanceID)
+			}
+		}
+	}
+	return nil
+}
+
+// NewResolver builds the resolver described by c. Like the balancer
+// constructors it does not start it, and it logs nothing until a logger is
+// set with SetLogger.
+func NewResolver(c *Config) (*balancer.ConsulResolver, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	interval, timeout := time.Duration(c.Interval), time.Duration(c.Timeout)
+	if interval == 0 {
+		interval = DEFAULT_INTERVAL
+	}
+	if timeout == 0 {
+		timeout = DEFAULT_TIMEOUT
+	}
+	var args []string
+	if c.K8sServiceKey != "" {
+		args = append(args, c.K8sServiceKey)
+	}
+	r, err := balancer.NewConsulResolver(c.Cloud, c.Address, c.Service, c.Keys.CPUThreshold, c.Keys.ZoneCPU, c.Keys.InstanceFactor, c.Keys.OnlineLab, interval, timeout, args...)
+	if err != nil {
+		return nil, err
+	}
+	if c.Zone != "" {
+		r.SetZone(c.Zone)
+	} else if err = r.SetZoneProvider(util.CloudZone(c.Cloud, time.Duration(c.ZoneTimeout))); err != nil {
+		return nil, err
+	}
+	if c.Namespace != "" {
+		r.SetNamespace(c.Namespace)
+	}
+	if len(c.Tags) > 0 {
+		r.SetTags(c.Tags...)
+	}
+	if len(c.Datacenters) > 0 {
+		r.SetDatacenters(c.Datacenters, balancer.REMOTE_DC_PENALTY)
+	}
+	if c.PreparedQuery != "" {
+		r.SetPreparedQuery(c.PreparedQuery)
+	}
+	if c.Keys.Cordon != "" {
+		r.SetCordonKey(c.Keys.Cordon)
+	}
+	if c.Keys.Quarantine != "" {
+		r.SetQuarantineKey(c.Keys.Quarantine)
+	}
+	if c.Keys.ConfigSet != "" {
+		r.SetConfigSetKey(c.Keys.ConfigSet)
+	}
+	if c.Keys.Discovery != "" {
+		r.SetDiscoveryKey(c.Keys.Discovery)
+	}
+	if c.Keys.Settings != "" {
+		r.SetSettingsKey(c.Keys.Settings)
+	}
+	r.SetStreaming(c.Streaming)
+	r.SetKVWatch(c.KVWatch)
+	r.SetStaticWeights(c.StaticWeights || !c.Keys.learning())
+	switch c.Strategy {
+	case STRATEGY_RANDOM:
+		r.SetWeightedRandom(true)
+	case STRATEGY_LEAST_IN_FLIGHT:
+		r.SetLeastInFlight(true)
+	}
+
+	l := c.Limits
+	if l.RetryAttempts > 0 {
+		r.SetRetry(l.RetryAttempts, time.Duration(l.RetryBaseDelay), time.Duration(l.RetryMaxDelay))
+	}
+	if l.UpdateFailures > 0 {
+		r.SetUpdateCircuit(l.UpdateFailures, time.Duration(l.UpdateCoolDown))
+	}
+	if l.BreakerFailures > 0 {
+		r.SetCircuitBreaker(l.BreakerFailures, time.Duration(l.BreakerCoolDown), l.BreakerRecovery)
+	}
+	if l.FairnessMultiple > 0 {
+		r.SetFairnessGuard(l.FairnessMultiple, l.FairnessWindow)
+	}
+	if l.RequestDeadline > 0 {
+		r.SetRequestDeadline(time.Duration(l.RequestDeadline))
+	}
+
+	if f := c.Fallback; f != nil {
+		if len(f.Nodes) > 0 {
+			nodes := make([]balancer.ServiceNode, len(f.Nodes))
+			for i, node := range f.Nodes {
+				nodes[i] = balancer.ServiceNode{
+					InstanceID:    node.InstanceID,
+					Host:          node.Host,
+					Port:          node.Port,
+					Zone:          node.Zone,
+					Tags:          node.Tags,
+					BalanceFactor: node.Factor,
+				}
+			}
+			r.SetFallbackNodes(nodes, time.Duration(f.Window))
+		}
+		if f.DNSHost != "" {
+			r.SetDNSFallback(f.DNSHost, f.DNSPort)
+		}
+		if f.SRVServer != "" || f.SRVDomain != "" {
+			r.SetSRVFallback(f.SRVServer, f.SRVDomain)
+		}
+	}
+	return r, nil
+}
+
+// NewResolverFromFile loads the file at path and builds its resolver.
+func NewResolverFromFile(path string) (*balancer.ConsulResolver, error) {
+	c, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewResolver(c)
+}
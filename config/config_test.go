@@ -0,0 +1,145 @@
+package config_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mae-pax/consul-loadbalancer/config"
+	"github.com/mae-pax/consul-loadbalancer/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const yamlConfig = `
+address: 127.0.0.1:8500
+service: as
+zone: a
+tags: [v2]
+keys:
+  cpuThreshold: clb/cpu
+  zoneCPU: clb/zone
+  instanceFactor: clb/factor
+  onlineLab: clb/lab
+interval: 5s
+strategy: least_in_flight
+limits:
+  retryAttempts: 3
+  retryBaseDelay: 50ms
+  retryMaxDelay: 1s
+fallback:
+  window: 30s
+  nodes:
+    - {instanceID: seed-1, host: 10.0.0.1, port: 8080, factor: 2}
+`
+
+const jsonConfig = `{
+	"address": "127.0.0.1:8500",
+	"service": "as",
+	"keys": {"cpuThreshold": "clb/cpu", "zoneCPU": "clb/zone", "instanceFactor": "clb/factor", "onlineLab": "clb/lab"},
+	"interval": "5s",
+	"strategy": "random"
+}`
+
+func TestLoad(t *testing.T) {
+	Convey("Test Load", t, func() {
+		dir, err := ioutil.TempDir("", "clb-config")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		write := func(name, content string) string {
+			path := filepath.Join(dir, name)
+			So(ioutil.WriteFile(path, []byte(content), 0644), ShouldBeNil)
+			return path
+		}
+
+		Convey("YAML files are parsed by extension", func() {
+			c, err := config.Load(write("clb.yaml", yamlConfig))
+			So(err, ShouldBeNil)
+			So(c.Service, ShouldEqual, "as")
+			So(c.Tags, ShouldResemble, []string{"v2"})
+			So(c.Keys.CPUThreshold, ShouldEqual, "clb/cpu")
+			So(time.Duration(c.Interval), ShouldEqual, 5*time.Second)
+			So(c.Strategy, ShouldEqual, config.STRATEGY_LEAST_IN_FLIGHT)
+			So(c.Limits.RetryAttempts, ShouldEqual, 3)
+			So(time.Duration(c.Limits.RetryBaseDelay), ShouldEqual, 50*time.Millisecond)
+			So(c.Fallback.Nodes, ShouldHaveLength, 1)
+			So(c.Fallback.Nodes[0].Factor, ShouldEqual, 2)
+		})
+
+		Convey("JSON files parse into the same schema", func() {
+			c, err := config.Load(write("clb.json", jsonConfig))
+			So(err, ShouldBeNil)
+			So(c.Keys.OnlineLab, ShouldEqual, "clb/lab")
+			So(time.Duration(c.Interval), ShouldEqual, 5*time.Second)
+			So(c.Strategy, ShouldEqual, config.STRATEGY_RANDOM)
+		})
+
+		Convey("Invalid files are rejected", func() {
+			_, err := config.Load(write("missing.yaml", "address: 127.0.0.1:8500\n"))
+			So(err, ShouldNotBeNil)
+			_, err = config.Load(write("strategy.yaml", "address: a\nservice: as\nstrategy: fastest\n"))
+			So(err, ShouldNotBeNil)
+			_, err = config.Load(write("interval.json", `{"address": "a", "service": "as", "interval": 5}`))
+			So(err, ShouldNotBeNil)
+			_, err = config.Load(write("keys.yaml", "address: a\nservice: as\nkeys: {cpuThreshold: clb/cpu}\n"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("NewResolverFromFile builds the resolver", func() {
+			r, err := config.NewResolverFromFile(write("clb.yml", yamlConfig))
+			So(err, ShouldBeNil)
+			So(r, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestMinimalConfig(t *testing.T) {
+	Convey("Test a minimal config", t, func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if !strings.HasPrefix(req.URL.Path, "/v1/health/service/") {
+				http.NotFound(w, req)
+				return
+			}
+			w.Header().Set("X-Consul-Index", "1")
+			json.NewEncoder(w).Encode([]api.ServiceEntry{{
+				Node: &api.Node{Node: "n-1"},
+				Service: &api.AgentService{
+					Address: "10.0.0.1",
+					Port:    80,
+					Meta:    map[string]string{"zone": "a", "instanceID": "i-1", "balanceFactor": "100"},
+				},
+			}})
+		}))
+		defer server.Close()
+		dir, err := ioutil.TempDir("", "clb-config")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "clb.yaml")
+		content := "address: " + strings.TrimPrefix(server.URL, "http://") + "\nservice: as\nzone: a\n"
+		So(ioutil.WriteFile(path, []byte(content), 0644), ShouldBeNil)
+
+		Convey("Given only the address and service, the resolver starts with static weights", func() {
+			r, err := config.NewResolverFromFile(path)
+			So(err, ShouldBeNil)
+			So(r.Start(), ShouldBeNil)
+			defer r.Stop()
+			node := r.SelectNode()
+			So(node, ShouldNotBeNil)
+			So(node.InstanceID, ShouldEqual, "i-1")
+		})
+		Convey("Given no zone, it is read from the environment", func() {
+			t.Setenv(util.ZONE_ENV, "b")
+			content := "address: " + strings.TrimPrefix(server.URL, "http://") + "\nservice: as\n"
+			So(ioutil.WriteFile(path, []byte(content), 0644), ShouldBeNil)
+			r, err := config.NewResolverFromFile(path)
+			So(err, ShouldBeNil)
+			So(r.Zone(), ShouldEqual, "b")
+		})
+	})
+}
@@ -0,0 +1,66 @@
+package grpcbalancer
+
+import (
+	"net"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	gbalancer "google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+// Name is the load balancing policy picking subconns with
+// ConsulResolver.SelectNode, keeping its zone-aware weighting per RPC.
+const Name = "consul_weighted"
+
+func init() {
+	gbalancer.Register(base.NewBalancerBuilder(Name, &pickerBuilder{}, base.Config{HealthCheck: true}))
+}
+
+type pickerBuilder struct{}
+
+func (*pickerBuilder) Build(info base.PickerBuildInfo) gbalancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(gbalancer.ErrNoSubConnAvailable)
+	}
+	p := &picker{subConns: make(map[string]gbalancer.SubConn, len(info.ReadySCs))}
+	for sc, sci := range info.ReadySCs {
+		p.subConns[sci.Address.Addr] = sc
+		p.ready = append(p.ready, sc)
+		if r, ok := sci.Address.BalancerAttributes.Value(resolverKey{}).(*balancer.ConsulResolver); ok {
+			p.resolver = r
+		}
+	}
+	return p
+}
+
+type picker struct {
+	resolver *balancer.ConsulResolver
+	subConns map[string]gbalancer.SubConn
+	ready    []gbalancer.SubConn
+	next     uint64
+}
+
+// Pick selects a node with the resolver, leaving out the nodes whose
+// subconn is not ready, and uses its subconn. If none of them is ready it
+// falls back to round robin over the ready subconns.
+func (p *picker) Pick(gbalancer.PickInfo) (gbalancer.PickResult, error) {
+	if p.resolver != nil {
+		node := p.resolver.SelectNodeExcept(func(node *balancer.ServiceNode) bool {
+			_, ok := p.subConns[nodeAddr(node)]
+			return !ok
+		})
+		if node != nil {
+			if sc, ok := p.subConns[nodeAddr(node)]; ok {
+				return gbalancer.PickResult{SubConn: sc}, nil
+			}
+		}
+	}
+	i := atomic.AddUint64(&p.next, 1) - 1
+	return gbalancer.PickResult{SubConn: p.ready[i%uint64(len(p.ready))]}, nil
+}
+
+func nodeAddr(node *balancer.ServiceNode) string {
+	return net.JoinHostPort(node.Host, strconv.Itoa(node.Port))
+}
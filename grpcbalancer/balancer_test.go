@@ -0,0 +1,95 @@
+package grpcbalancer_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	"github.com/mae-pax/consul-loadbalancer/grpcbalancer"
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// backend is a gRPC server answering every method and counting the calls.
+type backend struct {
+	mutex  sync.Mutex
+	calls  int
+	server *grpc.Server
+	port   int
+}
+
+func newBackend() *backend {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	So(err, ShouldBeNil)
+	b := &backend{port: lis.Addr().(*net.TCPAddr).Port}
+	b.server = grpc.NewServer(grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		b.mutex.Lock()
+		b.calls++
+		b.mutex.Unlock()
+		if err := stream.RecvMsg(&emptypb.Empty{}); err != nil {
+			return err
+		}
+		return stream.SendMsg(&emptypb.Empty{})
+	}))
+	go b.server.Serve(lis)
+	return b
+}
+
+func (b *backend) Calls() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.calls
+}
+
+func (b *backend) node(id string) balancer.ServiceNode {
+	return balancer.ServiceNode{InstanceID: id, Host: "127.0.0.1", Port: b.port, Zone: "a", BalanceFactor: 100}
+}
+
+// closedPort returns a port nothing listens on.
+func closedPort() int {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	So(err, ShouldBeNil)
+	port := lis.Addr().(*net.TCPAddr).Port
+	lis.Close()
+	return port
+}
+
+func dial(r *balancer.ConsulResolver) *grpc.ClientConn {
+	opts := append(grpcbalancer.DialOptions(r), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.Dial(grpcbalancer.Scheme+":///as", opts...)
+	So(err, ShouldBeNil)
+	return conn
+}
+
+func call(conn *grpc.ClientConn) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return conn.Invoke(ctx, "/test.Service/Call", &emptypb.Empty{}, &emptypb.Empty{}, grpc.WaitForReady(true))
+}
+
+func TestPicker(t *testing.T) {
+	Convey("Test the consul_weighted picker", t, func() {
+		b1, b2 := newBackend(), newBackend()
+		defer b1.server.Stop()
+		defer b2.server.Stop()
+		down := balancer.ServiceNode{InstanceID: "i-down", Host: "127.0.0.1", Port: closedPort(), Zone: "a", BalanceFactor: 100}
+		r, err := balancer.NewSimpleResolver("a", []balancer.ServiceNode{b1.node("i-1"), b2.node("i-2"), down}, nil, 0)
+		So(err, ShouldBeNil)
+		conn := dial(r)
+		defer conn.Close()
+
+		Convey("Given a node without a ready subconn, RPCs go to the others and count once", func() {
+			const n = 100
+			for i := 0; i < n; i++ {
+				So(call(conn), ShouldBeNil)
+			}
+			So(b1.Calls()+b2.Calls(), ShouldEqual, n)
+			So(r.Metrics().SelectNum, ShouldEqual, n)
+		})
+	})
+}
@@ -0,0 +1,66 @@
+package grpcbalancer
+
+import (
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+)
+
+// Scheme is the target scheme of the resolver, e.g. consul-lb:///service.
+const Scheme = "consul-lb"
+
+type resolverKey struct{}
+
+// DialOptions returns the options to dial a consul-lb:/// target resolved
+// by r with the consul_weighted policy.
+func DialOptions(r *balancer.ConsulResolver) []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithResolvers(NewResolverBuilder(r)),
+		grpc.WithDefaultServiceConfig(`{"loadBalancingConfig": [{"` + Name + `": {}}]}`),
+	}
+}
+
+// NewResolverBuilder returns a gRPC resolver.Builder feeding the nodes of
+// the candidate pool of r to the client connection.
+func NewResolverBuilder(r *balancer.ConsulResolver) resolver.Builder {
+	return &resolverBuilder{resolver: r}
+}
+
+type resolverBuilder struct {
+	resolver *balancer.ConsulResolver
+}
+
+func (b *resolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	ch, cancel := b.resolver.Subscribe()
+	go func() {
+		for range ch {
+			cc.UpdateState(resolver.State{Addresses: b.addresses()})
+		}
+	}()
+	return &grpcResolver{cancel: cancel}, nil
+}
+
+func (b *resolverBuilder) Scheme() string {
+	return Scheme
+}
+
+func (b *resolverBuilder) addresses() []resolver.Address {
+	nodes := b.resolver.CandidateNodes()
+	attrs := attributes.New(resolverKey{}, b.resolver)
+	addrs := make([]resolver.Address, len(nodes))
+	for i := range nodes {
+		addrs[i] = resolver.Address{Addr: nodeAddr(&nodes[i]), BalancerAttributes: attrs}
+	}
+	return addrs
+}
+
+type grpcResolver struct {
+	cancel func()
+}
+
+func (*grpcResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *grpcResolver) Close() {
+	r.cancel()
+}
@@ -0,0 +1,40 @@
+package grpcbalancer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	"github.com/mae-pax/consul-loadbalancer/grpcbalancer"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestResolver(t *testing.T) {
+	Convey("Test the consul-lb resolver", t, func() {
+		b1, b2 := newBackend(), newBackend()
+		defer b1.server.Stop()
+		defer b2.server.Stop()
+		r, err := balancer.NewSimpleResolver("a", []balancer.ServiceNode{b1.node("i-1")}, nil, 0)
+		So(err, ShouldBeNil)
+		So(grpcbalancer.NewResolverBuilder(r).Scheme(), ShouldEqual, grpcbalancer.Scheme)
+		conn := dial(r)
+		defer conn.Close()
+
+		Convey("Given the pool changes, RPCs follow it", func() {
+			So(call(conn), ShouldBeNil)
+			So(b1.Calls(), ShouldEqual, 1)
+			So(r.SetNodes([]balancer.ServiceNode{b2.node("i-2")}), ShouldBeNil)
+			// the update reaches the connection asynchronously
+			deadline := time.Now().Add(5 * time.Second)
+			for b2.Calls() == 0 && time.Now().Before(deadline) {
+				So(call(conn), ShouldBeNil)
+			}
+			So(b2.Calls(), ShouldBeGreaterThan, 0)
+			calls := b1.Calls()
+			for i := 0; i < 10; i++ {
+				So(call(conn), ShouldBeNil)
+			}
+			So(b1.Calls(), ShouldEqual, calls)
+		})
+	})
+}
@@ -0,0 +1,81 @@
+package grpcserver
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative pool.proto
+
+import (
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	"github.com/mae-pax/consul-loadbalancer/util"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Server implements the PoolService described in pool.proto for one
+// resolver, so that local non-Go processes can follow its candidate pool.
+// Node hosts and public IPs are masked while the resolver redacts.
+type Server struct {
+	UnimplementedPoolServiceServer
+	resolver *balancer.ConsulResolver
+}
+
+func NewServer(r *balancer.ConsulResolver) *Server {
+	return &Server{resolver: r}
+}
+
+// Register registers the PoolService on s.
+func (s *Server) Register(gs *grpc.Server) {
+	RegisterPoolServiceServer(gs, s)
+}
+
+// Watch sends the current pool, then a new snapshot on every pool change,
+// until the client goes away.
+func (s *Server) Watch(_ *emptypb.Empty, stream PoolService_WatchServer) error {
+	ch, cancel := s.resolver.Subscribe()
+	defer cancel()
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			msg, err := s.snapshot()
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+func (s *Server) snapshot() (*structpb.Struct, error) {
+	nodes := s.resolver.CandidateNodes()
+	redact := s.resolver.Redacting()
+	list := make([]interface{}, len(nodes))
+	for i, node := range nodes {
+		host, publicIP := node.Host, node.PublicIP
+		if redact {
+			host = util.REDACTED
+			if publicIP != "" {
+				publicIP = util.REDACTED
+			}
+		}
+		list[i] = map[string]interface{}{
+			"instanceID": node.InstanceID,
+			"host":       host,
+			"port":       node.Port,
+			"zone":       node.Zone,
+			"publicIP":   publicIP,
+			"factor":     node.CurrentFactor,
+			"workload":   node.WorkLoad,
+		}
+	}
+	return structpb.NewStruct(map[string]interface{}{
+		"service": s.resolver.Service(),
+		"zone":    s.resolver.Zone(),
+		"nodes":   list,
+	})
+}
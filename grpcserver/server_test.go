@@ -0,0 +1,71 @@
+package grpcserver_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	"github.com/mae-pax/consul-loadbalancer/grpcserver"
+	"github.com/mae-pax/consul-loadbalancer/util"
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestServer(t *testing.T) {
+	Convey("Test Server", t, func() {
+		r, err := balancer.NewSimpleResolver("a", []balancer.ServiceNode{
+			{InstanceID: "i-1", Host: "10.0.0.1", Port: 80, Zone: "a", PublicIP: "54.0.0.1", BalanceFactor: 300},
+			{InstanceID: "i-2", Host: "10.0.0.2", Port: 80, Zone: "a", BalanceFactor: 900},
+		}, nil, 0)
+		So(err, ShouldBeNil)
+		So(r.Update(), ShouldBeNil)
+
+		lis := bufconn.Listen(1 << 20)
+		gs := grpc.NewServer()
+		grpcserver.NewServer(r).Register(gs)
+		go gs.Serve(lis)
+		defer gs.Stop()
+		conn, err := grpc.Dial("bufnet",
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+			grpc.WithTransportCredentials(insecure.NewCredentials()))
+		So(err, ShouldBeNil)
+		defer conn.Close()
+		watch := func() map[string]map[string]interface{} {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			stream, err := grpcserver.NewPoolServiceClient(conn).Watch(ctx, &emptypb.Empty{})
+			So(err, ShouldBeNil)
+			msg, err := stream.Recv()
+			So(err, ShouldBeNil)
+			pool := msg.AsMap()
+			So(pool["zone"], ShouldEqual, "a")
+			nodes := make(map[string]map[string]interface{})
+			for _, v := range pool["nodes"].([]interface{}) {
+				node := v.(map[string]interface{})
+				nodes[node["instanceID"].(string)] = node
+			}
+			return nodes
+		}
+
+		Convey("Given a watch, the first message is the current pool", func() {
+			nodes := watch()
+			So(nodes, ShouldHaveLength, 2)
+			So(nodes["i-1"]["host"], ShouldEqual, "10.0.0.1")
+			So(nodes["i-1"]["publicIP"], ShouldEqual, "54.0.0.1")
+			So(nodes["i-1"]["port"], ShouldEqual, 80)
+		})
+		Convey("Given redaction, hosts and public IPs are masked", func() {
+			r.SetRedaction(true)
+			nodes := watch()
+			So(nodes, ShouldHaveLength, 2)
+			So(nodes["i-1"]["host"], ShouldEqual, util.REDACTED)
+			So(nodes["i-1"]["publicIP"], ShouldEqual, util.REDACTED)
+			So(nodes["i-2"]["host"], ShouldEqual, util.REDACTED)
+			So(nodes["i-2"]["publicIP"], ShouldEqual, "")
+		})
+	})
+}
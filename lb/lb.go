@@ -0,0 +1,265 @@
+// Package lb is the stable API of consul-loadbalancer: a small Resolver
+// interface, a Node value type and functional options, wrapping the
+// balancer package. The balancer package keeps changing with its
+// internals; code built on lb does not have to follow.
+package lb
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	"github.com/mae-pax/consul-loadbalancer/util"
+)
+
+var (
+	// ErrNoNodes is returned by Select when there is no node to select.
+	ErrNoNodes = balancer.ErrNoCandidates
+	// ErrNotStarted is returned by Select before the first update.
+	ErrNotStarted = balancer.ErrNotStarted
+	// ErrStopped is returned by Select after Stop.
+	ErrStopped = balancer.ErrStopped
+)
+
+// Node is one instance of the service.
+type Node struct {
+	InstanceID string
+	Host       string
+	Port       int
+	Ports      map[string]int
+	Zone       string
+	Tags       []string
+	// Factor is the weight the node is currently selected with.
+	Factor float64
+}
+
+// Addr returns host:port of the node.
+func (n Node) Addr() string {
+	return net.JoinHostPort(n.Host, strconv.Itoa(n.Port))
+}
+
+// Picker picks one of nodes, returning its index or -1 if there is none.
+type Picker func(nodes []Node) int
+
+// Resolver selects the nodes of one service.
+type Resolver interface {
+	// Start runs the first update and keeps the nodes updated until Stop.
+	Start(ctx context.Context) error
+	Stop()
+	// Select returns the node to send the next request to.
+	Select() (Node, error)
+	// Nodes returns the candidate nodes.
+	Nodes() []Node
+	// Report records the latency and outcome of a request sent to node.
+	Report(node Node, latency time.Duration, err error)
+}
+
+// Keys are the Consul KV keys of the documents the resolver learns from.
+type Keys struct {
+	CPUThreshold   string
+	ZoneCPU        string
+	InstanceFactor string
+	OnlineLab      string
+}
+
+type options struct {
+	cloud     string
+	zone      string
+	zones     util.ZoneProvider
+	interval  time.Duration
+	timeout   time.Duration
+	keys      Keys
+	logger    util.Logger
+	tags      []string
+	namespace string
+	picker    Picker
+	fallback  []Node
+	window    time.Duration
+}
+
+// Option configures a Resolver built by New.
+type Option func(*options)
+
+// WithCloud detects the zone from the metadata service of cloud, one of
+// the util.CLOUD_* constants.
+func WithCloud(cloud string) Option {
+	return func(o *options) { o.cloud = cloud }
+}
+
+// WithZone sets the zone of the client instead of detecting it.
+func WithZone(zone string) Option {
+	return func(o *options) { o.zone = zone }
+}
+
+// WithZoneProvider finds the zone of the client with provider, e.g.
+// util.FirstZone(util.EnvZone{}, util.MetadataZone{Cloud: cloud, Timeout: timeout}).
+// New fails when it finds none.
+func WithZoneProvider(provider util.ZoneProvider) Option {
+	return func(o *options) { o.zones = provider }
+}
+
+// WithInterval sets how often the nodes are updated, 10s by default.
+func WithInterval(interval time.Duration) Option {
+	return func(o *options) { o.interval = interval }
+}
+
+// WithTimeout sets the timeout of a Consul call, 2s by default.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) { o.timeout = timeout }
+}
+
+// WithKeys sets the KV documents to learn from. Without them the
+// registered weights are used as is.
+func WithKeys(keys Keys) Option {
+	return func(o *options) { o.keys = keys }
+}
+
+// WithLogger sets the logger, silent by default.
+func WithLogger(logger util.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// WithTags keeps only the nodes registered with all of tags.
+func WithTags(tags ...string) Option {
+	return func(o *options) { o.tags = tags }
+}
+
+// WithNamespace queries the Consul Enterprise namespace.
+func WithNamespace(namespace string) Option {
+	return func(o *options) { o.namespace = namespace }
+}
+
+// WithPicker picks with picker instead of the built-in weighted round
+// robin.
+func WithPicker(picker Picker) Option {
+	return func(o *options) { o.picker = picker }
+}
+
+// WithFallbackNodes serves nodes, weighted by their Factor, when Consul
+// returns no passing instance, when it has been unreachable for longer
+// than the fallback window or when it is unreachable at Start.
+func WithFallbackNodes(nodes []Node) Option {
+	return func(o *options) { o.fallback = nodes }
+}
+
+// WithFallbackWindow sets how long updates may fail before the fallback
+// nodes are served, a minute by default.
+func WithFallbackWindow(window time.Duration) Option {
+	return func(o *options) { o.window = window }
+}
+
+const (
+	DEFAULT_INTERVAL = 10 * time.Second
+	DEFAULT_TIMEOUT  = 2 * time.Second
+)
+
+// New returns a Resolver of service registered in the Consul agent at
+// address.
+func New(address, service string, opts ...Option) (Resolver, error) {
+	o := options{interval: DEFAULT_INTERVAL, timeout: DEFAULT_TIMEOUT, logger: util.NopLogger{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	r, err := balancer.NewConsulResolver(o.cloud, address, service, o.keys.CPUThreshold, o.keys.ZoneCPU, o.keys.InstanceFactor, o.keys.OnlineLab, o.interval, o.timeout)
+	if err != nil {
+		return nil, err
+	}
+	r.SetLogger(o.logger)
+	if o.zone != "" {
+		r.SetZone(o.zone)
+	} else if o.zones != nil {
+		if err = r.SetZoneProvider(o.zones); err != nil {
+			return nil, err
+		}
+	}
+	if o.keys == (Keys{}) {
+		r.SetStaticWeights(true)
+	}
+	if len(o.tags) > 0 {
+		r.SetTags(o.tags...)
+	}
+	if o.namespace != "" {
+		r.SetNamespace(o.namespace)
+	}
+	if o.picker != nil {
+		r.SetPicker(nodePicker(o.picker))
+	}
+	if len(o.fallback) > 0 {
+		nodes := make([]balancer.ServiceNode, len(o.fallback))
+		for i, node := range o.fallback {
+			nodes[i] = balancer.ServiceNode{
+				InstanceID:    node.InstanceID,
+				Host:          node.Host,
+				Port:          node.Port,
+				Ports:         node.Ports,
+				Zone:          node.Zone,
+				Tags:          node.Tags,
+				BalanceFactor: node.Factor,
+			}
+		}
+		r.SetFallbackNodes(nodes, o.window)
+	}
+	return &resolver{r: r}, nil
+}
+
+type resolver struct {
+	r *balancer.ConsulResolver
+}
+
+func (r *resolver) Start(ctx context.Context) error {
+	return r.r.StartContext(ctx)
+}
+
+func (r *resolver) Stop() {
+	r.r.Stop()
+}
+
+func (r *resolver) Select() (Node, error) {
+	node, err := r.r.SelectNodeE()
+	if err != nil {
+		return Node{}, err
+	}
+	return newNode(node), nil
+}
+
+func (r *resolver) Nodes() []Node {
+	candidates := r.r.CandidateNodes()
+	nodes := make([]Node, len(candidates))
+	for i := range candidates {
+		nodes[i] = newNode(&candidates[i])
+	}
+	return nodes
+}
+
+func (r *resolver) Report(node Node, latency time.Duration, err error) {
+	r.r.ReportResult(&balancer.ServiceNode{InstanceID: node.InstanceID, Host: node.Host, Port: node.Port}, latency, err)
+}
+
+func newNode(node *balancer.ServiceNode) Node {
+	return Node{
+		InstanceID: node.InstanceID,
+		Host:       node.Host,
+		Port:       node.Port,
+		Ports:      node.Ports,
+		Zone:       node.Zone,
+		Tags:       node.Tags,
+		Factor:     node.CurrentFactor,
+	}
+}
+
+// nodePicker adapts picker to the balancer.
+func nodePicker(picker Picker) balancer.NodePicker {
+	return func(candidates []*balancer.ServiceNode) *balancer.ServiceNode {
+		nodes := make([]Node, len(candidates))
+		for i, node := range candidates {
+			nodes[i] = newNode(node)
+		}
+		i := picker(nodes)
+		if i < 0 || i >= len(candidates) {
+			return nil
+		}
+		return candidates[i]
+	}
+}
@@ -0,0 +1,73 @@
+package lb_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mae-pax/consul-loadbalancer/lb"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func healthServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Consul-Index", "1")
+		var entries []api.ServiceEntry
+		for i, zone := range []string{"a", "a", "b"} {
+			id := string(rune('1' + i))
+			entries = append(entries, api.ServiceEntry{
+				Node: &api.Node{Node: "i-" + id},
+				Service: &api.AgentService{
+					Address: "10.0.0." + id,
+					Port:    80,
+					Meta:    map[string]string{"zone": zone, "instanceID": "i-" + id, "balanceFactor": "100"},
+				},
+			})
+		}
+		json.NewEncoder(w).Encode(entries)
+	}))
+}
+
+func TestResolver(t *testing.T) {
+	Convey("Test Resolver", t, func() {
+		server := healthServer()
+		defer server.Close()
+		address := strings.TrimPrefix(server.URL, "http://")
+
+		Convey("Given no KV keys, the registered weights of the local zone are used", func() {
+			r, err := lb.New(address, "as", lb.WithZone("a"))
+			So(err, ShouldBeNil)
+			_, err = r.Select()
+			So(err, ShouldEqual, lb.ErrNotStarted)
+			So(r.Start(context.Background()), ShouldBeNil)
+			defer r.Stop()
+			So(len(r.Nodes()), ShouldEqual, 2)
+			node, err := r.Select()
+			So(err, ShouldBeNil)
+			So(node.Zone, ShouldEqual, "a")
+			So(node.Addr(), ShouldStartWith, "10.0.0.")
+		})
+		Convey("Given a picker, Select picks with it", func() {
+			r, err := lb.New(address, "as", lb.WithZone("a"), lb.WithPicker(func(nodes []lb.Node) int {
+				for i, node := range nodes {
+					if node.InstanceID == "i-2" {
+						return i
+					}
+				}
+				return -1
+			}))
+			So(err, ShouldBeNil)
+			So(r.Start(context.Background()), ShouldBeNil)
+			defer r.Stop()
+			for i := 0; i < 5; i++ {
+				node, err := r.Select()
+				So(err, ShouldBeNil)
+				So(node.InstanceID, ShouldEqual, "i-2")
+			}
+		})
+	})
+}
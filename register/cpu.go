@@ -0,0 +1,66 @@
+package register
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CPUSampler returns the cpu utilization, in percent, since its last call.
+type CPUSampler interface {
+	Sample() (float64, error)
+}
+
+// ProcCPUSampler reads /proc/stat. Its first Sample covers the time since
+// boot.
+type ProcCPUSampler struct {
+	mutex sync.Mutex
+	idle  uint64
+	total uint64
+}
+
+func (s *ProcCPUSampler) Sample() (float64, error) {
+	idle, total, err := procCPUTimes()
+	if err != nil {
+		return 0, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	dIdle, dTotal := idle-s.idle, total-s.total
+	s.idle, s.total = idle, total
+	if dTotal == 0 {
+		return 0, nil
+	}
+	return 100 * (1 - float64(dIdle)/float64(dTotal)), nil
+}
+
+func procCPUTimes() (idle, total uint64, err error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return 0, 0, errors.New("empty /proc/stat")
+	}
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, errors.New("unexpected /proc/stat format")
+	}
+	for i, field := range fields[1:] {
+		v, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		total += v
+		// idle and iowait
+		if i == 3 || i == 4 {
+			idle += v
+		}
+	}
+	return idle, total, nil
+}
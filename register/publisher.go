@@ -0,0 +1,143 @@
+package register
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	"github.com/mae-pax/consul-loadbalancer/util"
+)
+
+const PUBLISH_CAS_RETRY = 5
+
+var ErrCASConflict = errors.New("kv check-and-set conflict")
+
+// Publisher samples the local cpu and writes it, every interval, into the
+// InstanceFactor document at key, the one resolvers read with
+// instanceFactorKey. Every instance updates only its own entry with a
+// check-and-set, so many publishers can share the document.
+type Publisher struct {
+	client   *api.Client
+	key      string
+	info     balancer.InstanceMetaInfo
+	interval time.Duration
+	sampler  CPUSampler
+	logger   util.Logger
+	done     chan struct{}
+	exited   chan struct{}
+	stop     sync.Once
+	stopErr  error
+}
+
+func NewPublisher(client *api.Client, key string, info balancer.InstanceMetaInfo, interval time.Duration) *Publisher {
+	return &Publisher{
+		client:   client,
+		key:      key,
+		info:     info,
+		interval: interval,
+		sampler:  &ProcCPUSampler{},
+	}
+}
+
+func (p *Publisher) SetSampler(sampler CPUSampler) {
+	p.sampler = sampler
+}
+
+func (p *Publisher) SetLogger(logger util.Logger) {
+	p.logger = logger
+}
+
+// Start publishes every interval until Stop. It must be called once.
+func (p *Publisher) Start() {
+	p.sampler.Sample()
+	done, exited := make(chan struct{}), make(chan struct{})
+	p.done, p.exited = done, exited
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := p.Publish(); err != nil && p.logger != nil {
+					p.logger.Warnf("publish instance factor failed. key: [%s], err: [%v]", p.key, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops publishing, waiting for a publish in progress, and removes
+// this instance from the document. Later calls return the first result.
+func (p *Publisher) Stop() error {
+	p.stop.Do(func() {
+		if p.done != nil {
+			close(p.done)
+			<-p.exited
+		}
+		p.stopErr = p.update(func(infos []balancer.InstanceMetaInfo) []balancer.InstanceMetaInfo {
+			return removeInstance(infos, p.info.InstanceID)
+		})
+	})
+	return p.stopErr
+}
+
+// Publish samples the cpu once and writes it.
+func (p *Publisher) Publish() error {
+	cpu, err := p.sampler.Sample()
+	if err != nil {
+		return err
+	}
+	info := p.info
+	info.CPUUtilization = cpu
+	return p.update(func(infos []balancer.InstanceMetaInfo) []balancer.InstanceMetaInfo {
+		return append(removeInstance(infos, info.InstanceID), info)
+	})
+}
+
+func (p *Publisher) update(f func([]balancer.InstanceMetaInfo) []balancer.InstanceMetaInfo) error {
+	kv := p.client.KV()
+	for i := 0; i < PUBLISH_CAS_RETRY; i++ {
+		pair, _, err := kv.Get(p.key, nil)
+		if err != nil {
+			return err
+		}
+		var doc balancer.InstanceFactor
+		var index uint64
+		if pair != nil {
+			index = pair.ModifyIndex
+			if err := json.Unmarshal(pair.Value, &doc); err != nil {
+				return err
+			}
+		}
+		doc.Date = f(doc.Date)
+		doc.Updated = time.Now().Unix()
+		value, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		ok, _, err := kv.CAS(&api.KVPair{Key: p.key, Value: value, ModifyIndex: index}, nil)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return ErrCASConflict
+}
+
+func removeInstance(infos []balancer.InstanceMetaInfo, instanceID string) []balancer.InstanceMetaInfo {
+	out := infos[:0]
+	for _, info := range infos {
+		if info.InstanceID != instanceID {
+			out = append(out, info)
+		}
+	}
+	return out
+}
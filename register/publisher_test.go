@@ -0,0 +1,132 @@
+package register_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	"github.com/mae-pax/consul-loadbalancer/register"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeKV serves the KV get and check-and-set of a single key.
+type fakeKV struct {
+	mutex sync.Mutex
+	value []byte
+	index uint64
+	puts  int
+}
+
+func (f *fakeKV) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	key := strings.TrimPrefix(req.URL.Path, "/v1/kv/")
+	switch req.Method {
+	case "GET":
+		if f.value == nil {
+			http.NotFound(w, req)
+			return
+		}
+		json.NewEncoder(w).Encode([]api.KVPair{{Key: key, Value: f.value, ModifyIndex: f.index}})
+	case "PUT":
+		f.puts++
+		cas, _ := strconv.ParseUint(req.URL.Query().Get("cas"), 10, 64)
+		if cas != f.index {
+			w.Write([]byte("false"))
+			return
+		}
+		f.value, _ = ioutil.ReadAll(req.Body)
+		f.index++
+		w.Write([]byte("true"))
+	}
+}
+
+func (f *fakeKV) instances() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var doc balancer.InstanceFactor
+	json.Unmarshal(f.value, &doc)
+	var ids []string
+	for _, info := range doc.Date {
+		ids = append(ids, info.InstanceID)
+	}
+	return ids
+}
+
+// blockingSampler blocks its second call, the first publish after the
+// one of Start, until release is closed.
+type blockingSampler struct {
+	mutex   sync.Mutex
+	n       int
+	calls   chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingSampler) Sample() (float64, error) {
+	s.mutex.Lock()
+	s.n++
+	n := s.n
+	s.mutex.Unlock()
+	if n == 2 {
+		s.calls <- struct{}{}
+		<-s.release
+	}
+	return 50, nil
+}
+
+type fixedSampler float64
+
+func (s fixedSampler) Sample() (float64, error) {
+	return float64(s), nil
+}
+
+func newPublisher(addr string, interval time.Duration) *register.Publisher {
+	config := api.DefaultConfig()
+	config.Address = strings.TrimPrefix(addr, "http://")
+	client, err := api.NewClient(config)
+	So(err, ShouldBeNil)
+	return register.NewPublisher(client, "clb/factor", balancer.InstanceMetaInfo{InstanceID: "i-1"}, interval)
+}
+
+func TestPublisher(t *testing.T) {
+	Convey("Test Publisher", t, func() {
+		f := &fakeKV{}
+		server := httptest.NewServer(f)
+		defer server.Close()
+
+		Convey("Given a publish, the instance is written and Stop removes it once", func() {
+			p := newPublisher(server.URL, time.Hour)
+			p.SetSampler(fixedSampler(50))
+			So(p.Publish(), ShouldBeNil)
+			So(f.instances(), ShouldResemble, []string{"i-1"})
+			p.Start()
+			So(p.Stop(), ShouldBeNil)
+			So(f.instances(), ShouldBeEmpty)
+			puts := f.puts
+			So(p.Stop(), ShouldBeNil)
+			So(f.puts, ShouldEqual, puts)
+		})
+		Convey("Given Stop during a publish, it waits and the instance stays removed", func() {
+			p := newPublisher(server.URL, time.Millisecond)
+			s := &blockingSampler{calls: make(chan struct{}), release: make(chan struct{})}
+			p.SetSampler(s)
+			p.Start()
+			<-s.calls
+			stopped := make(chan error)
+			go func() { stopped <- p.Stop() }()
+			time.Sleep(10 * time.Millisecond)
+			close(s.release)
+			So(<-stopped, ShouldBeNil)
+			time.Sleep(10 * time.Millisecond)
+			So(f.instances(), ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,200 @@
+package register
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	"github.com/mae-pax/consul-loadbalancer/util"
+)
+
+const (
+	DEFAULT_BALANCE_FACTOR = 100
+	DEFAULT_INTERVAL       = 10 * time.Second
+	DEFAULT_TIMEOUT        = time.Second
+	DEFAULT_DEREGISTER     = 90 * time.Minute
+)
+
+// Registration describes the current process as the resolver expects to
+// find it in consul. Zone, InstanceID, Address and BalanceFactor default to
+// the cloud metadata zone, the hostname, the local ip and
+// DEFAULT_BALANCE_FACTOR.
+//
+// Ports registers extra named ports as "port.<name>" meta.
+//
+// The health check is a TTL check when TTL is set, an HTTP check when HTTP
+// is set, and a TCP check on Address:Port otherwise.
+type Registration struct {
+	Cloud         string
+	Service       string
+	Address       string
+	Port          int
+	Ports         map[string]int
+	Tags          []string
+	Zone          string
+	InstanceID    string
+	PublicIP      string
+	BalanceFactor float64
+	Meta          map[string]string
+	// StartTime is published as the start time meta, now if zero.
+	StartTime time.Time
+
+	TTL                            time.Duration
+	HTTP                           string
+	Interval                       time.Duration
+	Timeout                        time.Duration
+	DeregisterCriticalServiceAfter time.Duration
+}
+
+// ID returns the service id, "service-address-port" as the scripts use.
+func (reg *Registration) ID() string {
+	return fmt.Sprintf("%s-%s-%d", reg.Service, reg.Address, reg.Port)
+}
+
+// AgentServiceRegistration fills in the defaults and returns the payload
+// for the consul agent.
+func (reg *Registration) AgentServiceRegistration() *api.AgentServiceRegistration {
+	if reg.Address == "" {
+		reg.Address = LocalIP()
+	}
+	if reg.Zone == "" {
+		reg.Zone = util.Zone(reg.Cloud)
+	}
+	if reg.InstanceID == "" {
+		reg.InstanceID, _ = os.Hostname()
+	}
+	if reg.BalanceFactor <= 0 {
+		reg.BalanceFactor = DEFAULT_BALANCE_FACTOR
+	}
+	if reg.Interval == 0 {
+		reg.Interval = DEFAULT_INTERVAL
+	}
+	if reg.Timeout == 0 {
+		reg.Timeout = DEFAULT_TIMEOUT
+	}
+	if reg.DeregisterCriticalServiceAfter == 0 {
+		reg.DeregisterCriticalServiceAfter = DEFAULT_DEREGISTER
+	}
+
+	if reg.StartTime.IsZero() {
+		reg.StartTime = time.Now()
+	}
+
+	meta := make(map[string]string, len(reg.Meta)+5)
+	for k, v := range reg.Meta {
+		meta[k] = v
+	}
+	for name, port := range reg.Ports {
+		meta[balancer.PORT_META_PREFIX+name] = strconv.Itoa(port)
+	}
+	meta["zone"] = reg.Zone
+	meta["instanceID"] = reg.InstanceID
+	meta["publicIP"] = reg.PublicIP
+	meta["balanceFactor"] = strconv.FormatFloat(reg.BalanceFactor, 'f', -1, 64)
+	meta[balancer.START_TIME_META] = strconv.FormatInt(reg.StartTime.Unix(), 10)
+
+	check := &api.AgentServiceCheck{
+		DeregisterCriticalServiceAfter: reg.DeregisterCriticalServiceAfter.String(),
+	}
+	switch {
+	case reg.TTL > 0:
+		check.Name = "check ttl"
+		check.CheckID = "service:" + reg.ID()
+		check.TTL = reg.TTL.String()
+	case reg.HTTP != "":
+		check.Name = "check http"
+		check.HTTP = reg.HTTP
+		check.Interval = reg.Interval.String()
+		check.Timeout = reg.Timeout.String()
+	default:
+		check.Name = "check port"
+		check.TCP = net.JoinHostPort(reg.Address, strconv.Itoa(reg.Port))
+		check.Interval = reg.Interval.String()
+		check.Timeout = reg.Timeout.String()
+	}
+
+	return &api.AgentServiceRegistration{
+		ID:      reg.ID(),
+		Name:    reg.Service,
+		Tags:    reg.Tags,
+		Address: reg.Address,
+		Port:    reg.Port,
+		Meta:    meta,
+		Check:   check,
+	}
+}
+
+// Registrar registers a Registration with the local consul agent, keeps
+// its TTL check passing and deregisters it on Deregister.
+type Registrar struct {
+	client *api.Client
+	reg    *Registration
+	logger util.Logger
+	mutex  sync.Mutex
+	done   chan struct{}
+}
+
+func NewRegistrar(client *api.Client, reg *Registration) *Registrar {
+	return &Registrar{client: client, reg: reg}
+}
+
+func (r *Registrar) SetLogger(logger util.Logger) {
+	r.logger = logger
+}
+
+func (r *Registrar) Register() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	asr := r.reg.AgentServiceRegistration()
+	if err := r.client.Agent().ServiceRegister(asr); err != nil {
+		return err
+	}
+	if r.logger != nil {
+		r.logger.Infof("register service: %s, id: %s, meta: %v", asr.Name, asr.ID, asr.Meta)
+	}
+	if r.reg.TTL > 0 && r.done == nil {
+		r.done = make(chan struct{})
+		go r.keepAlive(asr.Check.CheckID, r.done)
+	}
+	return nil
+}
+
+func (r *Registrar) keepAlive(checkID string, done chan struct{}) {
+	ticker := time.NewTicker(r.reg.TTL / 2)
+	defer ticker.Stop()
+	for {
+		if err := r.client.Agent().UpdateTTL(checkID, "", api.HealthPassing); err != nil && r.logger != nil {
+			r.logger.Warnf("update ttl failed. checkID: [%s], err: [%v]", checkID, err)
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (r *Registrar) Deregister() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.done != nil {
+		close(r.done)
+		r.done = nil
+	}
+	return r.client.Agent().ServiceDeregister(r.reg.ID())
+}
+
+// LocalIP returns the address of the interface used for outbound traffic.
+func LocalIP() string {
+	conn, err := net.Dial("udp", "8.8.8.8:80")
+	if err != nil {
+		return "127.0.0.1"
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
@@ -0,0 +1,41 @@
+package register_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mae-pax/consul-loadbalancer/balancer"
+	"github.com/mae-pax/consul-loadbalancer/register"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAgentServiceRegistration(t *testing.T) {
+	Convey("Test AgentServiceRegistration", t, func() {
+		reg := &register.Registration{
+			Service:    "as",
+			Address:    "10.0.0.1",
+			Port:       9099,
+			Zone:       "us-east-1a",
+			InstanceID: "i-1",
+		}
+		Convey("Given no balance factor and no check, defaults are filled in", func() {
+			asr := reg.AgentServiceRegistration()
+			So(asr.ID, ShouldEqual, "as-10.0.0.1-9099")
+			So(asr.Meta["zone"], ShouldEqual, "us-east-1a")
+			So(asr.Meta["instanceID"], ShouldEqual, "i-1")
+			So(asr.Meta["balanceFactor"], ShouldEqual, "100")
+			So(asr.Meta[balancer.START_TIME_META], ShouldNotBeEmpty)
+			So(asr.Check.TCP, ShouldEqual, "10.0.0.1:9099")
+			So(asr.Check.Interval, ShouldEqual, "10s")
+		})
+		Convey("Given a TTL, a TTL check is registered", func() {
+			reg.TTL = 5 * time.Second
+			reg.BalanceFactor = 80.5
+			asr := reg.AgentServiceRegistration()
+			So(asr.Meta["balanceFactor"], ShouldEqual, "80.5")
+			So(asr.Check.TTL, ShouldEqual, "5s")
+			So(asr.Check.TCP, ShouldBeEmpty)
+			So(asr.Check.CheckID, ShouldEqual, "service:as-10.0.0.1-9099")
+		})
+	})
+}
@@ -5,6 +5,7 @@ import requests
 import argparse
 import socket
 import subprocess
+import time
 
 
 def local_ip():
@@ -131,6 +132,50 @@ def register(data, consul="localhost:8500"):
     print(json.dumps(data, indent=4))
 
 
+def cpu_times():
+    with open("/proc/stat") as f:
+        fields = [int(v) for v in f.readline().split()[1:]]
+    idle = fields[3] + fields[4]
+    return idle, sum(fields)
+
+
+def cpu_utilization(interval=1):
+    idle1, total1 = cpu_times()
+    time.sleep(interval)
+    idle2, total2 = cpu_times()
+    if total2 == total1:
+        return 0
+    return 100 * (1 - (idle2 - idle1) / (total2 - total1))
+
+
+def backpressure_factor(base, cpu, threshold=80, min_rate=0.1):
+    """scale the advertised factor down linearly from threshold to 100% cpu"""
+    if cpu <= threshold:
+        return base
+    rate = max(min_rate, (100 - cpu) / (100 - threshold))
+    return int(base * rate)
+
+
+def backpressure(service, port, threshold=80, min_rate=0.1, every=10,
+                 balance_factor=None, consul="localhost:8500", **kwargs):
+    """
+    re-register the local instance with a lowered balanceFactor while its own
+    cpu is above threshold, so peers shift traffic away before the kv
+    pipeline catches up
+    """
+    data = payload(service, port, balance_factor=balance_factor, consul=consul, **kwargs)
+    base = float(data["Meta"]["balanceFactor"])
+    last = None
+    while True:
+        cpu = cpu_utilization()
+        factor = backpressure_factor(base, cpu, threshold, min_rate)
+        if factor != last:
+            print("cpu: {:.1f}, balanceFactor: {}".format(cpu, factor))
+            data["Meta"]["balanceFactor"] = str(factor)
+            register(data, consul)
+            last = factor
+        time.sleep(every)
+
 def deregister(service_id="", consul="localhost:8500"):
     url = "http://{}/v1/agent/service/deregister/{}".format(
         consul, service_id)
@@ -205,11 +250,12 @@ def main():
     python3 consul.py kvget consul/as/factor_map.json
     python3 consul.py register as 9099 --factor-map consul/as/factor_map.json
     python3 consul.py register rs 7077 --factor-map consul/rs/factor_map.json
+    python3 consul.py backpressure as 9099 --threshold 80 --every 10
     python3 consul.py services as | jq '.[] | {Service}'
 """,
     )
     parser.add_argument("operation", nargs="?", type=str,
-                        choices=["register", "services",
+                        choices=["register", "backpressure", "services",
                                  "deregister", "kvget", "kvput", "config"],
                         help="operation")
     parser.add_argument("service", nargs="?", type=str,
@@ -229,6 +275,12 @@ def main():
     parser.add_argument("-f", "--factor", type=str, help="balance factor")
     parser.add_argument("-m", "--factor-map", type=str, default="consul/factor_map.json",
                         help="balance factor map consul path")
+    parser.add_argument("--threshold", type=float, default=80,
+                        help="backpressure cpu threshold")
+    parser.add_argument("--min-rate", type=float, default=0.1,
+                        help="backpressure minimum balance factor rate")
+    parser.add_argument("--every", type=int, default=10,
+                        help="backpressure check interval seconds")
     parser.add_argument("-s", "--src", type=str, help="upload file source")
     parser.add_argument("-d", "--dst", type=str, help="upload file kv path")
     args = parser.parse_args()
@@ -248,6 +300,23 @@ def main():
             ),
             args.consul
         )
+    elif args.operation == "backpressure":
+        backpressure(
+            args.service,
+            args.port,
+            threshold=args.threshold,
+            min_rate=args.min_rate,
+            every=args.every,
+            balance_factor=args.factor,
+            consul=args.consul,
+            interval=args.interval,
+            timeout=args.timeout,
+            deregister_critical_service_after=args.deregister,
+            zone=args.zone,
+            instanceID=args.instanceID,
+            publicIP=args.publicIP,
+            fm=args.factor_map,
+        )
     elif args.operation == "services":
         services(args.service, args.consul)
     elif args.operation == "deregister":
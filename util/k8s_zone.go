@@ -0,0 +1,124 @@
+package util
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CLOUD_K8S = "kubernetes"
+
+	K8S_ZONE_LABEL        = "topology.kubernetes.io/zone"
+	K8S_LEGACY_ZONE_LABEL = "failure-domain.beta.kubernetes.io/zone"
+	// K8S_ZONE_ENV holds the zone when it is injected into the pod, e.g.
+	// by an admission webhook copying the node label.
+	K8S_ZONE_ENV = "TOPOLOGY_ZONE"
+	// K8S_LABELS_FILE is the downward API volume file of the pod labels.
+	K8S_LABELS_FILE = "/etc/podinfo/labels"
+	// K8S_NODE_NAME_ENV holds spec.nodeName, exposed with the downward API,
+	// to read the zone label of the node from the API server.
+	K8S_NODE_NAME_ENV   = "NODE_NAME"
+	K8S_SERVICE_ACCOUNT = "/var/run/secrets/kubernetes.io/serviceaccount"
+	// K8S_NODE_TIMEOUT is the default timeout of the node query.
+	K8S_NODE_TIMEOUT = time.Second
+)
+
+// k8sZone reads the topology zone from the environment, the pod labels
+// file or, with the node name and get permission on nodes, the node
+// object, in that order.
+func k8sZone(timeout time.Duration) (string, error) {
+	if zone := os.Getenv(K8S_ZONE_ENV); zone != "" {
+		return zone, nil
+	}
+	if data, err := ioutil.ReadFile(K8S_LABELS_FILE); err == nil {
+		labels := parseLabels(string(data))
+		if zone := zoneLabel(labels); zone != "" {
+			return zone, nil
+		}
+	}
+	zone, err := nodeZone(os.Getenv(K8S_NODE_NAME_ENV), timeout)
+	if err != nil {
+		return "", err
+	}
+	if zone == "" {
+		return "", errors.New("node has no topology zone label")
+	}
+	return zone, nil
+}
+
+// parseLabels parses the key="value" lines of a downward API labels file.
+func parseLabels(data string) map[string]string {
+	labels := make(map[string]string)
+	for _, line := range strings.Split(data, "\n") {
+		i := strings.Index(line, "=")
+		if i < 0 {
+			continue
+		}
+		value, err := strconv.Unquote(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			continue
+		}
+		labels[strings.TrimSpace(line[:i])] = value
+	}
+	return labels
+}
+
+func zoneLabel(labels map[string]string) string {
+	if zone := labels[K8S_ZONE_LABEL]; zone != "" {
+		return zone
+	}
+	return labels[K8S_LEGACY_ZONE_LABEL]
+}
+
+// nodeZone gets the node from the API server with the in-cluster service
+// account and returns its zone label.
+func nodeZone(node string, timeout time.Duration) (string, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if node == "" || host == "" || port == "" {
+		return "", errors.New("not in a cluster or NODE_NAME not set")
+	}
+	token, err := ioutil.ReadFile(K8S_SERVICE_ACCOUNT + "/token")
+	if err != nil {
+		return "", err
+	}
+	ca, err := ioutil.ReadFile(K8S_SERVICE_ACCOUNT + "/ca.crt")
+	if err != nil {
+		return "", err
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, _ := http.NewRequest("GET", "https://"+net.JoinHostPort(host, port)+"/api/v1/nodes/"+node, nil)
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("get node %s: %s", node, resp.Status)
+	}
+	var object struct {
+		Metadata struct {
+			Labels map[string]string `json:"labels"`
+		} `json:"metadata"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&object); err != nil {
+		return "", err
+	}
+	return zoneLabel(object.Metadata.Labels), nil
+}
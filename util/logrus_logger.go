@@ -0,0 +1,36 @@
+package util
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// LogrusLogger logs to a logrus.FieldLogger. It is both a Logger and a
+// StructuredLogger.
+type LogrusLogger struct {
+	l logrus.FieldLogger
+}
+
+func NewLogrusLogger(logger logrus.FieldLogger) *LogrusLogger {
+	return &LogrusLogger{l: logger}
+}
+
+func (l *LogrusLogger) entry(fields []Field) logrus.FieldLogger {
+	if len(fields) == 0 {
+		return l.l
+	}
+	lf := make(logrus.Fields, len(fields))
+	for _, f := range fields {
+		lf[f.Key] = f.Value
+	}
+	return l.l.WithFields(lf)
+}
+
+func (l *LogrusLogger) Debug(msg string, fields ...Field) { l.entry(fields).Debug(msg) }
+func (l *LogrusLogger) Info(msg string, fields ...Field)  { l.entry(fields).Info(msg) }
+func (l *LogrusLogger) Warn(msg string, fields ...Field)  { l.entry(fields).Warn(msg) }
+func (l *LogrusLogger) Error(msg string, fields ...Field) { l.entry(fields).Error(msg) }
+
+func (l *LogrusLogger) Debugf(format string, v ...interface{}) { l.l.Debugf(format, v...) }
+func (l *LogrusLogger) Infof(format string, v ...interface{})  { l.l.Infof(format, v...) }
+func (l *LogrusLogger) Warnf(format string, v ...interface{})  { l.l.Warnf(format, v...) }
+func (l *LogrusLogger) Errorf(format string, v ...interface{}) { l.l.Errorf(format, v...) }
@@ -0,0 +1,69 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const REDACTED = "[redacted]"
+
+var ipPattern = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b|(?:[0-9a-fA-F]{1,4}:){7}[0-9a-fA-F]{1,4}|[0-9a-fA-F]{0,4}(?::[0-9a-fA-F]{1,4})*::(?:[0-9a-fA-F]{1,4}:)*[0-9a-fA-F]{0,4}`)
+
+// RedactLogger masks IP addresses and registered hostnames before passing
+// messages on to the wrapped logger.
+type RedactLogger struct {
+	logger Logger
+	words  *redactWords
+}
+
+type redactWords struct {
+	mutex sync.RWMutex
+	words map[string]bool
+}
+
+func NewRedactLogger(logger Logger) *RedactLogger {
+	return &RedactLogger{logger: logger, words: &redactWords{words: make(map[string]bool)}}
+}
+
+// With returns a RedactLogger for logger sharing the registered words.
+func (l *RedactLogger) With(logger Logger) *RedactLogger {
+	return &RedactLogger{logger: logger, words: l.words}
+}
+
+// AddWords registers strings, such as hostnames, that must not be logged.
+func (l *RedactLogger) AddWords(words ...string) {
+	l.words.mutex.Lock()
+	defer l.words.mutex.Unlock()
+	for _, w := range words {
+		if w != "" {
+			l.words.words[w] = true
+		}
+	}
+}
+
+func (l *RedactLogger) Redact(s string) string {
+	l.words.mutex.RLock()
+	for w := range l.words.words {
+		s = strings.Replace(s, w, REDACTED, -1)
+	}
+	l.words.mutex.RUnlock()
+	return ipPattern.ReplaceAllString(s, REDACTED)
+}
+
+func (l *RedactLogger) Debugf(format string, v ...interface{}) {
+	l.logger.Debugf("%s", l.Redact(fmt.Sprintf(format, v...)))
+}
+
+func (l *RedactLogger) Infof(format string, v ...interface{}) {
+	l.logger.Infof("%s", l.Redact(fmt.Sprintf(format, v...)))
+}
+
+func (l *RedactLogger) Warnf(format string, v ...interface{}) {
+	l.logger.Warnf("%s", l.Redact(fmt.Sprintf(format, v...)))
+}
+
+func (l *RedactLogger) Errorf(format string, v ...interface{}) {
+	l.logger.Errorf("%s", l.Redact(fmt.Sprintf(format, v...)))
+}
@@ -0,0 +1,22 @@
+package util_test
+
+import (
+	"testing"
+
+	"github.com/mae-pax/consul-loadbalancer/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRedactLogger(t *testing.T) {
+	Convey("Test RedactLogger", t, func() {
+		l := util.NewRedactLogger(nil)
+		Convey("IP addresses are masked", func() {
+			So(l.Redact("node 10.0.1.23:3000 selected"), ShouldEqual, "node [redacted]:3000 selected")
+			So(l.Redact("fe80::1:2:3 up"), ShouldEqual, "[redacted] up")
+		})
+		Convey("Registered words are masked and instanceIDs kept", func() {
+			l.AddWords("ip-10-0-1-23.ec2.internal")
+			So(l.Redact("host ip-10-0-1-23.ec2.internal, id i-0abc"), ShouldEqual, "host [redacted], id i-0abc")
+		})
+	})
+}
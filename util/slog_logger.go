@@ -0,0 +1,47 @@
+//go:build go1.21
+// +build go1.21
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// SlogLogger logs to a slog.Logger. It is both a Logger and a
+// StructuredLogger.
+type SlogLogger struct {
+	l *slog.Logger
+}
+
+func NewSlogLogger(logger *slog.Logger) *SlogLogger {
+	return &SlogLogger{l: logger}
+}
+
+func slogArgs(fields []Field) []interface{} {
+	args := make([]interface{}, len(fields))
+	for i, f := range fields {
+		args[i] = slog.Any(f.Key, f.Value)
+	}
+	return args
+}
+
+func (l *SlogLogger) Debug(msg string, fields ...Field) { l.l.Debug(msg, slogArgs(fields)...) }
+func (l *SlogLogger) Info(msg string, fields ...Field)  { l.l.Info(msg, slogArgs(fields)...) }
+func (l *SlogLogger) Warn(msg string, fields ...Field)  { l.l.Warn(msg, slogArgs(fields)...) }
+func (l *SlogLogger) Error(msg string, fields ...Field) { l.l.Error(msg, slogArgs(fields)...) }
+
+// logf formats only when level is enabled, the per-node Debugf lines of
+// the update cycle being the bulk of the calls.
+func (l *SlogLogger) logf(level slog.Level, format string, v []interface{}) {
+	ctx := context.Background()
+	if l.l.Enabled(ctx, level) {
+		l.l.Log(ctx, level, fmt.Sprintf(format, v...))
+	}
+}
+
+func (l *SlogLogger) Debugf(format string, v ...interface{}) { l.logf(slog.LevelDebug, format, v) }
+func (l *SlogLogger) Infof(format string, v ...interface{})  { l.logf(slog.LevelInfo, format, v) }
+func (l *SlogLogger) Warnf(format string, v ...interface{})  { l.logf(slog.LevelWarn, format, v) }
+func (l *SlogLogger) Errorf(format string, v ...interface{}) { l.logf(slog.LevelError, format, v) }
@@ -0,0 +1,24 @@
+//go:build go1.21
+// +build go1.21
+
+package util_test
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/mae-pax/consul-loadbalancer/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSlogLogger(t *testing.T) {
+	Convey("Test SlogLogger", t, func() {
+		var buf bytes.Buffer
+		l := util.NewSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+		l.Info("pool updated", util.F("service", "as"))
+		l.Debugf("node %s", "i-1")
+		So(buf.String(), ShouldContainSubstring, `msg="pool updated" service=as`)
+		So(buf.String(), ShouldNotContainSubstring, "i-1")
+	})
+}
@@ -0,0 +1,69 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Field is a key-value pair attached to a log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F returns the field key=value.
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// StructuredLogger is a leveled logger taking a constant message and
+// fields instead of a format string. The zap, logrus and slog adapters
+// implement it together with Logger, so they can be passed to SetLogger.
+type StructuredLogger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+}
+
+// Printf adapts a StructuredLogger to Logger, logging the formatted line
+// as the message.
+func Printf(logger StructuredLogger) Logger {
+	return printfLogger{logger}
+}
+
+type printfLogger struct {
+	l StructuredLogger
+}
+
+func (l printfLogger) Debugf(format string, v ...interface{}) { l.l.Debug(fmt.Sprintf(format, v...)) }
+func (l printfLogger) Infof(format string, v ...interface{})  { l.l.Info(fmt.Sprintf(format, v...)) }
+func (l printfLogger) Warnf(format string, v ...interface{})  { l.l.Warn(fmt.Sprintf(format, v...)) }
+func (l printfLogger) Errorf(format string, v ...interface{}) { l.l.Error(fmt.Sprintf(format, v...)) }
+
+// Structured adapts a Logger to StructuredLogger, appending the fields to
+// the message as key=value.
+func Structured(logger Logger) StructuredLogger {
+	return structuredLogger{logger}
+}
+
+type structuredLogger struct {
+	l Logger
+}
+
+func (l structuredLogger) Debug(msg string, fields ...Field) { l.l.Debugf("%s", line(msg, fields)) }
+func (l structuredLogger) Info(msg string, fields ...Field)  { l.l.Infof("%s", line(msg, fields)) }
+func (l structuredLogger) Warn(msg string, fields ...Field)  { l.l.Warnf("%s", line(msg, fields)) }
+func (l structuredLogger) Error(msg string, fields ...Field) { l.l.Errorf("%s", line(msg, fields)) }
+
+func line(msg string, fields []Field) string {
+	if len(fields) == 0 {
+		return msg
+	}
+	var b strings.Builder
+	b.WriteString(msg)
+	for _, f := range fields {
+		fmt.Fprintf(&b, " %s=%v", f.Key, f.Value)
+	}
+	return b.String()
+}
@@ -0,0 +1,45 @@
+package util_test
+
+import (
+	"testing"
+
+	"github.com/mae-pax/consul-loadbalancer/util"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestStructuredLogger(t *testing.T) {
+	Convey("Test StructuredLogger", t, func() {
+		Convey("Given a zap logger, fields become zap fields and debug is gated", func() {
+			core, logs := observer.New(zapcore.InfoLevel)
+			l := util.NewZapLogger(zap.New(core))
+			l.Info("pool updated", util.F("service", "as"), util.F("nodes", 3))
+			l.Debugf("node %s", "i-1")
+			l.Warnf("update failed: %s", "boom")
+			entries := logs.AllUntimed()
+			So(entries, ShouldHaveLength, 2)
+			So(entries[0].Message, ShouldEqual, "pool updated")
+			So(entries[0].ContextMap(), ShouldResemble, map[string]interface{}{"service": "as", "nodes": int64(3)})
+			So(entries[1].Message, ShouldEqual, "update failed: boom")
+		})
+		Convey("Given a logrus logger, fields become logrus fields", func() {
+			logger, hook := test.NewNullLogger()
+			l := util.NewLogrusLogger(logger)
+			l.Warn("node ejected", util.F("instance", "i-1"))
+			So(hook.LastEntry().Level, ShouldEqual, logrus.WarnLevel)
+			So(hook.LastEntry().Message, ShouldEqual, "node ejected")
+			So(hook.LastEntry().Data["instance"], ShouldEqual, "i-1")
+		})
+		Convey("Given a printf logger, fields are appended to the message", func() {
+			ring := util.NewRingLogger(10)
+			util.Structured(ring).Info("pool updated", util.F("nodes", 3))
+			util.Printf(util.Structured(ring)).Errorf("update failed: %s", "boom")
+			So(ring.Lines()[0], ShouldContainSubstring, "pool updated nodes=3")
+			So(ring.Lines()[1], ShouldContainSubstring, "update failed: boom")
+		})
+	})
+}
@@ -0,0 +1,49 @@
+package util
+
+import (
+	"sync/atomic"
+)
+
+// NopLogger discards everything.
+type NopLogger struct{}
+
+func (NopLogger) Debugf(format string, v ...interface{}) {}
+func (NopLogger) Infof(format string, v ...interface{})  {}
+func (NopLogger) Warnf(format string, v ...interface{})  {}
+func (NopLogger) Errorf(format string, v ...interface{}) {}
+
+// SwapLogger forwards to a logger that can be replaced while other
+// goroutines are logging.
+type SwapLogger struct {
+	v atomic.Value
+}
+
+// loggerBox keeps the concrete type stored in the atomic.Value the same.
+type loggerBox struct {
+	Logger
+}
+
+// NewSwapLogger forwards to logger, NopLogger when nil.
+func NewSwapLogger(logger Logger) *SwapLogger {
+	l := &SwapLogger{}
+	l.Swap(logger)
+	return l
+}
+
+// Swap forwards to logger from now on, NopLogger when nil.
+func (l *SwapLogger) Swap(logger Logger) {
+	if logger == nil {
+		logger = NopLogger{}
+	}
+	l.v.Store(loggerBox{logger})
+}
+
+// Logger returns the logger currently forwarded to.
+func (l *SwapLogger) Logger() Logger {
+	return l.v.Load().(loggerBox).Logger
+}
+
+func (l *SwapLogger) Debugf(format string, v ...interface{}) { l.Logger().Debugf(format, v...) }
+func (l *SwapLogger) Infof(format string, v ...interface{})  { l.Logger().Infof(format, v...) }
+func (l *SwapLogger) Warnf(format string, v ...interface{})  { l.Logger().Warnf(format, v...) }
+func (l *SwapLogger) Errorf(format string, v ...interface{}) { l.Logger().Errorf(format, v...) }
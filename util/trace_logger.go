@@ -0,0 +1,127 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RingLogger keeps the last size formatted lines in memory.
+type RingLogger struct {
+	mutex sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+func NewRingLogger(size int) *RingLogger {
+	return &RingLogger{lines: make([]string, size)}
+}
+
+func (l *RingLogger) add(level, format string, v ...interface{}) {
+	line := time.Now().Format(time.RFC3339Nano) + " " + level + " " + fmt.Sprintf(format, v...)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.lines[l.next] = line
+	l.next = (l.next + 1) % len(l.lines)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Lines returns the buffered lines, oldest first.
+func (l *RingLogger) Lines() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !l.full {
+		return append([]string(nil), l.lines[:l.next]...)
+	}
+	return append(append([]string(nil), l.lines[l.next:]...), l.lines[:l.next]...)
+}
+
+func (l *RingLogger) Debugf(format string, v ...interface{}) { l.add("DEBUG", format, v...) }
+func (l *RingLogger) Infof(format string, v ...interface{})  { l.add("INFO", format, v...) }
+func (l *RingLogger) Warnf(format string, v ...interface{})  { l.add("WARN", format, v...) }
+func (l *RingLogger) Errorf(format string, v ...interface{}) { l.add("ERROR", format, v...) }
+
+// FileLogger appends lines to a file, rotating it to path.1 .. path.N
+// once it grows past maxSize bytes.
+type FileLogger struct {
+	mutex      sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func NewFileLogger(path string, maxSize int64, maxBackups int) (*FileLogger, error) {
+	l := &FileLogger{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *FileLogger) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file = f
+	l.size = fi.Size()
+	return nil
+}
+
+func (l *FileLogger) rotate() error {
+	l.file.Close()
+	for i := l.maxBackups - 1; i > 0; i-- {
+		os.Rename(l.path+"."+strconv.Itoa(i), l.path+"."+strconv.Itoa(i+1))
+	}
+	if l.maxBackups > 0 {
+		os.Rename(l.path, l.path+".1")
+	} else {
+		os.Remove(l.path)
+	}
+	return l.open()
+}
+
+func (l *FileLogger) add(level, format string, v ...interface{}) {
+	line := time.Now().Format(time.RFC3339Nano) + " " + level + " " + fmt.Sprintf(format, v...) + "\n"
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return
+	}
+	if l.maxSize > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			l.file = nil
+			return
+		}
+	}
+	n, _ := l.file.WriteString(line)
+	l.size += int64(n)
+}
+
+func (l *FileLogger) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func (l *FileLogger) Debugf(format string, v ...interface{}) { l.add("DEBUG", format, v...) }
+func (l *FileLogger) Infof(format string, v ...interface{})  { l.add("INFO", format, v...) }
+func (l *FileLogger) Warnf(format string, v ...interface{})  { l.add("WARN", format, v...) }
+func (l *FileLogger) Errorf(format string, v ...interface{}) { l.add("ERROR", format, v...) }
@@ -0,0 +1,52 @@
+package util_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mae-pax/consul-loadbalancer/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRingLogger(t *testing.T) {
+	Convey("Test RingLogger", t, func() {
+		l := util.NewRingLogger(3)
+		Convey("Given fewer lines than its size, Lines returns all of them", func() {
+			l.Debugf("a %d", 1)
+			l.Infof("b")
+			So(len(l.Lines()), ShouldEqual, 2)
+		})
+		Convey("Given more lines than its size, Lines returns the latest, oldest first", func() {
+			for _, s := range []string{"a", "b", "c", "d"} {
+				l.Debugf(s)
+			}
+			lines := l.Lines()
+			So(len(lines), ShouldEqual, 3)
+			So(lines[0], ShouldEndWith, "DEBUG b")
+			So(lines[2], ShouldEndWith, "DEBUG d")
+		})
+	})
+}
+
+func TestFileLogger(t *testing.T) {
+	Convey("Test FileLogger", t, func() {
+		dir, err := ioutil.TempDir("", "filelogger")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "trace.log")
+		l, err := util.NewFileLogger(path, 100, 2)
+		So(err, ShouldBeNil)
+		Convey("Given writes past maxSize, the file is rotated", func() {
+			for i := 0; i < 10; i++ {
+				l.Infof("line %d", i)
+			}
+			So(l.Close(), ShouldBeNil)
+			_, err := os.Stat(path + ".1")
+			So(err, ShouldBeNil)
+			_, err = os.Stat(path + ".3")
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
@@ -1,23 +1,30 @@
 package util
 
 import (
-	"context"
 	crand "crypto/rand"
-	"io/ioutil"
+	"encoding/json"
 	"math/big"
 	"math/rand"
-	"net/http"
+	"strings"
 	"time"
 )
 
 const (
-	CLOUD_AWS = "aws"
-	CLOUD_ALI = "aliyun"
-	CLOUD_HW  = "huawei"
+	CLOUD_AWS   = "aws"
+	CLOUD_ALI   = "aliyun"
+	CLOUD_HW    = "huawei"
+	CLOUD_GCP   = "gcp"
+	CLOUD_AZURE = "azure"
 
 	API_AWS_META_DATA = "http://169.254.169.254/latest/meta-data/placement/availability-zone"
 	API_ALI_META_DATA = "http://100.100.100.200/latest/meta-data/zone-id"
 	API_HW_META_DATA  = "http://169.254.169.254/latest/meta-data/placement/availability-zone"
+	// API_GCP_META_DATA answers projects/<number>/zones/<zone> and needs the
+	// Metadata-Flavor: Google header.
+	API_GCP_META_DATA = "http://metadata.google.internal/computeMetadata/v1/instance/zone"
+	// API_AZURE_META_DATA answers the compute document of the IMDS and needs
+	// the Metadata: true header.
+	API_AZURE_META_DATA = "http://169.254.169.254/metadata/instance/compute?api-version=2021-02-01"
 )
 
 // Logger for log
@@ -28,29 +35,39 @@ type Logger interface {
 	Errorf(format string, v ...interface{})
 }
 
+// Zone returns the zone of the instance from DefaultZoneProvider(cloud),
+// or "unknown" when it cannot be found.
 func Zone(cloud string) string {
-	var api string
-	switch cloud {
-	case CLOUD_AWS:
-		api = API_AWS_META_DATA
-	case CLOUD_ALI:
-		api = API_ALI_META_DATA
-	case API_HW_META_DATA:
-		api = API_HW_META_DATA
-	default:
-		api = API_AWS_META_DATA
-	}
-
-	ctx, _ := context.WithTimeout(context.Background(), time.Millisecond*20)
-	req, _ := http.NewRequest("GET", api, nil)
-	req = req.WithContext(ctx)
-	resp, err := http.DefaultClient.Do(req)
+	zone, err := DefaultZoneProvider(cloud).Zone()
 	if err != nil {
 		return "unknown"
 	}
-	defer resp.Body.Close()
-	data, _ := ioutil.ReadAll(resp.Body)
-	return string(data)
+	return zone
+}
+
+// NormalizeZone turns the answer of the metadata service of cloud into a
+// zone name: us-central1-a on GCP, and <location>-<zone> such as eastus-1
+// on Azure, or the location alone for instances outside availability
+// zones.
+func NormalizeZone(cloud, data string) string {
+	data = strings.TrimSpace(data)
+	switch cloud {
+	case CLOUD_GCP:
+		return data[strings.LastIndex(data, "/")+1:]
+	case CLOUD_AZURE:
+		var compute struct {
+			Location string `json:"location"`
+			Zone     string `json:"zone"`
+		}
+		if err := json.Unmarshal([]byte(data), &compute); err != nil || compute.Location == "" {
+			return "unknown"
+		}
+		if compute.Zone == "" {
+			return compute.Location
+		}
+		return compute.Location + "-" + compute.Zone
+	}
+	return data
 }
 
 func IntPseudoRandom(min, max int) int {
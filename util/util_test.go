@@ -1,6 +1,8 @@
 package util_test
 
 import (
+	"errors"
+	"os"
 	"testing"
 
 	"github.com/mae-pax/consul-loadbalancer/util"
@@ -26,3 +28,80 @@ func TestIntRandomGenerator(t *testing.T) {
 		})
 	})
 }
+
+func TestNormalizeZone(t *testing.T) {
+	Convey("Test NormalizeZone", t, func() {
+		Convey("Given a GCP answer, the zone is its last path element", func() {
+			So(util.NormalizeZone(util.CLOUD_GCP, "projects/123456/zones/us-central1-a"), ShouldEqual, "us-central1-a")
+		})
+		Convey("Given an Azure compute document, location and zone are joined", func() {
+			So(util.NormalizeZone(util.CLOUD_AZURE, `{"location": "eastus", "zone": "2"}`), ShouldEqual, "eastus-2")
+			So(util.NormalizeZone(util.CLOUD_AZURE, `{"location": "westeurope", "zone": ""}`), ShouldEqual, "westeurope")
+			So(util.NormalizeZone(util.CLOUD_AZURE, `not json`), ShouldEqual, "unknown")
+		})
+		Convey("Given the other clouds, the answer is kept", func() {
+			So(util.NormalizeZone(util.CLOUD_AWS, "us-east-1a\n"), ShouldEqual, "us-east-1a")
+		})
+	})
+}
+
+func TestK8sZone(t *testing.T) {
+	Convey("Test K8sZone", t, func() {
+		Convey("Given the zone injected into the pod, it is used", func() {
+			os.Setenv(util.K8S_ZONE_ENV, "us-east-1b")
+			defer os.Unsetenv(util.K8S_ZONE_ENV)
+			zone, err := util.MetadataZone{Cloud: util.CLOUD_K8S}.Zone()
+			So(err, ShouldBeNil)
+			So(zone, ShouldEqual, "us-east-1b")
+		})
+		Convey("Given no zone anywhere, it fails", func() {
+			_, err := util.MetadataZone{Cloud: util.CLOUD_K8S}.Zone()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+type countingZone struct {
+	calls int
+	zone  string
+}
+
+func (c *countingZone) Zone() (string, error) {
+	c.calls++
+	if c.zone == "" {
+		return "", errors.New("no zone")
+	}
+	return c.zone, nil
+}
+
+func TestZoneProvider(t *testing.T) {
+	Convey("Test ZoneProvider", t, func() {
+		Convey("Given the env override, it wins over the metadata", func() {
+			os.Setenv(util.ZONE_ENV, "zone-x")
+			defer os.Unsetenv(util.ZONE_ENV)
+			zone, err := util.FirstZone(util.EnvZone{}, util.MetadataZone{Cloud: util.CLOUD_AWS}).Zone()
+			So(err, ShouldBeNil)
+			So(zone, ShouldEqual, "zone-x")
+		})
+		Convey("Given failing providers, the next one is asked and the errors are kept", func() {
+			zone, err := util.FirstZone(util.EnvZone{Name: "CONSUL_LB_TEST_ZONE"}, util.StaticZone("b")).Zone()
+			So(err, ShouldBeNil)
+			So(zone, ShouldEqual, "b")
+			_, err = util.FirstZone(util.EnvZone{Name: "CONSUL_LB_TEST_ZONE"}, util.StaticZone("")).Zone()
+			So(err.Error(), ShouldContainSubstring, "CONSUL_LB_TEST_ZONE not set")
+		})
+		Convey("Given a cached provider, a found zone is asked once and a failure again", func() {
+			p := &countingZone{}
+			cached := util.CachedZone(p)
+			_, err := cached.Zone()
+			So(err, ShouldNotBeNil)
+			p.zone = "a"
+			for i := 0; i < 3; i++ {
+				zone, err := cached.Zone()
+				So(err, ShouldBeNil)
+				So(zone, ShouldEqual, "a")
+			}
+			So(p.calls, ShouldEqual, 2)
+		})
+	})
+}
@@ -0,0 +1,34 @@
+package util
+
+import (
+	"go.uber.org/zap"
+)
+
+// ZapLogger logs to a zap.Logger. It is both a Logger and a
+// StructuredLogger.
+type ZapLogger struct {
+	l *zap.Logger
+	s *zap.SugaredLogger
+}
+
+func NewZapLogger(logger *zap.Logger) *ZapLogger {
+	return &ZapLogger{l: logger, s: logger.Sugar()}
+}
+
+func zapFields(fields []Field) []zap.Field {
+	zf := make([]zap.Field, len(fields))
+	for i, f := range fields {
+		zf[i] = zap.Any(f.Key, f.Value)
+	}
+	return zf
+}
+
+func (l *ZapLogger) Debug(msg string, fields ...Field) { l.l.Debug(msg, zapFields(fields)...) }
+func (l *ZapLogger) Info(msg string, fields ...Field)  { l.l.Info(msg, zapFields(fields)...) }
+func (l *ZapLogger) Warn(msg string, fields ...Field)  { l.l.Warn(msg, zapFields(fields)...) }
+func (l *ZapLogger) Error(msg string, fields ...Field) { l.l.Error(msg, zapFields(fields)...) }
+
+func (l *ZapLogger) Debugf(format string, v ...interface{}) { l.s.Debugf(format, v...) }
+func (l *ZapLogger) Infof(format string, v ...interface{})  { l.s.Infof(format, v...) }
+func (l *ZapLogger) Warnf(format string, v ...interface{})  { l.s.Warnf(format, v...) }
+func (l *ZapLogger) Errorf(format string, v ...interface{}) { l.s.Errorf(format, v...) }
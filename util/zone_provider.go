@@ -0,0 +1,184 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ZONE_ENV overrides the detected zone.
+	ZONE_ENV = "CONSUL_LB_ZONE"
+	// DEFAULT_ZONE_TIMEOUT is the default timeout of a metadata query.
+	DEFAULT_ZONE_TIMEOUT = 20 * time.Millisecond
+)
+
+// ZoneProvider finds the zone of the instance.
+type ZoneProvider interface {
+	Zone() (string, error)
+}
+
+// StaticZone is a fixed zone.
+type StaticZone string
+
+func (z StaticZone) Zone() (string, error) {
+	if z == "" {
+		return "", errors.New("empty static zone")
+	}
+	return string(z), nil
+}
+
+// EnvZone reads the zone from the environment variable Name, ZONE_ENV
+// when empty.
+type EnvZone struct {
+	Name string
+}
+
+func (p EnvZone) Zone() (string, error) {
+	name := p.Name
+	if name == "" {
+		name = ZONE_ENV
+	}
+	zone := strings.TrimSpace(os.Getenv(name))
+	if zone == "" {
+		return "", fmt.Errorf("%s not set", name)
+	}
+	return zone, nil
+}
+
+// MetadataZone asks the metadata service of Cloud, one of the CLOUD_*
+// constants and AWS when unknown, or the Kubernetes topology for
+// CLOUD_K8S. Timeout defaults to DEFAULT_ZONE_TIMEOUT, and to
+// K8S_NODE_TIMEOUT for the node query of CLOUD_K8S.
+type MetadataZone struct {
+	Cloud   string
+	Timeout time.Duration
+}
+
+func (p MetadataZone) Zone() (string, error) {
+	timeout := p.Timeout
+	if p.Cloud == CLOUD_K8S {
+		if timeout <= 0 {
+			timeout = K8S_NODE_TIMEOUT
+		}
+		return k8sZone(timeout)
+	}
+	if timeout <= 0 {
+		timeout = DEFAULT_ZONE_TIMEOUT
+	}
+	api := API_AWS_META_DATA
+	header := make(http.Header)
+	switch p.Cloud {
+	case CLOUD_AWS:
+		api = API_AWS_META_DATA
+	case CLOUD_ALI:
+		api = API_ALI_META_DATA
+	case CLOUD_HW:
+		api = API_HW_META_DATA
+	case CLOUD_GCP:
+		api = API_GCP_META_DATA
+		header.Set("Metadata-Flavor", "Google")
+	case CLOUD_AZURE:
+		api = API_AZURE_META_DATA
+		header.Set("Metadata", "true")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, _ := http.NewRequest("GET", api, nil)
+	req.Header = header
+	req = req.WithContext(ctx)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata %s: %s", api, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	zone := NormalizeZone(p.Cloud, string(data))
+	if zone == "" || zone == "unknown" {
+		return "", fmt.Errorf("metadata %s: no zone in %q", api, data)
+	}
+	return zone, nil
+}
+
+// FirstZone returns the zone of the first of providers that finds one.
+func FirstZone(providers ...ZoneProvider) ZoneProvider {
+	return firstZone(providers)
+}
+
+type firstZone []ZoneProvider
+
+func (providers firstZone) Zone() (string, error) {
+	var errs []string
+	for _, p := range providers {
+		zone, err := p.Zone()
+		if err == nil {
+			return zone, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return "", fmt.Errorf("zone not found: %s", strings.Join(errs, "; "))
+}
+
+// CachedZone memoizes the zone found by provider, so the metadata service
+// is asked once. Failures are not cached and ask again.
+func CachedZone(provider ZoneProvider) ZoneProvider {
+	return &cachedZone{provider: provider}
+}
+
+type cachedZone struct {
+	mutex    sync.Mutex
+	provider ZoneProvider
+	zone     string
+}
+
+func (c *cachedZone) Zone() (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.zone != "" {
+		return c.zone, nil
+	}
+	zone, err := c.provider.Zone()
+	if err != nil {
+		return "", err
+	}
+	c.zone = zone
+	return zone, nil
+}
+
+var defaultZones = struct {
+	sync.Mutex
+	providers map[string]ZoneProvider
+}{providers: make(map[string]ZoneProvider)}
+
+// CloudZone returns a provider reading ZONE_ENV, then asking the metadata
+// of cloud with timeout, DEFAULT_ZONE_TIMEOUT when not positive, and
+// caching the zone found.
+func CloudZone(cloud string, timeout time.Duration) ZoneProvider {
+	return CachedZone(FirstZone(EnvZone{}, MetadataZone{Cloud: cloud, Timeout: timeout}))
+}
+
+// DefaultZoneProvider returns the provider used by Zone: ZONE_ENV, then
+// the metadata of cloud, cached for the process.
+func DefaultZoneProvider(cloud string) ZoneProvider {
+	defaultZones.Lock()
+	defer defaultZones.Unlock()
+	p, ok := defaultZones.providers[cloud]
+	if !ok {
+		p = CloudZone(cloud, 0)
+		defaultZones.providers[cloud] = p
+	}
+	return p
+}